@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"SearchBot/internal/models"
+	"SearchBot/internal/reconcile"
+	"SearchBot/internal/storage"
+)
+
+// runCommand runs a CLI subcommand, e.g. `bot reindex -full -100123456`,
+// and returns the process exit code
+func (a *app) runCommand(name string, args []string) int {
+	defer a.close(context.Background())
+
+	switch name {
+	case "reindex":
+		return a.runReindex(args)
+	case "export":
+		return a.runExport(args)
+	case "export-feedback":
+		return a.runExportFeedback(args)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\nAvailable commands:\n"+
+			"  reindex [-full] [chat_id ...]                Re-sync the search index with MongoDB\n"+
+			"  export [-o file] [-newest-first] chat_id     Write a chat's stored messages as JSON lines\n"+
+			"  export-feedback [-o file] [-rating up|down]  Write rated /ask answers as JSON lines\n", name)
+		return 2
+	}
+}
+
+// parseArgs parses flags and returns the remaining arguments. Negative
+// numbers, like group chat IDs, are arguments rather than flags.
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var flagArgs, rest []string
+	for _, arg := range args {
+		if _, err := strconv.ParseInt(arg, 10, 64); err == nil {
+			rest = append(rest, arg)
+		} else {
+			flagArgs = append(flagArgs, arg)
+		}
+	}
+	if err := flags.Parse(flagArgs); err != nil {
+		return nil, err
+	}
+	return append(flags.Args(), rest...), nil
+}
+
+// runReindex reconciles the given chats, or all chats if none are given
+func (a *app) runReindex(args []string) int {
+	flags := flag.NewFlagSet("reindex", flag.ContinueOnError)
+	full := flags.Bool("full", false, "compare message IDs even when the counts match")
+	chatArgs, err := parseArgs(flags, args)
+	if err != nil {
+		return 2
+	}
+
+	// Stop cleanly on Ctrl-C, MongoDB and Meilisearch calls are cancelled
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var reports []reconcile.Report
+	var failed bool
+	if len(chatArgs) == 0 {
+		reports, err = a.reconciler.ReconcileAll(ctx, *full)
+		if err != nil {
+			slog.Error("Reindex failed", "error", err)
+			failed = true
+		}
+	} else {
+		for _, arg := range chatArgs {
+			chatID, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				slog.Error("Invalid chat ID", "arg", arg, "error", err)
+				return 2
+			}
+			report, err := a.reconciler.ReconcileChat(ctx, chatID, *full)
+			if err != nil {
+				slog.Error("Failed to reconcile chat", "chat_id", chatID, "error", err)
+				failed = true
+				continue
+			}
+			reports = append(reports, report)
+		}
+	}
+
+	for _, report := range reports {
+		fmt.Println(report)
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// runExport writes the stored messages of a chat as JSON lines, one message
+// per line, streaming them so chats of any size can be exported
+func (a *app) runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := flags.String("o", "", "write to this file instead of stdout")
+	newestFirst := flags.Bool("newest-first", false, "export the newest messages first")
+	chatArgs, err := parseArgs(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(chatArgs) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: export [-o file] [-newest-first] chat_id")
+		return 2
+	}
+	chatID, err := strconv.ParseInt(chatArgs[0], 10, 64)
+	if err != nil {
+		slog.Error("Invalid chat ID", "arg", chatArgs[0], "error", err)
+		return 2
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			slog.Error("Failed to create export file", "error", err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+	writer := bufio.NewWriter(out)
+	encoder := json.NewEncoder(writer)
+
+	direction := storage.Forward
+	if *newestFirst {
+		direction = storage.Backward
+	}
+
+	// Stop cleanly on Ctrl-C, MongoDB and Meilisearch calls are cancelled
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var exported int
+	err = a.storage.StreamMessages(ctx, chatID, direction, func(msg *models.Message) error {
+		if err := encoder.Encode(msg); err != nil {
+			return err
+		}
+		exported++
+		return nil
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		slog.Error("Export failed", "chat_id", chatID, "exported", exported, "error", err)
+		return 1
+	}
+
+	slog.Info("Exported messages", "chat_id", chatID, "count", exported)
+	return 0
+}
+
+// ratedExample is a rated /ask answer together with the candidate messages
+// that are still stored, ready to be used as an evaluation example
+type ratedExample struct {
+	*models.Answer
+	Messages []models.Message `json:"messages"`
+}
+
+// runExportFeedback writes every rated /ask answer as JSON lines
+func (a *app) runExportFeedback(args []string) int {
+	flags := flag.NewFlagSet("export-feedback", flag.ContinueOnError)
+	output := flags.String("o", "", "write to this file instead of stdout")
+	only := flags.String("rating", "", "only export answers rated up or down at least once")
+	if _, err := parseArgs(flags, args); err != nil {
+		return 2
+	}
+
+	var want int
+	switch *only {
+	case "":
+	case "up":
+		want = models.RatingUp
+	case "down":
+		want = models.RatingDown
+	default:
+		fmt.Fprintln(os.Stderr, "Usage: export-feedback [-o file] [-rating up|down]")
+		return 2
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			slog.Error("Failed to create export file", "error", err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+	writer := bufio.NewWriter(out)
+	encoder := json.NewEncoder(writer)
+
+	// Stop cleanly on Ctrl-C, MongoDB calls are cancelled
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var exported int
+	err := a.storage.StreamRatedAnswers(ctx, func(answer *models.Answer) error {
+		if want != 0 && !hasRating(answer, want) {
+			return nil
+		}
+
+		keys := make([]storage.MessageKey, len(answer.Candidates))
+		for i, ref := range answer.Candidates {
+			keys[i] = storage.MessageKey{ChatID: ref.ChatID, MessageID: ref.MessageID}
+		}
+		messages, err := a.storage.GetMessages(ctx, keys)
+		if err != nil {
+			return err
+		}
+
+		example := ratedExample{Answer: answer, Messages: []models.Message{}}
+		for _, key := range keys {
+			// Messages deleted since are left out
+			if msg, ok := messages[key]; ok {
+				example.Messages = append(example.Messages, msg)
+			}
+		}
+
+		if err := encoder.Encode(example); err != nil {
+			return err
+		}
+		exported++
+		return nil
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		slog.Error("Feedback export failed", "exported", exported, "error", err)
+		return 1
+	}
+
+	slog.Info("Exported rated answers", "count", exported)
+	return 0
+}
+
+// hasRating reports whether anyone gave an answer the rating
+func hasRating(answer *models.Answer, rating int) bool {
+	for _, r := range answer.Ratings {
+		if r.Rating == rating {
+			return true
+		}
+	}
+	return false
+}
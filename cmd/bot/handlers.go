@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"SearchBot/internal/bot"
+	"SearchBot/internal/logging"
+	"SearchBot/internal/models"
+	"SearchBot/internal/query"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// handleUpdate processes a single update
+func (a *app) handleUpdate(ctx context.Context, update tgbotapi.Update) {
+	// Handle bot being added to a group
+	if update.MyChatMember != nil {
+		a.handleChatMemberUpdate(update.MyChatMember)
+		return
+	}
+
+	// Keep cached member roles current
+	if update.ChatMember != nil {
+		a.bot.HandleChatMemberUpdate(ctx, update.ChatMember)
+		return
+	}
+
+	// Handle inline keyboard buttons
+	if update.CallbackQuery != nil {
+		if err := a.bot.HandleCallbackQuery(ctx, update.CallbackQuery); err != nil {
+			slog.ErrorContext(ctx, "Error handling callback query", "error", err)
+		}
+		return
+	}
+
+	// Keep edited messages current, so search results and bookmarks show the new text
+	if update.EditedMessage != nil {
+		if update.EditedMessage.Chat.IsGroup() || update.EditedMessage.Chat.IsSuperGroup() {
+			a.storeEdit(ctx, update.EditedMessage)
+		}
+		return
+	}
+
+	// Handle "@bot <query>" typed in any chat
+	if update.InlineQuery != nil {
+		if err := a.bot.HandleInlineQuery(ctx, update.InlineQuery); err != nil {
+			slog.ErrorContext(ctx, "Error handling inline query", "error", err)
+		}
+		return
+	}
+
+	// Handle messages
+	if update.Message != nil {
+		// Log received message
+		slog.DebugContext(ctx, "Received message", "user_id", update.Message.From.ID,
+			logging.Content("text", update.Message.Text))
+
+		// Remember where users write, for searching their groups in private
+		isGroup := update.Message.Chat.IsGroup() || update.Message.Chat.IsSuperGroup()
+		if isGroup && update.Message.From != nil && update.Message.SenderChat == nil {
+			a.bot.NoteMember(ctx, update.Message.Chat.ID, update.Message.From.ID)
+		}
+
+		// Handle commands
+		if update.Message.IsCommand() {
+			a.handleCommand(ctx, update.Message)
+			return
+		}
+
+		// Handle chat exports uploaded in private chat
+		if update.Message.Chat.IsPrivate() && update.Message.Document != nil {
+			a.startImport(ctx, update.Message)
+			return
+		}
+
+		// Store regular messages
+		if isGroup {
+			a.storeMessage(ctx, update.Message)
+		}
+	}
+}
+
+func (a *app) handleChatMemberUpdate(update *tgbotapi.ChatMemberUpdated) {
+	// Check if the bot was added to a group
+	if update.NewChatMember.User.ID == a.api.Self.ID {
+		switch update.NewChatMember.Status {
+		case "member", "administrator":
+			// Bot was added to group or made admin
+			msg := tgbotapi.NewMessage(update.Chat.ID,
+				"Thanks for adding me! I'll start indexing new messages.\n\n"+
+					"Required permissions:\n"+
+					"- Read Messages\n"+
+					"- Send Messages\n\n"+
+					bot.ImportInstructions+"\n\n"+
+					"Use /help to see available commands.")
+			if _, err := a.api.Send(msg); err != nil {
+				slog.Error("Error sending welcome message", "chat_id", update.Chat.ID, "error", err)
+			}
+		}
+	}
+}
+
+func (a *app) handleCommand(ctx context.Context, message *tgbotapi.Message) {
+	ctx = logging.With(ctx, "command", message.Command())
+
+	// Check the chat's policy for this command
+	if !a.bot.Authorize(ctx, message) {
+		return
+	}
+	// Keep single users from flooding expensive commands
+	if !a.bot.CheckRateLimit(ctx, message) {
+		return
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, "")
+
+	switch message.Command() {
+	case "start":
+		msg.Text = "Hello! I'm a search bot. I can help you find messages in this group. Use /help to see available commands."
+	case "help":
+		msg.Text = fmt.Sprintf(`Available commands:
+/search <query> - Search for messages
+/ask <question> - Ask a question about past messages
+/status - Check bot permissions and status
+/reindex - Re-sync the search index with stored messages (admins only)
+/settings - Change this chat's settings (admins only)
+/permissions - Choose who may use each command (admins only)
+/usage - Show this chat's AI usage (admins only)
+/watch <query> - Get a private message about new matches (private chat)
+/unwatch <number> - Stop a watch (private chat)
+/save [tags] - Reply to a message to bookmark it
+/saved [tag|query] - List your bookmarks (private chat)
+/unsave - Remove a bookmark
+/help - Show this help message
+
+In a private chat, /search and /ask look through every group we're both in. You can also type @%s <query> in any chat.
+
+To index older history, send me a Telegram Desktop JSON export (result.json) in a private chat.`, a.api.Self.UserName)
+	case "status":
+		if message.Chat.IsGroup() || message.Chat.IsSuperGroup() {
+			// Get bot's member info in the group
+			chatMemberConfig := tgbotapi.GetChatMemberConfig{
+				ChatConfigWithUser: tgbotapi.ChatConfigWithUser{
+					ChatID: message.Chat.ID,
+					UserID: a.api.Self.ID,
+				},
+			}
+
+			member, err := a.api.GetChatMember(chatMemberConfig)
+			if err != nil {
+				msg.Text = "Error checking bot status."
+				slog.ErrorContext(ctx, "Error getting bot member info", "error", err)
+			} else {
+				msg.Text = fmt.Sprintf("Bot Status in this group:\n"+
+					"Role: %s\n", member.Status)
+
+				if member.Status == "administrator" {
+					msg.Text += "✅ Bot is properly configured with admin access.\n"
+				} else {
+					msg.Text += "❌ Bot needs to be an administrator to access messages.\n" +
+						"Please make me an administrator with these permissions:\n" +
+						"- Read Messages\n" +
+						"- Send Messages"
+				}
+			}
+		} else {
+			msg.Text = "This command only works in groups."
+		}
+	case "search":
+		// In private chats, search every group the caller belongs to
+		if message.Chat.IsPrivate() {
+			if err := a.bot.HandlePrivateSearch(ctx, message); err != nil {
+				slog.ErrorContext(ctx, "Error handling private search", "error", err)
+			}
+			return
+		}
+		q := query.Parse(message.CommandArguments())
+		if q.IsEmpty() {
+			msg.Text = "Please provide a search query. Example: /search golang\n\n" + query.Syntax
+		} else {
+			limit := int64(a.bot.Settings(ctx, message.Chat.ID).ResultLimit)
+			results, err := a.search.Find(ctx, []int64{message.Chat.ID}, q, limit)
+			if err != nil {
+				msg.Text = "Sorry, an error occurred while searching."
+				slog.ErrorContext(ctx, "Search failed", "error", err)
+			} else if len(results) == 0 {
+				msg.Text = "No messages found matching your query."
+			} else {
+				msg.Text = "Found messages:\n\n"
+				for _, result := range results {
+					msg.Text += "From " + result.Author() + ":\n" + result.Text + "\n\n"
+				}
+			}
+		}
+	case "ask":
+		if err := a.bot.HandleAskCommand(ctx, message); err != nil {
+			slog.ErrorContext(ctx, "Error handling ask command", "error", err)
+			msg.Text = "Sorry, an error occurred while processing your question."
+		}
+		return
+	case "settings":
+		if err := a.bot.HandleSettingsCommand(ctx, message); err != nil {
+			slog.ErrorContext(ctx, "Error handling settings command", "error", err)
+		}
+		return
+	case "permissions":
+		if err := a.bot.HandlePermissionsCommand(ctx, message); err != nil {
+			slog.ErrorContext(ctx, "Error handling permissions command", "error", err)
+		}
+		return
+	case "watch":
+		if err := a.bot.HandleWatchCommand(ctx, message); err != nil {
+			slog.ErrorContext(ctx, "Error handling watch command", "error", err)
+			msg.Text = "Sorry, an error occurred while saving your watch."
+			break
+		}
+		return
+	case "unwatch":
+		if err := a.bot.HandleUnwatchCommand(ctx, message); err != nil {
+			slog.ErrorContext(ctx, "Error handling unwatch command", "error", err)
+			msg.Text = "Sorry, an error occurred while removing your watch."
+			break
+		}
+		return
+	case "save":
+		if err := a.bot.HandleSaveCommand(ctx, message); err != nil {
+			slog.ErrorContext(ctx, "Error handling save command", "error", err)
+			msg.Text = "Sorry, an error occurred while saving the message."
+			break
+		}
+		return
+	case "saved":
+		if err := a.bot.HandleSavedCommand(ctx, message); err != nil {
+			slog.ErrorContext(ctx, "Error handling saved command", "error", err)
+			msg.Text = "Sorry, an error occurred while loading your bookmarks."
+			break
+		}
+		return
+	case "unsave":
+		if err := a.bot.HandleUnsaveCommand(ctx, message); err != nil {
+			slog.ErrorContext(ctx, "Error handling unsave command", "error", err)
+			msg.Text = "Sorry, an error occurred while removing the bookmark."
+			break
+		}
+		return
+	case "usage":
+		if err := a.bot.HandleUsageCommand(ctx, message); err != nil {
+			slog.ErrorContext(ctx, "Error handling usage command", "error", err)
+		}
+		return
+	case "reindex":
+		if !message.Chat.IsGroup() && !message.Chat.IsSuperGroup() {
+			msg.Text = "This command only works in groups."
+		} else if a.startReindex(ctx, message.Chat.ID) {
+			msg.Text = "🔄 Re-syncing the search index with stored messages..."
+		} else {
+			msg.Text = "🔄 The search index of this chat is already being re-synced."
+		}
+	default:
+		msg.Text = "Unknown command. Use /help to see available commands."
+	}
+
+	if _, err := a.api.Send(msg); err != nil {
+		slog.ErrorContext(ctx, "Error sending message", "error", err)
+	}
+}
+
+// startReindex re-syncs a chat's search index in the background and reports
+// the result to the chat. It returns false if a re-sync of the chat is
+// already running.
+func (a *app) startReindex(ctx context.Context, chatID int64) bool {
+	a.reindexMu.Lock()
+	defer a.reindexMu.Unlock()
+	if a.reindexing[chatID] {
+		return false
+	}
+	a.reindexing[chatID] = true
+
+	// Keep the update's trace but outlive its deadline, the reply is sent
+	// later. Shutdown cancels the re-sync and waits for it.
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stopCancel := context.AfterFunc(a.lifetime, cancel)
+
+	a.goBackground(func() {
+		defer func() {
+			stopCancel()
+			cancel()
+			a.reindexMu.Lock()
+			delete(a.reindexing, chatID)
+			a.reindexMu.Unlock()
+		}()
+
+		text := "✅ Search index is in sync."
+		report, err := a.reconciler.ReconcileChat(ctx, chatID, true)
+		if ctx.Err() != nil {
+			text = "⚠️ The re-sync was interrupted by a restart. Please run /reindex again."
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error reconciling chat", "error", err)
+			text = "❌ Failed to re-sync the search index. Please try again later."
+		} else if report.Reindexed > 0 || report.Removed > 0 {
+			text = fmt.Sprintf("✅ Search index re-synced: %d messages re-indexed, %d stale entries removed.",
+				report.Reindexed, report.Removed)
+		}
+		if _, err := a.api.Send(tgbotapi.NewMessage(chatID, text)); err != nil {
+			slog.ErrorContext(ctx, "Error sending reindex result", "error", err)
+		}
+	})
+	return true
+}
+
+// startImport imports an uploaded chat export in the background, as large
+// exports take longer than an update may
+func (a *app) startImport(ctx context.Context, message *tgbotapi.Message) {
+	// Keep the update's trace but not its deadline. Shutdown cancels the
+	// import and waits for it, messages stored so far are kept.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), importTimeout)
+	stopCancel := context.AfterFunc(a.lifetime, cancel)
+
+	a.goBackground(func() {
+		defer func() {
+			stopCancel()
+			cancel()
+		}()
+
+		if err := a.bot.HandleExportUpload(ctx, message); err != nil {
+			slog.ErrorContext(ctx, "Error handling export upload", "error", err)
+		}
+	})
+}
+
+func (a *app) storeMessage(ctx context.Context, message *tgbotapi.Message) error {
+	msg, err := a.saveMessage(ctx, message)
+	if err != nil {
+		return err
+	}
+
+	// Tell users whose saved searches match
+	a.bot.NotifyWatchers(ctx, msg)
+
+	slog.DebugContext(ctx, "Processed message", "message_id", msg.MessageID)
+	return nil
+}
+
+// storeEdit replaces a stored message with its edited version. Edits don't
+// trigger watches, so fixing a typo doesn't alert anyone twice.
+func (a *app) storeEdit(ctx context.Context, message *tgbotapi.Message) error {
+	msg, err := a.saveMessage(ctx, message)
+	if err != nil {
+		return err
+	}
+
+	slog.DebugContext(ctx, "Processed edited message", "message_id", msg.MessageID)
+	return nil
+}
+
+// saveMessage stores a message in MongoDB and queues it for indexing
+func (a *app) saveMessage(ctx context.Context, message *tgbotapi.Message) (*models.Message, error) {
+	// Create message model
+	msg := &models.Message{
+		MessageID:    int64(message.MessageID),
+		ChatID:       message.Chat.ID,
+		ChatUsername: message.Chat.UserName,
+		UserID:       message.From.ID,
+		Username:     message.From.UserName,
+		Text:         message.Text,
+		CreatedAt:    message.Time(),
+	}
+	if message.EditDate != 0 {
+		msg.EditedAt = time.Unix(int64(message.EditDate), 0)
+	}
+
+	// Store in MongoDB
+	if err := a.storage.StoreMessage(ctx, msg); err != nil {
+		slog.ErrorContext(ctx, "Failed to store message", "message_id", msg.MessageID, "error", err)
+		return nil, err
+	}
+
+	// Queue for batched indexing in Meilisearch
+	if err := a.indexer.Add(ctx, msg); err != nil {
+		slog.ErrorContext(ctx, "Failed to queue message for indexing", "message_id", msg.MessageID, "error", err)
+		return nil, err
+	}
+
+	return msg, nil
+}
@@ -2,294 +2,466 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
+	"SearchBot/internal/access"
 	"SearchBot/internal/ai"
+	"SearchBot/internal/answer"
 	"SearchBot/internal/bot"
-	"SearchBot/internal/models"
+	"SearchBot/internal/config"
+	"SearchBot/internal/dispatch"
+	"SearchBot/internal/health"
+	"SearchBot/internal/logging"
+	"SearchBot/internal/metrics"
+	"SearchBot/internal/ratelimit"
+	"SearchBot/internal/reconcile"
+	"SearchBot/internal/retention"
 	"SearchBot/internal/search"
 	"SearchBot/internal/storage"
+	"SearchBot/internal/tracing"
+	"SearchBot/internal/webhook"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/joho/godotenv"
-	"github.com/meilisearch/meilisearch-go"
 )
 
-var (
-	mongoStorage storage.MessageStorage
-	meiliSearch  *search.MeiliSearch
-	geminiAI     *ai.GeminiAI
-	searchBot    *bot.Bot
-)
+// pollRetryDelay is how long to wait after a failed poll
+const pollRetryDelay = 3 * time.Second
+
+// connectTimeout is how long connecting to MongoDB may take at startup
+const connectTimeout = 30 * time.Second
+
+// importTimeout is how long importing an uploaded chat export may take
+const importTimeout = 30 * time.Minute
+
+// app holds the bot's dependencies
+type app struct {
+	cfg        *config.Config
+	api        *tgbotapi.BotAPI
+	storage    *storage.MongoDB
+	search     *search.MeiliSearch
+	indexer    *search.Indexer
+	reconciler *reconcile.Reconciler
+	retention  *retention.Enforcer
+	ai         *ai.GeminiAI
+	prompts    *answer.Prompts
+	bot        *bot.Bot
+
+	opsServer   *http.Server
+	stopTracing func(context.Context) error
+
+	lifetime   context.Context // Canceled when shutdown starts
+	background sync.WaitGroup  // Work that shutdown waits for before closing clients
+	reindexMu  sync.Mutex
+	reindexing map[int64]bool // Chats with a /reindex in progress
+}
 
-func init() {
+func main() {
 	// Load .env file
 	if err := godotenv.Load(); err != nil {
-		log.Printf("Warning: .env file not found")
+		slog.Warn(".env file not found")
 	}
 
-	// Initialize MongoDB
-	mongoURI := os.Getenv("MONGODB_URI")
-	if mongoURI == "" {
-		log.Fatal("MONGODB_URI is not set in .env file")
+	cfg, args, err := config.Load(os.Args[1:], os.LookupEnv)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		// Logging isn't set up yet, and the problems read best one per line
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
-	log.Printf("Connecting to MongoDB...")
+	if _, err := logging.Setup(os.Stderr, logging.Config{
+		Level:         cfg.Log.Level,
+		Format:        cfg.Log.Format,
+		RedactContent: cfg.Log.RedactContent,
+		Secrets:       cfg.Secrets(),
+	}); err != nil {
+		fatal("Invalid logging configuration", "error", err)
+	}
 
-	// Initialize MongoDB storage with longer timeout
-	mongoStore, err := storage.NewMongoDB(mongoURI, "telegram_bot", "messages")
+	a, err := newApp(cfg)
 	if err != nil {
-		log.Fatal("Failed to connect to MongoDB:", err)
+		fatal("Failed to start", "error", err)
 	}
-	mongoStorage = mongoStore
-	log.Printf("Successfully connected to MongoDB")
 
-	// Initialize Meilisearch
-	meiliHost := os.Getenv("MEILI_HOST")
-	log.Printf("Meilisearch Host from env: %s", meiliHost)
-	if meiliHost == "" {
-		meiliHost = "http://localhost:7700"
-		log.Printf("Warning: MEILI_HOST not set, using default: %s", meiliHost)
+	// Run CLI subcommands instead of the bot if requested
+	if len(args) > 0 {
+		os.Exit(a.runCommand(args[0], args[1:]))
+	}
+
+	a.run()
+}
+
+// fatal logs an error and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// newApp connects to all backends described by cfg
+func newApp(cfg *config.Config) (*app, error) {
+	a := &app{cfg: cfg, lifetime: context.Background(), reindexing: make(map[int64]bool)}
+
+	stopTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+		ServiceName: cfg.Tracing.ServiceName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up tracing: %v", err)
+	}
+	a.stopTracing = stopTracing
+	if cfg.Tracing.Exporter != "none" {
+		slog.Info("Tracing enabled", "exporter", cfg.Tracing.Exporter, "sample_ratio", cfg.Tracing.SampleRatio)
+	}
+
+	slog.Info("Connecting to MongoDB")
+
+	// Initialize MongoDB storage with longer timeout
+	connectCtx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	mongoStore, err := storage.NewMongoDB(connectCtx, cfg.MongoDB.URI, cfg.MongoDB.Database, cfg.MongoDB.Collection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MongoDB: %v", err)
 	}
-	meiliKey := os.Getenv("MEILI_KEY")
-	log.Printf("Meilisearch Key length: %d", len(meiliKey))
-	if meiliKey == "" {
-		log.Printf("Warning: MEILI_KEY not set")
+	a.storage = mongoStore
+	slog.Info("Connected to MongoDB")
+
+	// Bring the schema up to date before anything reads or writes messages,
+	// refusing databases written by a newer version. Index builds and
+	// migrations can take a while on large databases, so they get no deadline.
+	if err := mongoStore.EnsureSchema(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to prepare MongoDB schema: %v", err)
 	}
-	meiliSearch = search.NewMeiliSearch(meiliHost, meiliKey, "messages")
-	log.Printf("Initialized Meilisearch with host: %s", meiliHost)
 
-	// Initialize Gemini AI
-	geminiKey := os.Getenv("GEMINI_API_KEY")
-	if geminiKey == "" {
-		log.Fatal("GEMINI_API_KEY is not set in .env file")
+	// Initialize Meilisearch
+	a.search = search.NewMeiliSearch(cfg.Meilisearch.Host, cfg.Meilisearch.Key, cfg.Meilisearch.Index)
+	slog.Info("Initialized Meilisearch", "host", cfg.Meilisearch.Host)
+
+	// Initialize the batched indexing pipeline
+	indexerConfig := search.DefaultIndexerConfig()
+	indexerConfig.BatchSize = cfg.Indexer.BatchSize
+	indexerConfig.FlushInterval = cfg.Indexer.FlushInterval
+	a.indexer = search.NewIndexer(a.search, mongoStore, indexerConfig)
+	slog.Info("Started indexer", "batch_size", indexerConfig.BatchSize, "flush_interval", indexerConfig.FlushInterval)
+
+	a.reconciler = reconcile.NewReconciler(mongoStore, a.search)
+	a.retention = retention.NewEnforcer(mongoStore, mongoStore, mongoStore, a.search)
+
+	return a, nil
+}
+
+// newAI sets up answering /ask, which only the bot needs. Subcommands run
+// without a Gemini API key.
+func (a *app) newAI() error {
+	var err error
+	a.ai, err = ai.NewGeminiAI(a.cfg.Gemini.APIKey, a.cfg.Gemini.Model)
+	if err != nil {
+		return fmt.Errorf("failed to initialize Gemini AI: %v", err)
 	}
 
-	// Initialize Gemini AI client
-	geminiClient, err := ai.NewGeminiAI(geminiKey)
+	// Fail early on broken prompt templates rather than on the first /ask
+	a.prompts, err = answer.LoadPrompts(a.cfg.Prompts.Dir, a.cfg.Prompts.Default)
 	if err != nil {
-		log.Fatal("Failed to initialize Gemini AI:", err)
+		return fmt.Errorf("failed to load prompts: %v", err)
 	}
-	geminiAI = geminiClient
+	slog.Info("Loaded prompts", "prompts", a.prompts.Names(), "default", a.prompts.Default())
+	return nil
 }
 
-func main() {
-	// Get bot token from environment variable
-	botToken := os.Getenv("TELEGRAM_BOT_TOKEN")
-	if botToken == "" {
-		log.Fatal("TELEGRAM_BOT_TOKEN is not set in .env file")
+// run connects to Telegram and handles updates until SIGINT or SIGTERM
+func (a *app) run() {
+	if err := a.newAI(); err != nil {
+		fatal("Failed to start", "error", err)
+	}
+
+	// Send the Telegram library's logs through slog
+	if err := tgbotapi.SetLogger(logging.BotLogger{}); err != nil {
+		slog.Warn("Failed to set Telegram logger", "error", err)
 	}
 
 	// Initialize bot API
-	api, err := tgbotapi.NewBotAPI(botToken)
+	api, err := tgbotapi.NewBotAPI(a.cfg.Telegram.Token)
 	if err != nil {
-		log.Fatal(err)
+		fatal("Failed to connect to Telegram", "error", err)
 	}
+	a.api = api
 
-	api.Debug = true
-	log.Printf("Authorized on account %s", api.Self.UserName)
+	// Debug mode logs raw updates, including the text of every message
+	api.Debug = a.cfg.Telegram.Debug
+	if api.Debug && logging.RedactsContent() {
+		slog.Warn("Ignoring Telegram debug mode because message content is redacted from logs")
+		api.Debug = false
+	}
+	slog.Info("Authorized on Telegram", "account", api.Self.UserName)
 
 	// Create bot instance
-	searchBot = bot.NewBot(api, geminiAI, meiliSearch, mongoStorage)
+	a.bot = bot.NewBot(api, a.ai, a.prompts, a.search, a.indexer, a.storage, a.storage, a.storage, a.storage, a.storage, a.storage, a.storage,
+		access.NewChecker(api, access.DefaultRoleTTL), bot.Limits{
+			Commands: map[string]*ratelimit.Limiter{
+				"ask":    ratelimit.NewLimiter(ratelimit.Config{PerUser: a.cfg.Limits.AskPerUser, PerChat: a.cfg.Limits.AskPerChat}),
+				"search": ratelimit.NewLimiter(ratelimit.Config{PerUser: a.cfg.Limits.SearchPerUser, PerChat: a.cfg.Limits.SearchPerChat}),
+			},
+			Inline:          ratelimit.NewLimiter(ratelimit.Config{PerUser: a.cfg.Limits.InlinePerUser}),
+			DailyTokenQuota: a.cfg.Limits.DailyAITokens,
+		})
+
+	// Load saved searches so new messages can be matched against them
+	loadCtx, cancelLoad := context.WithTimeout(context.Background(), connectTimeout)
+	err = a.bot.LoadWatches(loadCtx)
+	cancelLoad()
+	if err != nil {
+		fatal("Failed to load watches", "error", err)
+	}
 
 	// Set up updates configuration
 	updateConfig := tgbotapi.NewUpdate(0)
-	updateConfig.Timeout = 60
-	updateConfig.AllowedUpdates = []string{"message", "channel_post", "my_chat_member"}
-
-	// Get updates channel
-	updates := api.GetUpdatesChan(updateConfig)
+	updateConfig.Timeout = int(a.cfg.Telegram.PollTimeout.Seconds())
+	updateConfig.AllowedUpdates = []string{"message", "edited_message", "channel_post", "my_chat_member", "chat_member", "callback_query", "inline_query"}
+
+	// Stop on SIGINT/SIGTERM, e.g. during a deploy
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	a.lifetime = ctx
+
+	// Periodically bring the search index back in sync with MongoDB and
+	// delete messages past each chat's retention period. A run in progress
+	// is cancelled once we're asked to stop.
+	if a.cfg.ReconcileInterval > 0 {
+		a.goBackground(func() { a.reconciler.RunPeriodically(ctx, a.cfg.ReconcileInterval) })
+	}
+	if a.cfg.RetentionInterval > 0 {
+		a.goBackground(func() { a.retention.RunPeriodically(ctx, a.cfg.RetentionInterval) })
+	}
 
-	// Handle updates
-	for update := range updates {
-		// Handle bot being added to a group
-		if update.MyChatMember != nil {
-			handleChatMemberUpdate(api, update.MyChatMember)
-			continue
-		}
+	// Pick up watches added through other instances
+	a.goBackground(func() { a.bot.RefreshWatches(ctx) })
+
+	// Handle updates concurrently, one worker per chat at a time
+	dispatcher := dispatch.NewDispatcher(a.handleUpdate, dispatch.Config{
+		Workers:        a.cfg.Workers.Count,
+		QueueSize:      a.cfg.Workers.QueueSize,
+		HandlerTimeout: a.cfg.Workers.HandlerTimeout,
+	})
+	slog.Info("Started update workers", "workers", a.cfg.Workers.Count)
+
+	// Serve metrics and health checks. Polling must keep succeeding for the
+	// bot to count as alive.
+	var pollHeartbeat *health.Heartbeat
+	if a.cfg.Telegram.Mode != "webhook" {
+		pollHeartbeat = health.NewHeartbeat()
+	}
+	metrics.RegisterQueueDepth(dispatcher.QueueDepth)
+	a.opsServer = a.startOpsServer(a.healthChecker(pollHeartbeat))
+
+	// Receive updates until we're asked to stop
+	var stopReceiving func(ctx context.Context)
+	switch a.cfg.Telegram.Mode {
+	case "webhook":
+		stopReceiving = a.receiveByWebhook(ctx, stop, updateConfig, dispatcher)
+	default:
+		stopReceiving = a.receiveByPolling(ctx, updateConfig, dispatcher, pollHeartbeat)
+	}
 
-		// Handle messages
-		if update.Message != nil {
-			// Log received message
-			log.Printf("[%s] %s", update.Message.From.UserName, update.Message.Text)
+	a.shutdown(stopReceiving, dispatcher)
+}
 
-			// Handle commands
-			if update.Message.IsCommand() {
-				handleCommand(api, update.Message)
-				continue
-			}
+// receiveByPolling long-polls Telegram for updates until ctx is done and
+// returns a function that waits for the last poll to finish. Every successful
+// poll beats heartbeat.
+func (a *app) receiveByPolling(ctx context.Context, updateConfig tgbotapi.UpdateConfig, dispatcher *dispatch.Dispatcher, heartbeat *health.Heartbeat) func(context.Context) {
+	// getUpdates is rejected while a webhook is registered
+	if _, err := a.api.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		slog.Error("Error removing webhook", "error", err)
+	}
 
-			// Store regular messages
-			if update.Message.Chat.IsGroup() || update.Message.Chat.IsSuperGroup() {
-				storeMessage(update.Message)
-			}
+	// Abort the poll in flight on shutdown instead of waiting for it to time
+	// out, so draining the dispatcher gets the whole shutdown timeout
+	poller := *a.api
+	poller.Client = &cancelableClient{ctx: ctx, client: a.api.Client}
+
+	slog.Info("Receiving updates by long polling")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.poll(ctx, &poller, updateConfig, dispatcher, heartbeat)
+	}()
+
+	<-ctx.Done()
+
+	// The aborted poll's updates were never confirmed and are redelivered on
+	// the next start
+	return func(ctx context.Context) {
+		select {
+		case <-done:
+		case <-ctx.Done():
 		}
 	}
 }
 
-func handleChatMemberUpdate(api *tgbotapi.BotAPI, update *tgbotapi.ChatMemberUpdated) {
-	// Check if the bot was added to a group
-	if update.NewChatMember.User.ID == api.Self.ID {
-		switch update.NewChatMember.Status {
-		case "member", "administrator":
-			// Bot was added to group or made admin
-			msg := tgbotapi.NewMessage(update.Chat.ID,
-				"Thanks for adding me! I'll start indexing recent messages.\n\n"+
-					"Required permissions:\n"+
-					"- Read Messages\n"+
-					"- Send Messages\n\n"+
-					"Use /help to see available commands.")
-			if _, err := api.Send(msg); err != nil {
-				log.Printf("Error sending welcome message: %v", err)
+// poll fetches updates and hands them to the dispatcher until ctx is done
+func (a *app) poll(ctx context.Context, api *tgbotapi.BotAPI, updateConfig tgbotapi.UpdateConfig, dispatcher *dispatch.Dispatcher, heartbeat *health.Heartbeat) {
+	for ctx.Err() == nil {
+		updates, err := api.GetUpdates(updateConfig)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Warn("Error polling for updates", "retry_in", pollRetryDelay, "error", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(pollRetryDelay):
 			}
+			continue
+		}
+		heartbeat.Beat()
 
-			// Fetch recent messages if bot is admin
-			if update.NewChatMember.Status == "administrator" {
-				go func() {
-					// Get chat history using our helper
-					messages, err := searchBot.GetChatHistory(update.Chat.ID, 100)
-					if err != nil {
-						log.Printf("Error fetching chat history: %v", err)
-						errorMsg := tgbotapi.NewMessage(update.Chat.ID,
-							"❌ Failed to fetch chat history. Please make sure I have the correct permissions.")
-						if _, err := api.Send(errorMsg); err != nil {
-							log.Printf("Error sending error message: %v", err)
-						}
-						return
-					}
-
-					// Store messages in reverse order (oldest first)
-					storedCount := 0
-					for i := len(messages) - 1; i >= 0; i-- {
-						msg := messages[i]
-						if msg.Text != "" { // Only store text messages
-							storeMessage(&msg)
-							storedCount++
-						}
-					}
-
-					// Send confirmation
-					confirmMsg := tgbotapi.NewMessage(update.Chat.ID,
-						fmt.Sprintf("✅ Successfully indexed %d text messages from the chat history.", storedCount))
-					if _, err := api.Send(confirmMsg); err != nil {
-						log.Printf("Error sending confirmation message: %v", err)
-					}
-				}()
+		for _, update := range updates {
+			if update.UpdateID >= updateConfig.Offset {
+				updateConfig.Offset = update.UpdateID + 1
+			}
+			if err := dispatcher.Submit(ctx, update); err != nil {
+				slog.Error("Error dispatching update", "update_id", update.UpdateID, "error", err)
 			}
 		}
 	}
 }
 
-func handleCommand(api *tgbotapi.BotAPI, message *tgbotapi.Message) {
-	msg := tgbotapi.NewMessage(message.Chat.ID, "")
-
-	switch message.Command() {
-	case "start":
-		msg.Text = "Hello! I'm a search bot. I can help you find messages in this group. Use /help to see available commands."
-	case "help":
-		msg.Text = `Available commands:
-/search <query> - Search for messages
-/ask <question> - Ask a question about past messages
-/status - Check bot permissions and status
-/help - Show this help message`
-	case "status":
-		if message.Chat.IsGroup() || message.Chat.IsSuperGroup() {
-			// Get bot's member info in the group
-			chatMemberConfig := tgbotapi.GetChatMemberConfig{
-				ChatConfigWithUser: tgbotapi.ChatConfigWithUser{
-					ChatID: message.Chat.ID,
-					UserID: api.Self.ID,
-				},
-			}
+// goBackground runs fn in a goroutine that shutdown waits for
+func (a *app) goBackground(fn func()) {
+	a.background.Add(1)
+	go func() {
+		defer a.background.Done()
+		fn()
+	}()
+}
 
-			member, err := api.GetChatMember(chatMemberConfig)
-			if err != nil {
-				msg.Text = "Error checking bot status."
-				log.Printf("Error getting bot member info: %v", err)
-			} else {
-				msg.Text = fmt.Sprintf("Bot Status in this group:\n"+
-					"Role: %s\n", member.Status)
-
-				if member.Status == "administrator" {
-					msg.Text += "✅ Bot is properly configured with admin access.\n"
-				} else {
-					msg.Text += "❌ Bot needs to be an administrator to access messages.\n" +
-						"Please make me an administrator with these permissions:\n" +
-						"- Read Messages\n" +
-						"- Send Messages"
-				}
-			}
-		} else {
-			msg.Text = "This command only works in groups."
-		}
-	case "search":
-		query := message.CommandArguments()
-		if query == "" {
-			msg.Text = "Please provide a search query. Example: /search golang"
-		} else {
-			// Create search request
-			searchReq := &meilisearch.SearchRequest{
-				Query:                query,
-				Limit:                50,
-				AttributesToSearchOn: []string{"text"},
-				Sort:                 []string{"created_at:desc"},
-			}
+// cancelableClient ends the requests it sends when ctx is done
+type cancelableClient struct {
+	ctx    context.Context
+	client tgbotapi.HTTPClient
+}
 
-			results, err := meiliSearch.SearchMessages(message.Chat.ID, searchReq)
-			if err != nil {
-				msg.Text = "Sorry, an error occurred while searching."
-				log.Printf("Search error: %v", err)
-			} else if len(results) == 0 {
-				msg.Text = "No messages found matching your query."
-			} else {
-				msg.Text = "Found messages:\n\n"
-				for _, result := range results {
-					msg.Text += "From @" + result.Username + ":\n" + result.Text + "\n\n"
-				}
-			}
-		}
-	case "ask":
-		if err := searchBot.HandleAskCommand(context.Background(), message); err != nil {
-			log.Printf("Error handling ask command: %v", err)
-			msg.Text = "Sorry, an error occurred while processing your question."
+// Do sends a request, aborting it when ctx is done
+func (c *cancelableClient) Do(req *http.Request) (*http.Response, error) {
+	return c.client.Do(req.WithContext(c.ctx))
+}
+
+// receiveByWebhook registers a webhook and serves it until ctx is done, then
+// returns a function that stops the server
+func (a *app) receiveByWebhook(ctx context.Context, stop context.CancelFunc, updateConfig tgbotapi.UpdateConfig, dispatcher *dispatch.Dispatcher) func(context.Context) {
+	server, err := webhook.NewServer(a.api, webhook.Config{
+		URL:            a.cfg.Webhook.URL,
+		ListenAddr:     a.cfg.Webhook.ListenAddr,
+		SecretToken:    a.cfg.Webhook.Secret,
+		CertFile:       a.cfg.Webhook.CertFile,
+		KeyFile:        a.cfg.Webhook.KeyFile,
+		MaxConnections: a.cfg.Webhook.MaxConnections,
+		AllowedUpdates: updateConfig.AllowedUpdates,
+	}, dispatcher.Submit)
+	if err != nil {
+		fatal("Invalid webhook configuration", "error", err)
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			slog.Error("Webhook server failed", "error", err)
+			stop()
 		}
-		return
-	default:
-		msg.Text = "Unknown command. Use /help to see available commands."
+	}()
+
+	if err := server.Register(); err != nil {
+		fatal("Failed to register webhook", "error", err)
 	}
 
-	if _, err := api.Send(msg); err != nil {
-		log.Printf("Error sending message: %v", err)
+	<-ctx.Done()
+
+	return func(ctx context.Context) {
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("Error stopping webhook server", "error", err)
+		}
 	}
 }
 
-func storeMessage(message *tgbotapi.Message) error {
-	// Create message model
-	msg := &models.Message{
-		MessageID:    int64(message.MessageID),
-		ChatID:       message.Chat.ID,
-		ChatUsername: message.Chat.UserName,
-		UserID:       message.From.ID,
-		Username:     message.From.UserName,
-		Text:         message.Text,
-		CreatedAt:    time.Now(),
+// shutdown stops receiving updates, drains in-flight work and closes all
+// clients. Polling sends the offset that confirms a batch of updates with the
+// next poll, so queued updates are confirmed already and must be handled
+// before the deadline; if it passes, the dispatcher drops them and they are
+// lost. Unindexed messages stay in the durable queue.
+func (a *app) shutdown(stopReceiving func(context.Context), dispatcher *dispatch.Dispatcher) {
+	slog.Info("Shutting down", "timeout", a.cfg.ShutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.cfg.ShutdownTimeout)
+	defer cancel()
+
+	// Stop receiving new updates
+	stopReceiving(ctx)
+
+	// Let the workers finish what they already have
+	if err := dispatcher.Shutdown(ctx); err != nil {
+		slog.Error("Error draining update workers", "error", err)
 	}
 
-	// Store in MongoDB
-	if err := mongoStorage.StoreMessage(msg); err != nil {
-		log.Printf("Failed to store message: %v", err)
-		return err
+	// Send the watch notifications for the messages handled so far
+	if err := a.bot.CloseWatchNotifications(ctx); err != nil {
+		slog.Error("Error sending watch notifications", "error", err)
 	}
 
-	// Index in Meilisearch
-	if err := meiliSearch.IndexMessage(msg); err != nil {
-		log.Printf("Failed to index message: %v", err)
-		return err
+	// Background work was canceled with the lifetime context, wait for it to
+	// stop using the clients
+	background := make(chan struct{})
+	go func() {
+		a.background.Wait()
+		close(background)
+	}()
+	select {
+	case <-background:
+	case <-ctx.Done():
+		slog.Error("Shutdown deadline reached while background work was still running")
 	}
 
-	log.Printf("Successfully processed message: %d", msg.MessageID)
-	return nil
+	a.close(ctx)
+
+	// Keep serving metrics and health checks until everything is drained
+	if a.opsServer != nil {
+		if err := a.opsServer.Shutdown(ctx); err != nil {
+			slog.Error("Error stopping ops server", "error", err)
+		}
+	}
+	slog.Info("Shutdown complete")
+}
+
+// close flushes pending index batches, closes the storage, search and AI
+// clients, in that order, and finally exports the remaining spans
+func (a *app) close(ctx context.Context) {
+	// Index everything that is still buffered
+	if err := a.indexer.Close(ctx); err != nil {
+		slog.Error("Error flushing indexer", "error", err)
+	}
+
+	// Close clients
+	if err := a.storage.Close(ctx); err != nil {
+		slog.Error("Error closing MongoDB", "error", err)
+	}
+	a.search.Close()
+	if a.ai != nil {
+		a.ai.Close()
+	}
+
+	if err := a.stopTracing(ctx); err != nil {
+		slog.Error("Error flushing traces", "error", err)
+	}
 }
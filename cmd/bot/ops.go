@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"SearchBot/internal/health"
+	"SearchBot/internal/metrics"
+)
+
+// healthChecker builds the liveness and readiness checks. pollHeartbeat is
+// nil in webhook mode, where Telegram only calls us when there are updates.
+func (a *app) healthChecker(pollHeartbeat *health.Heartbeat) *health.Checker {
+	checker := health.NewChecker(a.cfg.Ops.CheckTimeout)
+
+	if pollHeartbeat != nil {
+		checker.AddLiveness("telegram_poll", pollHeartbeat.Check(a.cfg.Ops.MaxPollAge))
+	}
+
+	checker.AddReadiness("mongodb", a.storage.Ping)
+	checker.AddReadiness("meilisearch", a.search.Healthy)
+	checker.AddReadiness("telegram", func(ctx context.Context) error {
+		_, err := a.api.GetMe()
+		return err
+	})
+
+	return checker
+}
+
+// startOpsServer serves Prometheus metrics on /metrics and health checks on
+// /healthz and /readyz in the background. It returns nil if disabled.
+func (a *app) startOpsServer(checker *health.Checker) *http.Server {
+	if a.cfg.Ops.ListenAddr == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", checker.LivenessHandler())
+	mux.Handle("/readyz", checker.ReadinessHandler())
+
+	server := &http.Server{
+		Addr:              a.cfg.Ops.ListenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		slog.Info("Serving metrics and health checks", "addr", a.cfg.Ops.ListenAddr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Ops server failed", "error", err)
+		}
+	}()
+
+	return server
+}
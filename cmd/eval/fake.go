@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"SearchBot/internal/models"
+	"SearchBot/internal/query"
+)
+
+const (
+	fakeMaxCited    = 5 // Messages the fake model cites at most
+	fakeMinWordLen  = 4 // Shorter words are ignored
+	fakeCommonShare = 4 // Words in more than 1/fakeCommonShare of the messages are ignored
+)
+
+// fakeModel stands in for Gemini so evaluations run offline and give the
+// same results every time. It reads the "author: text" lines out of the
+// prompt and cites those sharing the most uncommon words with the question.
+type fakeModel struct {
+	question string
+	authors  []string // How the prompt names the senders, see models.Message.Author
+}
+
+// newFakeModel creates a fake model answering question from messages
+func newFakeModel(question string, messages []models.Message) *fakeModel {
+	seen := make(map[string]bool)
+	var authors []string
+	for _, message := range messages {
+		author := message.Author()
+		if !seen[author] {
+			seen[author] = true
+			authors = append(authors, author)
+		}
+	}
+	// Try longer names first, so "Ann Lee" isn't cut short by "Ann"
+	sort.Slice(authors, func(i, j int) bool { return len(authors[i]) > len(authors[j]) })
+	return &fakeModel{question: question, authors: authors}
+}
+
+// Model returns the name recorded for the fake model
+func (f *fakeModel) Model() string {
+	return "fake"
+}
+
+// AnswerQuestion answers the prompt built by answer.Analyze. Messages
+// spanning several lines are only matched by their first line.
+func (f *fakeModel) AnswerQuestion(ctx context.Context, prompt string, messages []models.Message) (string, models.AIUsage, error) {
+	var lines []string
+	df := make(map[string]int)
+	for _, line := range strings.Split(prompt, "\n") {
+		text, ok := f.messageText(line)
+		if !ok {
+			continue
+		}
+		lines = append(lines, text)
+		for word := range wordSet(text) {
+			df[word]++
+		}
+	}
+
+	type scored struct {
+		text  string
+		score int
+		order int
+	}
+	var cited []scored
+	questionWords := wordSet(f.question)
+	for i, line := range lines {
+		score := 0
+		for word := range wordSet(line) {
+			if questionWords[word] && df[word]*fakeCommonShare <= len(lines) {
+				score++
+			}
+		}
+		if score > 0 {
+			cited = append(cited, scored{text: line, score: score, order: i})
+		}
+	}
+	sort.Slice(cited, func(i, j int) bool {
+		if cited[i].score != cited[j].score {
+			return cited[i].score > cited[j].score
+		}
+		return cited[i].order < cited[j].order
+	})
+	if len(cited) > fakeMaxCited {
+		cited = cited[:fakeMaxCited]
+	}
+
+	response := struct {
+		RelevantMessages []string `json:"relevant_messages"`
+		Explanation      string   `json:"explanation"`
+	}{RelevantMessages: []string{}, Explanation: "These messages share words with your question."}
+	for _, c := range cited {
+		response.RelevantMessages = append(response.RelevantMessages, c.text)
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		return "", models.AIUsage{}, err
+	}
+
+	// Roughly four characters per token, so reports show what a prompt costs
+	usage := models.AIUsage{
+		Requests:       1,
+		PromptTokens:   int64(len(prompt) / 4),
+		ResponseTokens: int64(len(data) / 4),
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.ResponseTokens
+	return string(data), usage, nil
+}
+
+// messageText returns the text of a prompt line quoting a message, and
+// false if the line doesn't start with one of the senders. Display names
+// may contain spaces and colons, so the line can't simply be split.
+func (f *fakeModel) messageText(line string) (string, bool) {
+	for _, author := range f.authors {
+		if text, ok := strings.CutPrefix(line, author+": "); ok {
+			return text, true
+		}
+	}
+	return "", false
+}
+
+// wordSet returns the words of text long enough to mean something
+func wordSet(text string) map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range query.Words(text) {
+		if len(word) >= fakeMinWordLen {
+			words[word] = true
+		}
+	}
+	return words
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"SearchBot/internal/models"
+)
+
+// defaultFixture is used unless -fixture names another file, so the
+// command works from any directory
+//
+//go:embed testdata/corpus.json
+var defaultFixture []byte
+
+// fixture is a chat history with questions about it, labeled with the
+// messages that answer them
+type fixture struct {
+	Messages  []models.Message  `json:"messages"`
+	Questions []labeledQuestion `json:"questions"`
+}
+
+// labeledQuestion is a question and the IDs of the messages a good answer
+// links to. A question without expected messages should get no answer.
+type labeledQuestion struct {
+	Question string  `json:"question"`
+	Search   string  `json:"search,omitempty"` // What a user would type into /search, empty to use the question
+	Expected []int64 `json:"expected"`
+	Language string  `json:"language,omitempty"`
+}
+
+// searchQuery returns the /search query for the question
+func (q labeledQuestion) searchQuery() string {
+	if q.Search != "" {
+		return q.Search
+	}
+	return q.Question
+}
+
+// loadFixture reads and checks a fixture file, or the built-in fixture if
+// path is empty
+func loadFixture(path string) (*fixture, error) {
+	data := defaultFixture
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fixture: %v", err)
+		}
+	}
+
+	var f fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse fixture: %v", err)
+	}
+	if len(f.Messages) == 0 || len(f.Questions) == 0 {
+		return nil, fmt.Errorf("fixture has no messages or no questions")
+	}
+
+	ids := make(map[int64]bool)
+	for _, message := range f.Messages {
+		if ids[message.MessageID] {
+			return nil, fmt.Errorf("message %d appears twice", message.MessageID)
+		}
+		ids[message.MessageID] = true
+	}
+	for _, q := range f.Questions {
+		for _, id := range q.Expected {
+			if !ids[id] {
+				return nil, fmt.Errorf("question %q expects unknown message %d", q.Question, id)
+			}
+		}
+	}
+
+	// Order the history like storage.GetRecentMessages does, newest first
+	sort.SliceStable(f.Messages, func(i, j int) bool {
+		return f.Messages[i].CreatedAt.After(f.Messages[j].CreatedAt)
+	})
+	return &f, nil
+}
+
+// chatIDs returns the chats the fixture's messages come from
+func (f *fixture) chatIDs() []int64 {
+	var chatIDs []int64
+	seen := make(map[int64]bool)
+	for _, message := range f.Messages {
+		if !seen[message.ChatID] {
+			seen[message.ChatID] = true
+			chatIDs = append(chatIDs, message.ChatID)
+		}
+	}
+	return chatIDs
+}
+
+// recent returns the newest messages, like the history /ask looks at
+func (f *fixture) recent(n int) []models.Message {
+	if n <= 0 || n > len(f.Messages) {
+		n = len(f.Messages)
+	}
+	return f.Messages[:n]
+}
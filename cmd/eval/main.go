@@ -0,0 +1,265 @@
+// Command eval measures how well /ask answers a fixture of labeled questions,
+// so changes to the prompt or to keyword matching can be compared before
+// they are deployed:
+//
+//	go run ./cmd/eval
+//	go run ./cmd/eval -prompt ask-v1
+//	go run ./cmd/eval -backend gemini -min-recall 0.8
+//	go run ./cmd/eval -meilisearch http://localhost:7700 -min-search-recall 0.8
+//
+// With -meilisearch the fixture is also indexed into throwaway indexes to
+// measure how many expected messages /search finds among its first k
+// results. It exits with status 1 if an average falls below its -min-* threshold.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"SearchBot/internal/ai"
+	"SearchBot/internal/answer"
+	"SearchBot/internal/models"
+)
+
+// scores are the measurements for one question, each between 0 and 1
+type scores struct {
+	candidateRecall float64 // Expected messages within the history /ask looks at
+	searchRecall    float64 // Expected messages among the first k /search results
+	recall          float64 // Expected messages among the first k linked
+	precision       float64 // Linked messages that were expected
+	citation        float64 // Quoted messages that could be linked to a stored message
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout))
+}
+
+// run evaluates the fixture and returns the process exit code
+func run(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("eval", flag.ContinueOnError)
+	fixturePath := flags.String("fixture", "", "fixture with messages and labeled questions, empty for the built-in one")
+	backend := flags.String("backend", "fake", "model answering questions: fake, gemini or none (keyword matching only)")
+	modelName := flags.String("model", envOr("GEMINI_MODEL", "gemini-pro"), "Gemini model name")
+	promptsDir := flags.String("prompts", "", "directory of *.tmpl prompt templates adding to or replacing the built-in ones")
+	promptName := flags.String("prompt", "", "prompt to evaluate, empty for the default")
+	k := flags.Int("k", 5, "linked messages counted for recall@k")
+	contextSize := flags.Int("context", 100, "recent messages /ask looks at, 0 for all")
+	meiliHost := flags.String("meilisearch", "", "Meilisearch URL to also evaluate /search, empty to skip")
+	meiliKey := flags.String("meilisearch-key", os.Getenv("MEILISEARCH_KEY"), "Meilisearch API key")
+	minRecall := flags.Float64("min-recall", 0, "fail if the average recall@k is lower")
+	minPrecision := flags.Float64("min-precision", 0, "fail if the average precision is lower")
+	minCitation := flags.Float64("min-citation", 0, "fail if the average citation accuracy is lower")
+	minSearchRecall := flags.Float64("min-search-recall", 0, "fail if the average /search recall@k is lower")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	f, err := loadFixture(*fixturePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	prompts, err := answer.LoadPrompts(*promptsDir, *promptName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	prompt := prompts.Get(*promptName)
+
+	// Stop cleanly on Ctrl-C, the model call in flight is cancelled
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var gemini *ai.GeminiAI
+	switch *backend {
+	case "fake", "none":
+	case "gemini":
+		apiKey := os.Getenv("GEMINI_API_KEY")
+		if apiKey == "" {
+			fmt.Fprintln(os.Stderr, "GEMINI_API_KEY is required for the gemini backend")
+			return 2
+		}
+		gemini, err = ai.NewGeminiAI(apiKey, *modelName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		defer gemini.Close()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown backend %q\n", *backend)
+		return 2
+	}
+
+	var searcher *searchEval
+	if *meiliHost != "" {
+		searcher, err = newSearchEval(ctx, *meiliHost, *meiliKey, f)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer searcher.close()
+	}
+
+	messages := f.recent(*contextSize)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "QUESTION\tSOURCE\tCANDIDATES\tRECALL@K\tPRECISION\tCITATION\tSEARCH@K\tMISSED")
+
+	var total scores
+	var requests, tokens int64
+	for _, q := range f.Questions {
+		var model answer.Model
+		switch *backend {
+		case "fake":
+			model = newFakeModel(q.Question, messages)
+		case "gemini":
+			model = gemini
+		}
+
+		result, err := answer.Find(ctx, model, prompt, q.Question, messages, messages, q.Language)
+		requests += result.Usage.Requests
+		tokens += result.Usage.TotalTokens
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to answer %q: %v\n", q.Question, err)
+			return 1
+		}
+
+		s, missed := score(q, messages, result, *k)
+		searchRecall := "-"
+		if searcher != nil {
+			s.searchRecall, err = searcher.recall(ctx, q, *k)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			searchRecall = fmt.Sprintf("%.2f", s.searchRecall)
+		}
+		total.candidateRecall += s.candidateRecall
+		total.searchRecall += s.searchRecall
+		total.recall += s.recall
+		total.precision += s.precision
+		total.citation += s.citation
+		fmt.Fprintf(w, "%s\t%s\t%.2f\t%.2f\t%.2f\t%.2f\t%s\t%v\n", truncate(q.Question, 40), result.Source,
+			s.candidateRecall, s.recall, s.precision, s.citation, searchRecall, missed)
+	}
+
+	n := float64(len(f.Questions))
+	avg := scores{total.candidateRecall / n, total.searchRecall / n, total.recall / n, total.precision / n, total.citation / n}
+	searchRecall := "-"
+	if searcher != nil {
+		searchRecall = fmt.Sprintf("%.2f", avg.searchRecall)
+	}
+	fmt.Fprintf(w, "AVERAGE\t\t%.2f\t%.2f\t%.2f\t%.2f\t%s\t\n", avg.candidateRecall, avg.recall, avg.precision, avg.citation, searchRecall)
+	w.Flush()
+	fmt.Fprintf(out, "\n%d questions, %d messages, k=%d, prompt %s, %d model requests, %d tokens\n",
+		len(f.Questions), len(messages), *k, prompt.Version, requests, tokens)
+
+	failed := false
+	check := func(name string, got, min float64) {
+		if got < min {
+			fmt.Fprintf(out, "FAIL: average %s %.2f is below %.2f\n", name, got, min)
+			failed = true
+		}
+	}
+	check("recall@k", avg.recall, *minRecall)
+	check("precision", avg.precision, *minPrecision)
+	check("citation accuracy", avg.citation, *minCitation)
+	if searcher != nil {
+		check("/search recall@k", avg.searchRecall, *minSearchRecall)
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// score measures an answer against the messages the question expects, and
+// returns the expected messages it didn't link to
+func score(q labeledQuestion, messages []models.Message, result answer.Answer, k int) (scores, []int64) {
+	expected := make(map[int64]bool)
+	for _, id := range q.Expected {
+		expected[id] = true
+	}
+
+	// The answer may quote a message in several parts, count it once
+	var linked []int64
+	seen := make(map[int64]bool)
+	for _, message := range result.Cited {
+		if !seen[message.MessageID] {
+			seen[message.MessageID] = true
+			linked = append(linked, message.MessageID)
+		}
+	}
+
+	var s scores
+	s.citation = 1
+	if len(result.RelevantMessages) > 0 {
+		s.citation = float64(len(result.Cited)) / float64(len(result.RelevantMessages))
+	}
+
+	// Questions nothing answers should get no answer at all
+	if len(expected) == 0 {
+		s.candidateRecall, s.recall = 1, 1
+		if len(linked) == 0 {
+			s.precision = 1
+		}
+		return s, nil
+	}
+
+	inHistory := 0
+	for _, message := range messages {
+		if expected[message.MessageID] {
+			inHistory++
+		}
+	}
+	s.candidateRecall = float64(inHistory) / float64(len(expected))
+
+	found := 0
+	for i, id := range linked {
+		if i < k && expected[id] {
+			found++
+		}
+	}
+	s.recall = float64(found) / float64(len(expected))
+
+	relevant := 0
+	for _, id := range linked {
+		if expected[id] {
+			relevant++
+		}
+	}
+	if len(linked) > 0 {
+		s.precision = float64(relevant) / float64(len(linked))
+	}
+
+	var missed []int64
+	for _, id := range q.Expected {
+		if !seen[id] {
+			missed = append(missed, id)
+		}
+	}
+	return s, missed
+}
+
+// envOr returns an environment variable, or def if it isn't set
+func envOr(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// truncate shortens text to at most n runes
+func truncate(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-1]) + "…"
+}
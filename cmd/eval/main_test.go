@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// TestRun evaluates the built-in fixture with the fake model, so changes to
+// keyword matching or the default prompt can't quietly make /ask worse
+func TestRun(t *testing.T) {
+	var out bytes.Buffer
+	code := run([]string{"-min-recall", "0.9", "-min-precision", "0.7", "-min-citation", "1"}, &out)
+	if code != 0 {
+		t.Fatalf("run returned %d:\n%s", code, out.String())
+	}
+}
+
+// TestRunSearch also evaluates /search, it needs a Meilisearch server
+func TestRunSearch(t *testing.T) {
+	host := os.Getenv("MEILISEARCH_HOST")
+	if host == "" {
+		t.Skip("MEILISEARCH_HOST is not set")
+	}
+
+	var out bytes.Buffer
+	code := run([]string{"-meilisearch", host, "-min-search-recall", "0.5"}, &out)
+	if code != 0 {
+		t.Fatalf("run returned %d:\n%s", code, out.String())
+	}
+}
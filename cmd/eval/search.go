@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"SearchBot/internal/models"
+	"SearchBot/internal/query"
+	"SearchBot/internal/search"
+)
+
+// searchTimeout bounds indexing the fixture and deleting it again
+const searchTimeout = time.Minute
+
+// searchEval measures /search retrieval on a Meilisearch server. The
+// fixture is indexed into indexes of its own, which are deleted afterwards.
+type searchEval struct {
+	search  *search.MeiliSearch
+	chatIDs []int64
+}
+
+// newSearchEval indexes the fixture's messages with the bot's index settings
+func newSearchEval(ctx context.Context, host, key string, f *fixture) (*searchEval, error) {
+	e := &searchEval{
+		search:  search.NewMeiliSearch(host, key, fmt.Sprintf("searchbot_eval_%d", time.Now().UnixNano())),
+		chatIDs: f.chatIDs(),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, searchTimeout)
+	defer cancel()
+	for _, chatID := range e.chatIDs {
+		var messages []models.Message
+		for _, message := range f.Messages {
+			if message.ChatID == chatID {
+				messages = append(messages, message)
+			}
+		}
+		taskUID, err := e.search.IndexMessages(ctx, chatID, messages)
+		if err == nil {
+			err = e.search.WaitForTask(ctx, taskUID)
+		}
+		if err != nil {
+			e.close()
+			return nil, fmt.Errorf("failed to index the fixture: %v", err)
+		}
+	}
+	return e, nil
+}
+
+// recall returns the share of the messages a question expects among the
+// first k results of searching for it, like private /search does
+func (e *searchEval) recall(ctx context.Context, q labeledQuestion, k int) (float64, error) {
+	results, err := e.search.Find(ctx, e.chatIDs, query.Parse(q.searchQuery()), int64(k))
+	if err != nil {
+		return 0, fmt.Errorf("failed to search for %q: %v", q.searchQuery(), err)
+	}
+	if len(q.Expected) == 0 {
+		return 1, nil
+	}
+
+	expected := make(map[int64]bool)
+	for _, id := range q.Expected {
+		expected[id] = true
+	}
+	found := 0
+	for i, result := range results {
+		if i < k && expected[result.MessageID] {
+			found++
+			delete(expected, result.MessageID)
+		}
+	}
+	return float64(found) / float64(len(q.Expected)), nil
+}
+
+// close deletes the fixture's indexes
+func (e *searchEval) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), searchTimeout)
+	defer cancel()
+	for _, chatID := range e.chatIDs {
+		if err := e.search.DeleteIndex(ctx, chatID); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to delete evaluation index of chat %d: %v\n", chatID, err)
+		}
+	}
+	e.search.Close()
+}
@@ -7,6 +7,8 @@ import (
 	"os"
 	"time"
 
+	"SearchBot/internal/logging"
+
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -23,7 +25,7 @@ func main() {
 		log.Fatal("MONGODB_URI is not set")
 	}
 
-	log.Printf("Attempting to connect to MongoDB with URI: %s", uri)
+	log.Printf("Attempting to connect to MongoDB with URI: %s", logging.Scrub(uri))
 
 	// Create client options with longer timeouts
 	clientOptions := options.Client().
@@ -0,0 +1,149 @@
+package access
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"SearchBot/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// DefaultRoleTTL is how long a looked up chat role is trusted
+const DefaultRoleTTL = 5 * time.Minute
+
+// Role is a user's status in a chat, as reported by getChatMember
+type Role string
+
+// Roles a chat member can have
+const (
+	RoleCreator    Role = "creator"
+	RoleAdmin      Role = "administrator"
+	RoleMember     Role = "member"
+	RoleRestricted Role = "restricted"
+	RoleLeft       Role = "left"
+	RoleKicked     Role = "kicked"
+)
+
+// IsAdmin reports whether the role can manage the chat
+func (r Role) IsAdmin() bool {
+	return r == RoleCreator || r == RoleAdmin
+}
+
+// IsMember reports whether the role belongs to someone currently in the chat
+func (r Role) IsMember() bool {
+	return r.IsAdmin() || r == RoleMember || r == RoleRestricted
+}
+
+// RoleOf returns the role of a chat member. Restricted users who left the
+// chat are reported as RoleLeft.
+func RoleOf(member tgbotapi.ChatMember) Role {
+	if member.Status == string(RoleRestricted) && !member.IsMember {
+		return RoleLeft
+	}
+	return Role(member.Status)
+}
+
+// memberKey identifies a user in a chat
+type memberKey struct {
+	chatID int64
+	userID int64
+}
+
+// cachedRole is a role and when it stops being trusted
+type cachedRole struct {
+	role    Role
+	expires time.Time
+}
+
+// Checker resolves chat roles and decides whether users may run commands
+type Checker struct {
+	api *tgbotapi.BotAPI
+	ttl time.Duration
+
+	mu     sync.Mutex
+	roles  map[memberKey]cachedRole
+	pruned time.Time // When expired roles were last removed
+}
+
+// NewChecker creates a new Checker that caches roles for ttl
+func NewChecker(api *tgbotapi.BotAPI, ttl time.Duration) *Checker {
+	return &Checker{
+		api:   api,
+		ttl:   ttl,
+		roles: make(map[memberKey]cachedRole),
+	}
+}
+
+// Role returns a user's role in a chat, asking Telegram if it isn't cached
+func (c *Checker) Role(chatID, userID int64) (Role, error) {
+	key := memberKey{chatID: chatID, userID: userID}
+
+	c.mu.Lock()
+	cached, ok := c.roles[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.role, nil
+	}
+
+	member, err := c.api.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{
+			ChatID: chatID,
+			UserID: userID,
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get role of user %d in chat %d: %v", userID, chatID, err)
+	}
+
+	role := RoleOf(member)
+	c.SetRole(chatID, userID, role)
+	return role, nil
+}
+
+// SetRole records a role reported by a chat_member update, so changes apply
+// without waiting for the cached one to expire
+func (c *Checker) SetRole(chatID, userID int64, role Role) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.prune(now)
+	c.roles[memberKey{chatID: chatID, userID: userID}] = cachedRole{role: role, expires: now.Add(c.ttl)}
+}
+
+// prune removes expired roles, at most once per ttl, so the cache only
+// holds the users seen recently. c.mu must be held.
+func (c *Checker) prune(now time.Time) {
+	if now.Sub(c.pruned) < c.ttl {
+		return
+	}
+	for key, cached := range c.roles {
+		if now.After(cached.expires) {
+			delete(c.roles, key)
+		}
+	}
+	c.pruned = now
+}
+
+// Allowed reports whether a user may run a command with the given policy.
+// Admins may always run allowlisted commands so they can't lock themselves out.
+func (c *Checker) Allowed(chatID, userID int64, policy models.CommandPolicy) (bool, error) {
+	switch policy.Access {
+	case models.AccessEveryone, "":
+		return true, nil
+	case models.AccessAllowlist:
+		for _, id := range policy.AllowedUsers {
+			if id == userID {
+				return true, nil
+			}
+		}
+	}
+
+	role, err := c.Role(chatID, userID)
+	if err != nil {
+		return false, err
+	}
+	return role.IsAdmin(), nil
+}
@@ -0,0 +1,43 @@
+package access
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// memberLookups is how many getChatMember requests MemberChats makes at once
+const memberLookups = 8
+
+// MemberChats returns the chats among chatIDs that a user currently belongs
+// to, in the order given. Chats where membership can't be verified are left
+// out, so a failed lookup never reveals a chat.
+func (c *Checker) MemberChats(userID int64, chatIDs []int64) []int64 {
+	member := make([]bool, len(chatIDs))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, memberLookups)
+	for i, chatID := range chatIDs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, chatID int64) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			role, err := c.Role(chatID, userID)
+			if err != nil {
+				slog.Debug("Could not verify membership", "chat_id", chatID, "user_id", userID, "error", err)
+				return
+			}
+			member[i] = role.IsMember()
+		}(i, chatID)
+	}
+	wg.Wait()
+
+	var chats []int64
+	for i, chatID := range chatIDs {
+		if member[i] {
+			chats = append(chats, chatID)
+		}
+	}
+	return chats
+}
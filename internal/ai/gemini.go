@@ -4,19 +4,24 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
+	"SearchBot/internal/metrics"
 	"SearchBot/internal/models"
+	"SearchBot/internal/tracing"
 
 	"github.com/google/generative-ai-go/genai"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/api/option"
 )
 
 type GeminiAI struct {
-	client *genai.Client
-	model  *genai.GenerativeModel
+	client    *genai.Client
+	model     *genai.GenerativeModel
+	modelName string
 }
 
-func NewGeminiAI(apiKey string) (*GeminiAI, error) {
+func NewGeminiAI(apiKey, modelName string) (*GeminiAI, error) {
 	ctx := context.Background()
 	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
 	if err != nil {
@@ -24,19 +29,27 @@ func NewGeminiAI(apiKey string) (*GeminiAI, error) {
 	}
 
 	// Get the generative model
-	model := client.GenerativeModel("gemini-pro")
+	model := client.GenerativeModel(modelName)
 
 	return &GeminiAI{
-		client: client,
-		model:  model,
+		client:    client,
+		model:     model,
+		modelName: modelName,
 	}, nil
 }
 
+// Model returns the name of the model answering questions
+func (g *GeminiAI) Model() string {
+	return g.modelName
+}
+
 func (g *GeminiAI) Close() {
 	g.client.Close()
 }
 
-func (g *GeminiAI) AnswerQuestion(ctx context.Context, question string, messages []models.Message) (string, error) {
+// AnswerQuestion asks the model a question and returns its answer along with
+// the tokens it used
+func (g *GeminiAI) AnswerQuestion(ctx context.Context, question string, messages []models.Message) (string, models.AIUsage, error) {
 	// If no messages provided, this is a direct question to AI (e.g., for search strategy)
 	if messages == nil {
 		return g.generateResponse(ctx, question)
@@ -49,25 +62,51 @@ func (g *GeminiAI) AnswerQuestion(ctx context.Context, question string, messages
 	if len(messages) > 0 {
 		prompt.WriteString("\n\nContext from chat messages:\n")
 		for i, msg := range messages {
-			prompt.WriteString(fmt.Sprintf("%d. @%s: %s\n", i+1, msg.Username, msg.Text))
+			prompt.WriteString(fmt.Sprintf("%d. %s: %s\n", i+1, msg.Author(), msg.Text))
 		}
 	}
 
 	return g.generateResponse(ctx, prompt.String())
 }
 
-func (g *GeminiAI) generateResponse(ctx context.Context, prompt string) (string, error) {
+func (g *GeminiAI) generateResponse(ctx context.Context, prompt string) (_ string, usage models.AIUsage, err error) {
+	ctx, span := tracing.Start(ctx, "gemini.generate_content")
+	defer func() {
+		span.SetAttributes(
+			attribute.Int64("gen_ai.usage.input_tokens", usage.PromptTokens),
+			attribute.Int64("gen_ai.usage.output_tokens", usage.ResponseTokens))
+		tracing.End(span, err)
+	}()
+
 	// Generate content directly using the model
+	start := time.Now()
 	resp, err := g.model.GenerateContent(ctx, genai.Text(prompt))
+	metrics.AIRequestDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
-		return "", fmt.Errorf("failed to generate content: %v", err)
+		metrics.AIErrors.Inc()
+		return "", models.AIUsage{}, fmt.Errorf("failed to generate content: %v", err)
 	}
 
-	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
-		return "", fmt.Errorf("no response generated")
+	// Failed requests aren't billed, everything else is
+	usage = models.AIUsage{Requests: 1}
+	if resp.UsageMetadata != nil {
+		usage.PromptTokens = int64(resp.UsageMetadata.PromptTokenCount)
+		usage.ResponseTokens = int64(resp.UsageMetadata.CandidatesTokenCount)
+		usage.TotalTokens = int64(resp.UsageMetadata.TotalTokenCount)
+	}
+	metrics.AITokens.WithLabelValues("prompt").Add(float64(usage.PromptTokens))
+	metrics.AITokens.WithLabelValues("response").Add(float64(usage.ResponseTokens))
+
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
+		metrics.AIErrors.Inc()
+		return "", usage, fmt.Errorf("no response generated")
 	}
 
 	// Get the response text
-	response := resp.Candidates[0].Content.Parts[0].(genai.Text)
-	return string(response), nil
+	response, ok := resp.Candidates[0].Content.Parts[0].(genai.Text)
+	if !ok {
+		metrics.AIErrors.Inc()
+		return "", usage, fmt.Errorf("unexpected response part %T", resp.Candidates[0].Content.Parts[0])
+	}
+	return string(response), usage, nil
 } 
\ No newline at end of file
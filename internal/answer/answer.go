@@ -0,0 +1,172 @@
+package answer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"SearchBot/internal/logging"
+	"SearchBot/internal/metrics"
+	"SearchBot/internal/models"
+)
+
+// Where an answer's messages came from
+const (
+	SourceAI      = "ai"      // Chosen by the model
+	SourceKeyword = "keyword" // Shared words with the question
+	SourceNone    = "none"    // Nothing relevant was found
+)
+
+// Model is the language model that picks the messages answering a question
+type Model interface {
+	AnswerQuestion(ctx context.Context, question string, messages []models.Message) (string, models.AIUsage, error)
+	Model() string
+}
+
+// Result is the model's answer to a question
+type Result struct {
+	RelevantMessages []string `json:"relevant_messages"`
+	Explanation      string   `json:"explanation"`
+}
+
+// Answer is the outcome of answering a question
+type Answer struct {
+	Result
+	Candidates    []models.Message // Messages the answer was chosen from
+	Cited         []models.Message // Messages the relevant messages were mapped back to
+	Source        string           // SourceAI, SourceKeyword or SourceNone
+	PromptVersion string           // Version of the prompt, if the model answered
+	Usage         models.AIUsage   // What the model call cost
+}
+
+// Find answers a question the way /ask does. If model is not nil it is sent
+// the prompt and picks the relevant messages among aiMessages; if it isn't
+// asked or finds nothing, messages sharing keywords with the question are
+// used instead. The usage of the model call is returned even if it fails.
+func Find(ctx context.Context, model Model, prompt *Prompt, question string, aiMessages, messages []models.Message, language string) (Answer, error) {
+	var answer Answer
+	if model != nil && len(aiMessages) > 0 {
+		result, usage, err := Analyze(ctx, model, prompt, question, aiMessages, language)
+		answer.Usage = usage
+		if err != nil {
+			return answer, fmt.Errorf("failed to analyze messages: %v", err)
+		}
+		answer.Result = result
+		answer.Source = SourceAI
+		answer.PromptVersion = prompt.Version
+	}
+
+	answer.Candidates = aiMessages
+	if len(answer.RelevantMessages) == 0 {
+		answer.Candidates = messages
+		answer.Source = SourceKeyword
+		answer.PromptVersion = ""
+		answer.RelevantMessages = KeywordMatches(question, messages)
+		if len(answer.RelevantMessages) > 0 && answer.Explanation == "" {
+			answer.Explanation = "Found some messages that might be relevant to your question."
+		}
+	}
+	if len(answer.RelevantMessages) == 0 {
+		answer.Source = SourceNone
+		return answer, nil
+	}
+
+	answer.Cited = Resolve(ctx, answer.RelevantMessages, answer.Candidates)
+	return answer, nil
+}
+
+// Analyze asks the model which messages answer a question. An unparseable
+// answer yields an empty result so the caller can fall back to keyword
+// matching.
+func Analyze(ctx context.Context, model Model, prompt *Prompt, question string, messages []models.Message, language string) (Result, models.AIUsage, error) {
+	var result Result
+
+	analysisPrompt, err := prompt.Render(PromptData{Question: question, Messages: messages, Language: language})
+	if err != nil {
+		return result, models.AIUsage{}, err
+	}
+
+	slog.DebugContext(ctx, "Sending messages to AI for analysis", "count", len(messages), "prompt", prompt.Version)
+
+	analysis, usage, err := model.AnswerQuestion(ctx, analysisPrompt, nil)
+	if err != nil {
+		return result, usage, err
+	}
+
+	slog.DebugContext(ctx, "Received AI analysis", logging.Content("response", analysis))
+
+	// Clean and parse the AI response
+	analysis = cleanJSONResponse(analysis)
+	if err := json.Unmarshal([]byte(analysis), &result); err != nil {
+		metrics.AIParseFailures.Inc()
+		slog.WarnContext(ctx, "Failed to parse AI response", "error", err, logging.Content("response", analysis))
+		return Result{}, usage, nil
+	}
+	return result, usage, nil
+}
+
+// Resolve maps the relevant messages of an answer back to the messages they
+// were quoted from. Quotes that match no message are left out.
+func Resolve(ctx context.Context, relevant []string, messages []models.Message) []models.Message {
+	var relevantMessages []models.Message
+	for _, relevantMsg := range relevant {
+		// Find the corresponding message
+		found := false
+		for _, m := range messages {
+			// Clean up the message text for comparison
+			cleanedRelevantMsg := strings.TrimSpace(relevantMsg)
+			cleanedText := strings.TrimSpace(m.Text)
+
+			// Try exact match first
+			if cleanedText == cleanedRelevantMsg {
+				relevantMessages = append(relevantMessages, m)
+				found = true
+				break
+			}
+
+			// If no exact match, try to find the text within the message
+			if strings.Contains(cleanedText, cleanedRelevantMsg) {
+				// Create a new message with the matched text
+				newMsg := m
+				newMsg.Text = relevantMsg
+				relevantMessages = append(relevantMessages, newMsg)
+				found = true
+				break
+			}
+
+			// If the relevant message contains multiple messages, try to match the original
+			if strings.Contains(cleanedRelevantMsg, cleanedText) {
+				relevantMessages = append(relevantMessages, m)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			slog.DebugContext(ctx, "Could not find original message", logging.Content("text", relevantMsg))
+		}
+	}
+	return relevantMessages
+}
+
+// cleanJSONResponse cleans up the AI's response to extract valid JSON
+func cleanJSONResponse(response string) string {
+	response = strings.TrimSpace(response)
+	response = strings.ReplaceAll(response, "```json", "")
+	response = strings.ReplaceAll(response, "```", "")
+	response = strings.ReplaceAll(response, "`", "")
+	response = strings.ReplaceAll(response, "\n", "")
+	response = strings.ReplaceAll(response, "\r", "")
+	response = strings.ReplaceAll(response, "\t", "")
+
+	// Extract JSON between first { and last }
+	start := strings.Index(response, "{")
+	end := strings.LastIndex(response, "}")
+	if start != -1 && end != -1 && end > start {
+		response = response[start : end+1]
+	}
+
+	return strings.TrimSpace(response)
+}
@@ -0,0 +1,242 @@
+package answer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"SearchBot/internal/models"
+)
+
+// groupMessagesByContext groups messages based on their semantic context
+func groupMessagesByContext(messages []models.Message, relevanceCriteria string) [][]models.Message {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	// Sort messages by time
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+	})
+
+	// Group messages that are semantically related and within time window
+	const conversationTimeout = 2 * time.Minute
+	var conversations [][]models.Message
+	currentConvo := []models.Message{messages[0]}
+
+	for i := 1; i < len(messages); i++ {
+		timeDiff := messages[i].CreatedAt.Sub(messages[i-1].CreatedAt)
+
+		// Check if messages are related by:
+		// 1. Time proximity
+		// 2. Direct replies
+		// 3. Shared context (based on the AI's relevance criteria)
+		isRelated := false
+
+		// Time proximity check
+		if timeDiff <= conversationTimeout {
+			isRelated = true
+		}
+
+		// Direct reply check
+		if isDirectReply(messages[i-1].Text, messages[i].Text) {
+			isRelated = true
+		}
+
+		// Context similarity check (if messages share significant terms)
+		prevWords := extractSignificantTerms(messages[i-1].Text)
+		currWords := extractSignificantTerms(messages[i].Text)
+		if hasCommonTerms(prevWords, currWords) {
+			isRelated = true
+		}
+
+		if isRelated {
+			currentConvo = append(currentConvo, messages[i])
+		} else {
+			if len(currentConvo) > 0 {
+				conversations = append(conversations, currentConvo)
+			}
+			currentConvo = []models.Message{messages[i]}
+		}
+	}
+
+	if len(currentConvo) > 0 {
+		conversations = append(conversations, currentConvo)
+	}
+
+	return conversations
+}
+
+// extractSignificantTerms extracts meaningful terms from text
+func extractSignificantTerms(text string) []string {
+	text = strings.ToLower(text)
+	words := strings.Fields(text)
+	var terms []string
+
+	for _, word := range words {
+		// Clean the word
+		word = strings.Trim(word, ".,!?()[]{}:;\"'")
+
+		// Keep significant words
+		if len(word) > 3 && !isCommonWord(word) {
+			terms = append(terms, word)
+		}
+	}
+
+	return terms
+}
+
+// hasCommonTerms checks if two sets of terms share any significant words
+func hasCommonTerms(terms1, terms2 []string) bool {
+	// Create map of first set of terms
+	termMap := make(map[string]bool)
+	for _, term := range terms1 {
+		termMap[term] = true
+	}
+
+	// Check if any term from second set exists in map
+	for _, term := range terms2 {
+		if termMap[term] {
+			return true
+		}
+		// Also check for substring matches
+		for term1 := range termMap {
+			if len(term1) > 3 && len(term) > 3 {
+				if strings.Contains(term1, term) || strings.Contains(term, term1) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// isTopicRelated checks if two topics are related
+func isTopicRelated(topic1, topic2 string) bool {
+	// If either topic is empty, they're not related
+	if topic1 == "" || topic2 == "" {
+		return false
+	}
+
+	// Topics are related if:
+	// 1. They are exactly the same
+	if topic1 == topic2 {
+		return true
+	}
+
+	// 2. They are part of the same technical group
+	technicalGroups := map[string][]string{
+		"stack":  {"localstack", "aws", "cloud", "docker"},
+		"scrape": {"crawler", "crawling", "scraping", "extract"},
+		"docker": {"container", "localstack", "stack"},
+		"aws":    {"localstack", "cloud", "stack"},
+	}
+
+	// Check if topics belong to the same group
+	for _, group := range technicalGroups {
+		inGroup1 := false
+		inGroup2 := false
+		for _, term := range group {
+			if strings.Contains(topic1, term) {
+				inGroup1 = true
+			}
+			if strings.Contains(topic2, term) {
+				inGroup2 = true
+			}
+		}
+		if inGroup1 && inGroup2 {
+			return true
+		}
+	}
+
+	// 3. One contains the other
+	if strings.Contains(topic1, topic2) || strings.Contains(topic2, topic1) {
+		return true
+	}
+
+	return false
+}
+
+// isDirectReply checks if a message is a direct reply to the previous message
+func isDirectReply(prevText, currText string) bool {
+	// Convert to lowercase for consistent matching
+	prevText = strings.ToLower(prevText)
+	currText = strings.ToLower(currText)
+
+	// Check if it's a short response (less than 5 words) to a question
+	if strings.HasSuffix(prevText, "?") {
+		words := strings.Fields(currText)
+		if len(words) < 5 {
+			return true
+		}
+	}
+
+	// Check if the current message references words from the previous message
+	prevWords := strings.Fields(prevText)
+	currWords := strings.Fields(currText)
+
+	// Get significant words from previous message
+	var significantPrevWords []string
+	for _, word := range prevWords {
+		if len(word) > 3 && !isCommonWord(word) {
+			significantPrevWords = append(significantPrevWords, word)
+		}
+	}
+
+	// Check if current message contains any significant words from previous message
+	for _, currWord := range currWords {
+		for _, prevWord := range significantPrevWords {
+			if strings.Contains(strings.ToLower(currWord), strings.ToLower(prevWord)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isCommonWord returns true if the word is too common to be useful for topic detection
+func isCommonWord(word string) bool {
+	word = strings.ToLower(word)
+	commonWords := map[string]bool{
+		"the": true, "be": true, "to": true, "of": true, "and": true,
+		"a": true, "in": true, "that": true, "have": true, "i": true,
+		"it": true, "for": true, "not": true, "on": true, "with": true,
+		"he": true, "as": true, "you": true, "do": true, "at": true,
+		"this": true, "but": true, "his": true, "by": true, "from": true,
+		"they": true, "we": true, "say": true, "her": true, "she": true,
+		"or": true, "an": true, "will": true, "my": true, "one": true,
+		"all": true, "would": true, "there": true, "their": true, "what": true,
+		"was": true, "were": true, "been": true, "being": true, "into": true,
+		"who": true, "whom": true, "whose": true, "which": true, "where": true,
+		"when": true, "why": true, "how": true, "any": true, "some": true,
+		"can": true, "could": true, "may": true, "might": true, "must": true,
+		"shall": true, "should": true, "about": true, "many": true, "most": true,
+		"other": true, "such": true, "than": true, "then": true, "these": true,
+		"those": true, "only": true, "very": true, "also": true, "just": true,
+		"know": true, "like": true, "time": true, "make": true, "see": true,
+		"find": true, "want": true, "does": true, "need": true, "going": true,
+		"after": true, "again": true, "our": true, "well": true, "way": true,
+		"even": true, "new": true, "because": true, "give": true, "day": true,
+		"anyone": true, "anybody": true, "anything": true, "everyone": true,
+		"everybody": true, "everything": true, "someone": true, "somebody": true,
+		"something": true, "nothing": true, "nobody": true, "none": true,
+	}
+	return commonWords[word]
+}
+
+// KeywordMatches returns the messages sharing a significant word with the
+// question, formatted like the model's relevant messages
+func KeywordMatches(question string, messages []models.Message) []string {
+	var matches []string
+	keywords := extractSignificantTerms(question)
+	for _, message := range messages {
+		messageTerms := extractSignificantTerms(message.Text)
+		if hasCommonTerms(keywords, messageTerms) {
+			matches = append(matches, fmt.Sprintf("%s: %s", message.Author(), message.Text))
+		}
+	}
+	return matches
+}
@@ -0,0 +1,158 @@
+package answer
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"SearchBot/internal/models"
+)
+
+// DefaultPrompt is the prompt used unless the deployment picks another
+const DefaultPrompt = "ask-v1"
+
+// promptExt is the extension of prompt template files
+const promptExt = ".tmpl"
+
+// builtinPrompts are the prompts shipped with the bot
+//
+//go:embed prompts/*.tmpl
+var builtinPrompts embed.FS
+
+// PromptData is what a prompt template is executed with
+type PromptData struct {
+	Question string
+	Messages []models.Message // Newest first
+	Language string           // Answer language, empty to match the question
+}
+
+// Prompt is a text/template asking the model which messages answer a
+// question. It is named after its file, and its version also identifies the
+// exact text, so answers can be compared across prompt changes even if a
+// deployment edits a prompt without renaming it.
+type Prompt struct {
+	Name    string
+	Version string
+	tmpl    *template.Template
+}
+
+// Render executes the prompt
+func (p *Prompt) Render(data PromptData) (string, error) {
+	var prompt strings.Builder
+	if err := p.tmpl.Execute(&prompt, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt %s: %v", p.Name, err)
+	}
+	return prompt.String(), nil
+}
+
+// Prompts are the prompts a deployment offers
+type Prompts struct {
+	prompts map[string]*Prompt
+	def     string
+}
+
+// LoadPrompts loads the built-in prompts and then the *.tmpl files in dir,
+// which add prompts or replace built-in ones with the same name. dir may be
+// empty. Every prompt is checked by rendering it with sample data, and def
+// must be one of them, or empty for DefaultPrompt.
+func LoadPrompts(dir, def string) (*Prompts, error) {
+	if def == "" {
+		def = DefaultPrompt
+	}
+	p := &Prompts{prompts: make(map[string]*Prompt), def: def}
+	if err := p.load(builtinPrompts, "prompts"); err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		if err := p.load(os.DirFS(dir), "."); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.prompts[def] == nil {
+		return nil, fmt.Errorf("default prompt %q not found, available prompts: %s", def, strings.Join(p.Names(), ", "))
+	}
+	return p, nil
+}
+
+// load adds the prompt templates in a directory of fsys
+func (p *Prompts) load(fsys fs.FS, dir string) error {
+	paths, err := fs.Glob(fsys, path.Join(dir, "*"+promptExt))
+	if err != nil {
+		return fmt.Errorf("failed to list prompts: %v", err)
+	}
+	for _, file := range paths {
+		text, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return fmt.Errorf("failed to read prompt %s: %v", file, err)
+		}
+		prompt, err := parsePrompt(strings.TrimSuffix(path.Base(file), promptExt), string(text))
+		if err != nil {
+			return err
+		}
+		p.prompts[prompt.Name] = prompt
+	}
+	return nil
+}
+
+// parsePrompt parses and checks a prompt template. Rendering it with sample
+// data catches references to fields that don't exist, and prompts that
+// leave out the question or the messages.
+func parsePrompt(name, text string) (*Prompt, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prompt %s: %v", name, err)
+	}
+
+	sum := sha256.Sum256([]byte(text))
+	prompt := &Prompt{Name: name, Version: name + "@" + hex.EncodeToString(sum[:4]), tmpl: tmpl}
+
+	sample := PromptData{
+		Question: "sample question",
+		Messages: []models.Message{{Username: "sample_user", Text: "sample message", CreatedAt: time.Now()}},
+		Language: "English",
+	}
+	rendered, err := prompt.Render(sample)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(rendered, sample.Question) {
+		return nil, fmt.Errorf("invalid prompt %s: it doesn't include {{.Question}}", name)
+	}
+	if !strings.Contains(rendered, sample.Messages[0].Text) {
+		return nil, fmt.Errorf("invalid prompt %s: it doesn't include the text of .Messages", name)
+	}
+	return prompt, nil
+}
+
+// Get returns the prompt with a name, or the default prompt if the name is
+// empty or unknown, e.g. because a chat picked a prompt that was removed
+func (p *Prompts) Get(name string) *Prompt {
+	if prompt, ok := p.prompts[name]; ok {
+		return prompt
+	}
+	return p.prompts[p.def]
+}
+
+// Default returns the name of the default prompt
+func (p *Prompts) Default() string {
+	return p.def
+}
+
+// Names returns the names of all prompts, sorted
+func (p *Prompts) Names() []string {
+	names := make([]string, 0, len(p.prompts))
+	for name := range p.prompts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,169 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"SearchBot/internal/access"
+	"SearchBot/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// PermissionsUsage explains the /permissions command
+const PermissionsUsage = "Usage:\n" +
+	"/permissions - Show who may use each command\n" +
+	"/permissions <command> everyone|admins - Change who may use a command\n" +
+	"/permissions <command> allowlist <user_id> ... - Limit a command to admins and the listed users " +
+	"(reply to someone's message to add them)"
+
+// IsChatAdmin reports whether a user is an administrator or the creator of a chat
+func (b *Bot) IsChatAdmin(chatID, userID int64) bool {
+	role, err := b.access.Role(chatID, userID)
+	if err != nil {
+		slog.Error("Error getting role of user", "chat_id", chatID, "user_id", userID, "error", err)
+		return false
+	}
+	return role.IsAdmin()
+}
+
+// HandleChatMemberUpdate keeps cached roles and the membership index current
+// when members join, are promoted, demoted or leave
+func (b *Bot) HandleChatMemberUpdate(ctx context.Context, update *tgbotapi.ChatMemberUpdated) {
+	userID := update.NewChatMember.User.ID
+	role := access.RoleOf(update.NewChatMember)
+	b.access.SetRole(update.Chat.ID, userID, role)
+
+	if !update.Chat.IsGroup() && !update.Chat.IsSuperGroup() {
+		return
+	}
+	if role.IsMember() {
+		b.NoteMember(ctx, update.Chat.ID, userID)
+	} else {
+		b.forgetMember(ctx, update.Chat.ID, userID)
+	}
+}
+
+// Authorize checks whether the sender of a command may run it in this chat.
+// If not, it tells them why and returns false.
+func (b *Bot) Authorize(ctx context.Context, msg *tgbotapi.Message) bool {
+	// Policies only apply in groups
+	if !msg.Chat.IsGroup() && !msg.Chat.IsSuperGroup() {
+		return true
+	}
+	// Anonymous admins send messages on behalf of the group itself
+	if msg.SenderChat != nil && msg.SenderChat.ID == msg.Chat.ID {
+		return true
+	}
+
+	command := msg.Command()
+	policy := b.Settings(ctx, msg.Chat.ID).CommandPolicy(command)
+
+	allowed, err := b.access.Allowed(msg.Chat.ID, msg.From.ID, policy)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to check command access", "error", err)
+		b.replyDenied(ctx, msg, "❌ I couldn't check your permissions. Please try again later.")
+		return false
+	}
+	if allowed {
+		return true
+	}
+
+	slog.InfoContext(ctx, "Denied command", "user_id", msg.From.ID, "policy", policy.Access)
+	if policy.Access == models.AccessAllowlist {
+		b.replyDenied(ctx, msg, fmt.Sprintf("❌ /%s is limited to admins and selected members in this chat.", command))
+	} else {
+		b.replyDenied(ctx, msg, fmt.Sprintf("❌ Only group admins can use /%s in this chat.", command))
+	}
+	return false
+}
+
+// replyDenied answers a command that wasn't allowed
+func (b *Bot) replyDenied(ctx context.Context, msg *tgbotapi.Message, text string) {
+	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+	reply.ReplyToMessageID = msg.MessageID
+	if _, err := b.api.Send(reply); err != nil {
+		slog.ErrorContext(ctx, "Error sending access denied reply", "error", err)
+	}
+}
+
+// HandlePermissionsCommand shows or changes who may use each command
+func (b *Bot) HandlePermissionsCommand(ctx context.Context, msg *tgbotapi.Message) error {
+	if !msg.Chat.IsGroup() && !msg.Chat.IsSuperGroup() {
+		return b.sendMessage(msg.Chat.ID, "This command only works in groups.")
+	}
+
+	settings, err := b.settings.GetChatSettings(ctx, msg.Chat.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load settings: %v", err)
+	}
+
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) == 0 {
+		return b.sendMessage(msg.Chat.ID, formatPermissions(settings))
+	}
+	if len(args) < 2 {
+		return b.sendMessage(msg.Chat.ID, PermissionsUsage)
+	}
+
+	command := strings.TrimPrefix(strings.ToLower(args[0]), "/")
+	if !models.IsConfigurableCommand(command) {
+		return b.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ The policy of /%s can't be changed. Configurable commands: /%s",
+			command, strings.Join(models.ConfigurableCommands, ", /")))
+	}
+
+	var policy models.CommandPolicy
+	switch level := strings.ToLower(args[1]); level {
+	case models.AccessEveryone, models.AccessAdmins:
+		policy.Access = level
+	case models.AccessAllowlist:
+		policy.Access = models.AccessAllowlist
+		for _, arg := range args[2:] {
+			userID, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				return b.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ %q is not a user ID.\n\n%s", arg, PermissionsUsage))
+			}
+			policy.AllowedUsers = append(policy.AllowedUsers, userID)
+		}
+		if msg.ReplyToMessage != nil && msg.ReplyToMessage.From != nil {
+			policy.AllowedUsers = append(policy.AllowedUsers, msg.ReplyToMessage.From.ID)
+		}
+		if len(policy.AllowedUsers) == 0 {
+			return b.sendMessage(msg.Chat.ID, "❌ Give at least one user ID or reply to a message of the user to allow.")
+		}
+	default:
+		return b.sendMessage(msg.Chat.ID, PermissionsUsage)
+	}
+
+	settings, err = b.settings.SetChatSetting(ctx, msg.Chat.ID, "commands."+command, policy, msg.From.ID)
+	if err != nil {
+		return fmt.Errorf("failed to save settings: %v", err)
+	}
+
+	slog.InfoContext(ctx, "Changed command policy", "user_id", msg.From.ID, "target_command", command, "policy", policy.Access)
+	return b.sendMessage(msg.Chat.ID, "✅ Saved.\n\n"+formatPermissions(settings))
+}
+
+// formatPermissions lists who may use each configurable command
+func formatPermissions(settings *models.ChatSettings) string {
+	var text strings.Builder
+	text.WriteString("🔐 Who may use each command:\n\n")
+	for _, command := range models.ConfigurableCommands {
+		policy := settings.CommandPolicy(command)
+		text.WriteString(fmt.Sprintf("/%s - %s", command, policy.Access))
+		if policy.Access == models.AccessAllowlist {
+			ids := make([]string, len(policy.AllowedUsers))
+			for i, id := range policy.AllowedUsers {
+				ids[i] = strconv.FormatInt(id, 10)
+			}
+			text.WriteString(" (admins and " + strings.Join(ids, ", ") + ")")
+		}
+		text.WriteString("\n")
+	}
+	text.WriteString("/settings, /permissions, /usage - admins\n\n")
+	text.WriteString(PermissionsUsage)
+	return text.String()
+}
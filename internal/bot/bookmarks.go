@@ -0,0 +1,264 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"SearchBot/internal/models"
+	"SearchBot/internal/query"
+	"SearchBot/internal/storage"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	maxBookmarkTags   = 10  // Tags a bookmark may have
+	maxBookmarksShown = 50  // Bookmarks listed by /saved at once
+	bookmarkSnippet   = 200 // Runes of a saved message shown by /saved
+)
+
+// BookmarkUsage explains the bookmark commands
+const BookmarkUsage = "Usage:\n" +
+	"/save [tags] - Reply to a message in a group to bookmark it, e.g. /save docker howto\n" +
+	"/saved - List your bookmarks (private chat)\n" +
+	"/saved <tag or query> - List bookmarks with a tag or matching a query (private chat)\n" +
+	"/unsave - Reply to a saved message to remove its bookmark\n" +
+	"/unsave <number> - Remove a bookmark listed by /saved (private chat)"
+
+// HandleSaveCommand bookmarks the message a /save command replies to
+func (b *Bot) HandleSaveCommand(ctx context.Context, msg *tgbotapi.Message) error {
+	target := msg.ReplyToMessage
+	if msg.Chat.IsPrivate() || target == nil {
+		return b.sendMessage(msg.Chat.ID, BookmarkUsage)
+	}
+
+	// Make sure the message is stored, it may predate the bot or be a command
+	stored, err := b.storage.GetMessage(ctx, msg.Chat.ID, int64(target.MessageID))
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		if target.Text == "" || target.From == nil {
+			return b.sendMessage(msg.Chat.ID, "I can only save text messages.")
+		}
+		if err := b.HandleMessage(ctx, target); err != nil {
+			return err
+		}
+	}
+
+	bookmark := &models.Bookmark{
+		UserID:    msg.From.ID,
+		ChatID:    msg.Chat.ID,
+		MessageID: int64(target.MessageID),
+		Tags:      parseTags(msg.CommandArguments()),
+		CreatedAt: time.Now(),
+	}
+	if err := b.bookmarks.SaveBookmark(ctx, bookmark); err != nil {
+		return err
+	}
+
+	// Confirm privately, and only fall back to the group if that fails
+	text := fmt.Sprintf("🔖 Saved a message from %s.", b.chatInfo(msg.Chat.ID).title)
+	if len(bookmark.Tags) > 0 {
+		text += " Tags: " + formatTags(bookmark.Tags)
+	}
+	if err := b.sendMessage(msg.From.ID, text+"\nUse /saved to see your bookmarks."); err != nil {
+		slog.DebugContext(ctx, "Could not confirm bookmark privately", "error", err)
+		reply := tgbotapi.NewMessage(msg.Chat.ID, "🔖 Saved. Start a private chat with me and send /saved to see your bookmarks.")
+		reply.ReplyToMessageID = msg.MessageID
+		_, err = b.api.Send(reply)
+		return err
+	}
+
+	// Keep what people save to themselves, this only works if the bot is an admin
+	if _, err := b.api.Request(tgbotapi.NewDeleteMessage(msg.Chat.ID, msg.MessageID)); err != nil {
+		slog.DebugContext(ctx, "Could not delete /save command", "error", err)
+	}
+	return nil
+}
+
+// HandleSavedCommand lists the user's bookmarks, optionally only those with
+// a tag or matching a query
+func (b *Bot) HandleSavedCommand(ctx context.Context, msg *tgbotapi.Message) error {
+	if !msg.Chat.IsPrivate() {
+		return b.sendMessage(msg.Chat.ID, "Please send /saved to me in a private chat.")
+	}
+
+	bookmarks, err := b.bookmarks.GetBookmarks(ctx, msg.From.ID)
+	if err != nil {
+		return err
+	}
+	if len(bookmarks) == 0 {
+		return b.sendMessage(msg.Chat.ID, "You have no bookmarks yet.\n\n"+BookmarkUsage)
+	}
+
+	arg := strings.TrimSpace(msg.CommandArguments())
+	tag := normalizeTag(arg)
+	byTag := false
+	for i := range bookmarks {
+		if tag != "" && bookmarks[i].HasTag(tag) {
+			byTag = true
+			break
+		}
+	}
+	q := query.Parse(arg)
+
+	// Don't show messages from groups the user has left, checking each
+	// group once
+	member := make(map[int64]bool)
+	var keys []storage.MessageKey
+	for i := range bookmarks {
+		bookmark := &bookmarks[i]
+		if byTag && !bookmark.HasTag(tag) {
+			continue
+		}
+		isMember, checked := member[bookmark.ChatID]
+		if !checked {
+			role, err := b.access.Role(bookmark.ChatID, msg.From.ID)
+			isMember = err == nil && role.IsMember()
+			member[bookmark.ChatID] = isMember
+		}
+		if isMember {
+			keys = append(keys, storage.MessageKey{ChatID: bookmark.ChatID, MessageID: bookmark.MessageID})
+		}
+	}
+	messages, err := b.storage.GetMessages(ctx, keys)
+	if err != nil {
+		return err
+	}
+
+	// Numbers match the full list, so they work with /unsave
+	var entries []string
+	for i := range bookmarks {
+		bookmark := &bookmarks[i]
+		if byTag && !bookmark.HasTag(tag) {
+			continue
+		}
+
+		var message *models.Message
+		if stored, ok := messages[storage.MessageKey{ChatID: bookmark.ChatID, MessageID: bookmark.MessageID}]; ok {
+			message = &stored
+		}
+		if arg != "" && !byTag && (message == nil || !q.Matches(message.Text, message.Username)) {
+			continue
+		}
+
+		entries = append(entries, b.formatBookmark(i+1, bookmark, message, member[bookmark.ChatID]))
+		if len(entries) == maxBookmarksShown {
+			entries = append(entries, "Only the newest matches are shown, narrow them down with /saved <tag or query>.")
+			break
+		}
+	}
+
+	if len(entries) == 0 {
+		return b.sendMessage(msg.Chat.ID, fmt.Sprintf("None of your bookmarks match %q.", arg))
+	}
+
+	// Telegram rejects longer messages, so long lists are sent in parts
+	for _, text := range splitMessage(entries, "\n\n") {
+		reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+		reply.DisableWebPagePreview = true
+		if _, err := b.api.Send(reply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatBookmark describes a bookmark for /saved. message is the saved
+// message, or nil if it was deleted or the user isn't a member of its group
+// anymore. Deleted messages are shown as tombstones so the bookmark can
+// still be removed.
+func (b *Bot) formatBookmark(n int, bookmark *models.Bookmark, message *models.Message, member bool) string {
+	info := b.chatInfo(bookmark.ChatID)
+	tags := ""
+	if len(bookmark.Tags) > 0 {
+		tags = "\n" + formatTags(bookmark.Tags)
+	}
+
+	if !member {
+		return fmt.Sprintf("%d. [%s] 🔒 You're no longer in this group%s", n, info.title, tags)
+	}
+	if message == nil {
+		return fmt.Sprintf("%d. [%s] 🪦 This message was deleted (saved %s)%s",
+			n, info.title, bookmark.CreatedAt.Format("2006-01-02"), tags)
+	}
+
+	edited := ""
+	if !message.EditedAt.IsZero() {
+		edited = " (edited)"
+	}
+	return fmt.Sprintf("%d. [%s] %s%s: %s\n%s%s", n, info.title, message.Author(), edited,
+		truncate(message.Text, bookmarkSnippet),
+		b.generateMessageURL(message.ChatID, message.MessageID, info.username), tags)
+}
+
+// HandleUnsaveCommand removes a bookmark, either of the message the command
+// replies to or by its number in /saved
+func (b *Bot) HandleUnsaveCommand(ctx context.Context, msg *tgbotapi.Message) error {
+	var chatID, messageID int64
+	if target := msg.ReplyToMessage; target != nil && !msg.Chat.IsPrivate() {
+		chatID, messageID = msg.Chat.ID, int64(target.MessageID)
+	} else if msg.Chat.IsPrivate() {
+		bookmarks, err := b.bookmarks.GetBookmarks(ctx, msg.From.ID)
+		if err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(msg.CommandArguments()))
+		if err != nil || n < 1 || n > len(bookmarks) {
+			return b.sendMessage(msg.Chat.ID, BookmarkUsage)
+		}
+		chatID, messageID = bookmarks[n-1].ChatID, bookmarks[n-1].MessageID
+	} else {
+		return b.sendMessage(msg.Chat.ID, BookmarkUsage)
+	}
+
+	removed, err := b.bookmarks.DeleteBookmark(ctx, msg.From.ID, chatID, messageID)
+	if err != nil {
+		return err
+	}
+
+	text := "You hadn't saved that message."
+	if removed {
+		text = "🗑 Bookmark removed."
+	}
+	if msg.Chat.IsPrivate() {
+		return b.sendMessage(msg.Chat.ID, text)
+	}
+	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+	reply.ReplyToMessageID = msg.MessageID
+	_, err = b.api.Send(reply)
+	return err
+}
+
+// parseTags turns "#docker HowTo docker" into ["docker", "howto"]
+func parseTags(text string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, field := range strings.Fields(text) {
+		tag := normalizeTag(field)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+		if len(tags) == maxBookmarkTags {
+			break
+		}
+	}
+	return tags
+}
+
+// normalizeTag lowercases a tag and strips its leading #
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimLeft(strings.TrimSpace(tag), "#"))
+}
+
+// formatTags shows tags as hashtags
+func formatTags(tags []string) string {
+	return "#" + strings.Join(tags, " #")
+}
@@ -2,37 +2,154 @@ package bot
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"log"
-	"sort"
+	"log/slog"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf16"
 
+	"SearchBot/internal/access"
 	"SearchBot/internal/ai"
+	"SearchBot/internal/answer"
+	"SearchBot/internal/logging"
+	"SearchBot/internal/metrics"
 	"SearchBot/internal/models"
+	"SearchBot/internal/ratelimit"
 	"SearchBot/internal/search"
 	"SearchBot/internal/storage"
+	"SearchBot/internal/watch"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 // Bot handles Telegram bot functionality
 type Bot struct {
-	api     *tgbotapi.BotAPI
-	ai      *ai.GeminiAI
-	search  *search.MeiliSearch
-	storage storage.MessageStorage
+	api       *tgbotapi.BotAPI
+	ai        *ai.GeminiAI
+	prompts   *answer.Prompts
+	search    *search.MeiliSearch
+	indexer   *search.Indexer
+	storage   storage.MessageStorage
+	settings  storage.SettingsStorage
+	usage     storage.UsageStorage
+	watches   storage.WatchStorage
+	bookmarks storage.BookmarkStorage
+	answers   storage.AnswerStorage
+	members   storage.MembershipStorage
+	access    *access.Checker
+	limits    Limits
+
+	seenMu     sync.Mutex
+	seen       map[membership]time.Time // When memberships were last recorded
+	seenPruned time.Time                // When old entries were last removed from seen
+
+	chatsMu sync.Mutex
+	chats   map[int64]cachedChat // Titles of groups, for labelling cross-group results
+
+	inlineMu sync.Mutex
+	inline   map[inlineKey]cachedInline // Recent inline query answers
+
+	watchIndex *watch.Index // Active watches, for matching new messages
+
+	notifyMu      sync.Mutex
+	notifyClosed  bool
+	notifications chan watchNotification // Matched watches waiting to be sent
+	notifiers     sync.WaitGroup
+}
+
+// Limits caps how much each chat may use the bot
+type Limits struct {
+	Commands        map[string]*ratelimit.Limiter // Rate limits by command
+	Inline          *ratelimit.Limiter            // Rate limit of inline queries, nil for no limit
+	DailyTokenQuota int64                         // AI tokens per chat per day, 0 for no limit
 }
 
 // NewBot creates a new Bot instance
-func NewBot(api *tgbotapi.BotAPI, ai *ai.GeminiAI, search *search.MeiliSearch, storage storage.MessageStorage) *Bot {
-	return &Bot{
-		api:     api,
-		ai:      ai,
-		search:  search,
-		storage: storage,
+func NewBot(api *tgbotapi.BotAPI, ai *ai.GeminiAI, prompts *answer.Prompts, search *search.MeiliSearch, indexer *search.Indexer, storage storage.MessageStorage, settings storage.SettingsStorage, usage storage.UsageStorage, watches storage.WatchStorage, bookmarks storage.BookmarkStorage, answers storage.AnswerStorage, members storage.MembershipStorage, access *access.Checker, limits Limits) *Bot {
+	b := &Bot{
+		api:       api,
+		ai:        ai,
+		prompts:   prompts,
+		search:    search,
+		indexer:   indexer,
+		storage:   storage,
+		settings:  settings,
+		usage:     usage,
+		watches:   watches,
+		bookmarks: bookmarks,
+		answers:   answers,
+		members:   members,
+		access:    access,
+		limits:    limits,
+		seen:      make(map[membership]time.Time),
+		chats:     make(map[int64]cachedChat),
+		inline:    make(map[inlineKey]cachedInline),
+
+		watchIndex:    watch.NewIndex(),
+		notifications: make(chan watchNotification, watchQueueSize),
+	}
+
+	for i := 0; i < watchSenders; i++ {
+		b.notifiers.Add(1)
+		go b.sendWatchNotifications()
+	}
+	return b
+}
+
+// maxMessageLength is the most UTF-16 code units Telegram accepts in a message
+const maxMessageLength = 4096
+
+// splitMessage joins parts with sep into as few messages as Telegram
+// accepts. Parts are only split if they are too long by themselves.
+func splitMessage(parts []string, sep string) []string {
+	var messages []string
+	var current strings.Builder
+	currentLen := 0
+	for _, part := range parts {
+		for _, piece := range cutText(part, maxMessageLength) {
+			pieceLen := utf16Len(piece)
+			if current.Len() > 0 && currentLen+utf16Len(sep)+pieceLen > maxMessageLength {
+				messages = append(messages, current.String())
+				current.Reset()
+				currentLen = 0
+			}
+			if current.Len() > 0 {
+				current.WriteString(sep)
+				currentLen += utf16Len(sep)
+			}
+			current.WriteString(piece)
+			currentLen += pieceLen
+		}
+	}
+	if current.Len() > 0 {
+		messages = append(messages, current.String())
+	}
+	return messages
+}
+
+// cutText cuts text into pieces of at most n UTF-16 code units
+func cutText(text string, n int) []string {
+	var pieces []string
+	start, length := 0, 0
+	for i, r := range text {
+		if length+utf16.RuneLen(r) > n {
+			pieces = append(pieces, text[start:i])
+			start, length = i, 0
+		}
+		length += utf16.RuneLen(r)
+	}
+	return append(pieces, text[start:])
+}
+
+// utf16Len returns the length of text in UTF-16 code units, which is how
+// Telegram measures messages
+func utf16Len(text string) int {
+	n := 0
+	for _, r := range text {
+		n += utf16.RuneLen(r)
 	}
+	return n
 }
 
 // sendMessage sends a message to a chat
@@ -44,19 +161,18 @@ func (b *Bot) sendMessage(chatID int64, text string) error {
 
 // HandleAskCommand handles the /ask command
 func (b *Bot) HandleAskCommand(ctx context.Context, msg *tgbotapi.Message) error {
+	if msg.Chat.IsPrivate() {
+		return b.handlePrivateAsk(ctx, msg)
+	}
+
 	// First check if bot has necessary permissions
-	chatMember, err := b.api.GetChatMember(tgbotapi.GetChatMemberConfig{
-		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{
-			ChatID: msg.Chat.ID,
-			UserID: b.api.Self.ID,
-		},
-	})
+	role, err := b.access.Role(msg.Chat.ID, b.api.Self.ID)
 	if err != nil {
 		return fmt.Errorf("failed to check bot permissions: %v", err)
 	}
 
 	// Check if bot is admin and has message access
-	if chatMember.Status != "administrator" {
+	if role != access.RoleAdmin {
 		return b.sendMessage(msg.Chat.ID,
 			"⚠️ I need to be an administrator to access message history.\n"+
 				"Please make me an administrator with these permissions:\n"+
@@ -64,22 +180,23 @@ func (b *Bot) HandleAskCommand(ctx context.Context, msg *tgbotapi.Message) error
 				"- Send Messages")
 	}
 
+	settings := b.Settings(ctx, msg.Chat.ID)
+
 	// Extract the question from the message
-	question := strings.TrimSpace(strings.TrimPrefix(msg.Text, "/ask"))
+	question := strings.TrimSpace(msg.CommandArguments())
 	if question == "" {
 		return b.sendMessage(msg.Chat.ID, "Please provide a question after /ask")
 	}
 
-	log.Printf("Processing question: %s", question)
+	slog.InfoContext(ctx, "Processing question", logging.Content("question", question))
 
-	// Get recent messages from MongoDB (last 100 messages)
-	messages, err := b.storage.GetRecentMessages(msg.Chat.ID, 100)
+	// Get the recent messages this chat lets /ask look at
+	messages, err := b.storage.GetRecentMessages(ctx, msg.Chat.ID, int64(settings.ContextSize))
 	if err != nil {
-		log.Printf("Failed to fetch messages from MongoDB: %v", err)
 		return fmt.Errorf("failed to fetch messages: %v", err)
 	}
 
-	log.Printf("Found %d messages in database", len(messages))
+	slog.DebugContext(ctx, "Loaded recent messages", "count", len(messages))
 
 	if len(messages) == 0 {
 		return b.sendMessage(msg.Chat.ID,
@@ -90,155 +207,92 @@ func (b *Bot) HandleAskCommand(ctx context.Context, msg *tgbotapi.Message) error
 				"Please make sure I'm an administrator with message access and wait for new messages to be indexed.")
 	}
 
-	// Format all messages for AI analysis
-	var messagesText strings.Builder
-	for _, message := range messages {
-		messagesText.WriteString(fmt.Sprintf("@%s: %s\n", message.Username, message.Text))
-	}
-
-	log.Printf("Sending %d messages to AI for analysis", len(messages))
-
-	// Let AI analyze the messages and user's question
-	analysisPrompt := fmt.Sprintf(`You are an intelligent search assistant for a coding group chat.
-A user asked: '%s'
-
-Here are ALL the recent messages from our chat:
-%s
+	return b.answerQuestion(ctx, msg.Chat.ID, msg.From.ID, question, askHistory{messages: messages}, settings)
+}
 
-Your task is to find messages that would help answer their question, even if they use completely different terms.
-Think about:
-1. What the user is trying to find or learn about - consider synonyms, related concepts, and specific products/tools
-2. Which messages discuss relevant tools/concepts, even if they use different names
-3. Messages that mention alternatives or related approaches
-4. The context and flow of conversations - look for related messages before and after key discussions
+// askHistory is the history an /ask question is answered from
+type askHistory struct {
+	messages []models.Message
+	titles   map[int64]string // Group names to label results with, nil within a group
+	noAI     map[int64]bool   // Groups whose messages must not be sent to the AI
+}
 
-For example:
-- If someone asks about "AI models" or "language models", find messages about specific AI models like ChatGPT, DeepSeek, Claude, etc.
-- If they ask about "AWS testing tools" or "local cloud testing", find messages about LocalStack
-- If they ask about "collecting website data" or "data extraction", find messages about web scraping
+// answerQuestion finds the messages that answer a question and replies with
+// links to them in chatID. The answer is recorded so it can be rated.
+func (b *Bot) answerQuestion(ctx context.Context, chatID, userID int64, question string, history askHistory, settings *models.ChatSettings) error {
+	messages := history.messages
 
-When you find relevant messages:
-1. Explain WHY these messages are relevant to their question
-2. Point out the semantic connections (e.g. "DeepSeek is an AI model that was discussed here")
-3. Include enough context to understand the discussion
-4. IMPORTANT: You MUST include the EXACT messages in your response, including username and text
+	// The AI is charged to the groups whose messages it reads, which are
+	// not chatID for a question asked in a private chat
+	var aiMessages []models.Message
+	for _, message := range messages {
+		if !history.noAI[message.ChatID] {
+			aiMessages = append(aiMessages, message)
+		}
+	}
 
-Your response must be a raw JSON object with NO FORMATTING AT ALL.
-Example: {"relevant_messages":["@username: exact message text"],"explanation":"why these messages are helpful"}
+	// Fall back to keyword matching once the daily AI quota of any of these
+	// groups is used up
+	useAI := settings.AIEnabled
+	quotaExceeded := false
+	var reserved map[int64]int64
+	if useAI {
+		reserved, useAI = b.reserveAIQuota(ctx, question, aiMessages)
+		if !useAI {
+			slog.InfoContext(ctx, "Daily AI quota is used up, using keyword matching")
+			quotaExceeded = true
+		}
+	}
 
-Remember: 
-1. Focus on finding messages that would actually help them, even if the messages use completely different terminology
-2. You MUST include the EXACT messages in your response, do not paraphrase or summarize them
-3. Include ALL relevant messages, even if they seem similar`,
-		question, messagesText.String())
+	var model answer.Model
+	if useAI {
+		model = b.ai
+	} else {
+		aiMessages = nil
+	}
 
-	analysis, err := b.ai.AnswerQuestion(ctx, analysisPrompt, nil)
+	// If AI is off or finds nothing, look for messages containing keywords from the question
+	result, err := answer.Find(ctx, model, b.prompts.Get(settings.Prompt), question, aiMessages, messages, settings.Language)
+	b.recordAIUsage(ctx, aiMessages, result.Usage, reserved)
 	if err != nil {
-		return fmt.Errorf("failed to analyze messages: %v", err)
+		return err
 	}
 
-	log.Printf("Received AI analysis response: %s", analysis)
+	slog.DebugContext(ctx, "Found relevant messages", "count", len(result.RelevantMessages))
 
-	// Clean and parse the AI response
-	analysis = cleanJSONResponse(analysis)
+	// Track how often the AI answers and how often we fall back to keywords
+	metrics.AskAnswers.WithLabelValues(result.Source).Inc()
 
-	var result struct {
-		RelevantMessages []string `json:"relevant_messages"`
-		Explanation      string   `json:"explanation"`
-	}
-	if err := json.Unmarshal([]byte(analysis), &result); err != nil {
-		log.Printf("Failed to parse AI response: %v", err)
-		log.Printf("Raw response: %s", analysis)
-		// Try to recover by searching for messages ourselves
-		keywords := extractSignificantTerms(question)
-		for _, message := range messages {
-			messageTerms := extractSignificantTerms(message.Text)
-			if hasCommonTerms(keywords, messageTerms) {
-				result.RelevantMessages = append(result.RelevantMessages,
-					fmt.Sprintf("@%s: %s", message.Username, message.Text))
-			}
-		}
-		if len(result.RelevantMessages) > 0 {
-			result.Explanation = "Found some messages that might be relevant to your question."
-		}
+	// If still no relevant messages found
+	if result.Source == answer.SourceNone {
+		return b.sendMessage(chatID, "I couldn't find any relevant discussions about this topic in our chat history. You might be the first one to bring this up!")
 	}
-
-	log.Printf("Found %d relevant messages", len(result.RelevantMessages))
-
-	// If no relevant messages found in AI response, try to find them ourselves
-	if len(result.RelevantMessages) == 0 {
-		// Search for messages containing keywords from the question
-		keywords := extractSignificantTerms(question)
-		for _, message := range messages {
-			messageTerms := extractSignificantTerms(message.Text)
-			if hasCommonTerms(keywords, messageTerms) {
-				result.RelevantMessages = append(result.RelevantMessages,
-					fmt.Sprintf("@%s: %s", message.Username, message.Text))
-			}
+	if result.Source == answer.SourceKeyword && quotaExceeded {
+		if history.titles != nil {
+			result.Explanation = "Today's AI quota of one of your groups is used up, so these are keyword matches. " + result.Explanation
+		} else {
+			result.Explanation = "Today's AI quota for this chat is used up, so these are keyword matches. " + result.Explanation
 		}
 	}
 
-	// If still no relevant messages found
-	if len(result.RelevantMessages) == 0 {
-		return b.sendMessage(msg.Chat.ID, "I couldn't find any relevant discussions about this topic in our chat history. You might be the first one to bring this up!")
-	}
-
 	// Format the response
 	var response strings.Builder
 	response.WriteString(result.Explanation)
 	response.WriteString("\n\nHere are the relevant discussions:\n\n")
 
-	// Create message entities for clickable links
+	// Create message entities for clickable links. Telegram counts entity
+	// offsets in UTF-16 code units, not bytes.
 	var entities []tgbotapi.MessageEntity
 
-	baseOffset := len(result.Explanation) + len("\n\nHere are the relevant discussions:\n\n")
+	baseOffset := utf16Len(result.Explanation) + utf16Len("\n\nHere are the relevant discussions:\n\n")
 
 	// Group messages by conversation
 	var currentUsername string
 	var currentConversation []models.Message
 	var allConversations [][]models.Message
 
-	// First, convert relevant messages to actual Message objects
-	var relevantMessages []models.Message
-	for _, relevantMsg := range result.RelevantMessages {
-		// Find the corresponding message
-		found := false
-		for _, m := range messages {
-			// Clean up the message text for comparison
-			cleanedRelevantMsg := strings.TrimSpace(relevantMsg)
-			cleanedText := strings.TrimSpace(m.Text)
-
-			// Try exact match first
-			if cleanedText == cleanedRelevantMsg {
-				relevantMessages = append(relevantMessages, m)
-				found = true
-				break
-			}
-
-			// If no exact match, try to find the text within the message
-			if strings.Contains(cleanedText, cleanedRelevantMsg) {
-				// Create a new message with the matched text
-				newMsg := m
-				newMsg.Text = relevantMsg
-				relevantMessages = append(relevantMessages, newMsg)
-				found = true
-				break
-			}
-
-			// If the relevant message contains multiple messages, try to match the original
-			if strings.Contains(cleanedRelevantMsg, cleanedText) {
-				relevantMessages = append(relevantMessages, m)
-				found = true
-				break
-			}
-		}
-
-		if !found {
-			log.Printf("Could not find original message for: %s", relevantMsg)
-		}
-	}
-
-	log.Printf("Successfully mapped %d relevant messages to original messages", len(relevantMessages))
+	relevantMessages := result.Cited
+	slog.DebugContext(ctx, "Mapped relevant messages to original messages", "count", len(relevantMessages))
 
 	// Group messages by username
 	for _, msg := range relevantMessages {
@@ -259,7 +313,7 @@ Remember:
 		allConversations = append(allConversations, currentConversation)
 	}
 
-	log.Printf("Grouped messages into %d conversations", len(allConversations))
+	slog.DebugContext(ctx, "Grouped messages into conversations", "count", len(allConversations))
 
 	// Format conversations with numbers
 	for i, conversation := range allConversations {
@@ -276,10 +330,13 @@ Remember:
 
 			// Format the message
 			var fullMessage string
-			if j == 0 {
-				fullMessage = fmt.Sprintf("%d. @%s: %s\n", i+1, message.Username, message.Text)
+			if title, ok := history.titles[message.ChatID]; ok {
+				fullMessage = fmt.Sprintf("[%s] %s: %s\n", title, message.Author(), message.Text)
 			} else {
-				fullMessage = fmt.Sprintf("@%s: %s\n", message.Username, message.Text)
+				fullMessage = fmt.Sprintf("%s: %s\n", message.Author(), message.Text)
+			}
+			if j == 0 {
+				fullMessage = fmt.Sprintf("%d. %s", i+1, fullMessage)
 			}
 			response.WriteString(fullMessage)
 
@@ -295,19 +352,18 @@ Remember:
 				// Add the 100 prefix
 				chatIDStr = "100" + chatIDStr
 			}
-			log.Printf("Final chatID: %s, messageID: %d", chatIDStr, message.MessageID)
+			slog.DebugContext(ctx, "Linking message", "link_chat_id", chatIDStr, "message_id", message.MessageID)
 
 			// Add text_link entity for the entire message line
 			messageURL := b.generateMessageURL(message.ChatID, message.MessageID, message.ChatUsername)
-			log.Printf("Generated URL: %s", messageURL)
 			entities = append(entities, tgbotapi.MessageEntity{
 				Type:   "text_link",
 				Offset: baseOffset,
-				Length: len(fullMessage) - 1, // -1 to exclude the newline
+				Length: utf16Len(fullMessage) - 1, // -1 to exclude the newline
 				URL:    messageURL,
 			})
 
-			baseOffset += len(fullMessage)
+			baseOffset += utf16Len(fullMessage)
 		}
 
 		// Add a newline between conversations
@@ -321,318 +377,40 @@ Remember:
 		"(Make sure I'm an administrator to access message history)")
 
 	// Send message with entities
-	replyMsg := tgbotapi.NewMessage(msg.Chat.ID, response.String())
+	replyMsg := tgbotapi.NewMessage(chatID, response.String())
 	replyMsg.Entities = entities
 	replyMsg.ParseMode = "" // Ensure no parsing mode interferes with our entities
-	_, err = b.api.Send(replyMsg)
-	if err != nil {
-		log.Printf("Failed to send response: %v", err)
-		// Try sending without entities as fallback
-		return b.sendMessage(msg.Chat.ID, response.String())
-	}
-	return nil
-}
 
-// cleanJSONResponse cleans up the AI's response to extract valid JSON
-func cleanJSONResponse(response string) string {
-	response = strings.TrimSpace(response)
-	response = strings.ReplaceAll(response, "```json", "")
-	response = strings.ReplaceAll(response, "```", "")
-	response = strings.ReplaceAll(response, "`", "")
-	response = strings.ReplaceAll(response, "\n", "")
-	response = strings.ReplaceAll(response, "\r", "")
-	response = strings.ReplaceAll(response, "\t", "")
-
-	// Extract JSON between first { and last }
-	start := strings.Index(response, "{")
-	end := strings.LastIndex(response, "}")
-	if start != -1 && end != -1 && end > start {
-		response = response[start : end+1]
+	// Record the answer so it can be rated with the buttons below it
+	record := &models.Answer{
+		ChatID:      chatID,
+		UserID:      userID,
+		Question:    question,
+		Candidates:  messageRefs(result.Candidates),
+		Cited:       messageRefs(relevantMessages),
+		Explanation: result.Explanation,
+		Source:      result.Source,
+		CreatedAt:   time.Now(),
 	}
-
-	return strings.TrimSpace(response)
-}
-
-// groupMessagesByContext groups messages based on their semantic context
-func groupMessagesByContext(messages []models.Message, relevanceCriteria string) [][]models.Message {
-	if len(messages) == 0 {
-		return nil
+	if result.Source == answer.SourceAI {
+		record.Model = b.ai.Model()
+		record.PromptVersion = result.PromptVersion
 	}
-
-	// Sort messages by time
-	sort.Slice(messages, func(i, j int) bool {
-		return messages[i].CreatedAt.Before(messages[j].CreatedAt)
-	})
-
-	// Group messages that are semantically related and within time window
-	const conversationTimeout = 2 * time.Minute
-	var conversations [][]models.Message
-	currentConvo := []models.Message{messages[0]}
-
-	for i := 1; i < len(messages); i++ {
-		timeDiff := messages[i].CreatedAt.Sub(messages[i-1].CreatedAt)
-
-		// Check if messages are related by:
-		// 1. Time proximity
-		// 2. Direct replies
-		// 3. Shared context (based on the AI's relevance criteria)
-		isRelated := false
-
-		// Time proximity check
-		if timeDiff <= conversationTimeout {
-			isRelated = true
-		}
-
-		// Direct reply check
-		if isDirectReply(messages[i-1].Text, messages[i].Text) {
-			isRelated = true
-		}
-
-		// Context similarity check (if messages share significant terms)
-		prevWords := extractSignificantTerms(messages[i-1].Text)
-		currWords := extractSignificantTerms(messages[i].Text)
-		if hasCommonTerms(prevWords, currWords) {
-			isRelated = true
-		}
-
-		if isRelated {
-			currentConvo = append(currentConvo, messages[i])
-		} else {
-			if len(currentConvo) > 0 {
-				conversations = append(conversations, currentConvo)
-			}
-			currentConvo = []models.Message{messages[i]}
-		}
-	}
-
-	if len(currentConvo) > 0 {
-		conversations = append(conversations, currentConvo)
-	}
-
-	return conversations
-}
-
-// extractSignificantTerms extracts meaningful terms from text
-func extractSignificantTerms(text string) []string {
-	text = strings.ToLower(text)
-	words := strings.Fields(text)
-	var terms []string
-
-	for _, word := range words {
-		// Clean the word
-		word = strings.Trim(word, ".,!?()[]{}:;\"'")
-
-		// Keep significant words
-		if len(word) > 3 && !isCommonWord(word) {
-			terms = append(terms, word)
-		}
-	}
-
-	return terms
-}
-
-// hasCommonTerms checks if two sets of terms share any significant words
-func hasCommonTerms(terms1, terms2 []string) bool {
-	// Create map of first set of terms
-	termMap := make(map[string]bool)
-	for _, term := range terms1 {
-		termMap[term] = true
-	}
-
-	// Check if any term from second set exists in map
-	for _, term := range terms2 {
-		if termMap[term] {
-			return true
-		}
-		// Also check for substring matches
-		for term1 := range termMap {
-			if len(term1) > 3 && len(term) > 3 {
-				if strings.Contains(term1, term) || strings.Contains(term, term1) {
-					return true
-				}
-			}
-		}
-	}
-
-	return false
-}
-
-// isTopicRelated checks if two topics are related
-func isTopicRelated(topic1, topic2 string) bool {
-	// If either topic is empty, they're not related
-	if topic1 == "" || topic2 == "" {
-		return false
-	}
-
-	// Topics are related if:
-	// 1. They are exactly the same
-	if topic1 == topic2 {
-		return true
-	}
-
-	// 2. They are part of the same technical group
-	technicalGroups := map[string][]string{
-		"stack":  {"localstack", "aws", "cloud", "docker"},
-		"scrape": {"crawler", "crawling", "scraping", "extract"},
-		"docker": {"container", "localstack", "stack"},
-		"aws":    {"localstack", "cloud", "stack"},
-	}
-
-	// Check if topics belong to the same group
-	for _, group := range technicalGroups {
-		inGroup1 := false
-		inGroup2 := false
-		for _, term := range group {
-			if strings.Contains(topic1, term) {
-				inGroup1 = true
-			}
-			if strings.Contains(topic2, term) {
-				inGroup2 = true
-			}
-		}
-		if inGroup1 && inGroup2 {
-			return true
-		}
-	}
-
-	// 3. One contains the other
-	if strings.Contains(topic1, topic2) || strings.Contains(topic2, topic1) {
-		return true
-	}
-
-	return false
-}
-
-// isDirectReply checks if a message is a direct reply to the previous message
-func isDirectReply(prevText, currText string) bool {
-	// Convert to lowercase for consistent matching
-	prevText = strings.ToLower(prevText)
-	currText = strings.ToLower(currText)
-
-	// Check if it's a short response (less than 5 words) to a question
-	if strings.HasSuffix(prevText, "?") {
-		words := strings.Fields(currText)
-		if len(words) < 5 {
-			return true
-		}
-	}
-
-	// Check if the current message references words from the previous message
-	prevWords := strings.Fields(prevText)
-	currWords := strings.Fields(currText)
-
-	// Get significant words from previous message
-	var significantPrevWords []string
-	for _, word := range prevWords {
-		if len(word) > 3 && !isCommonWord(word) {
-			significantPrevWords = append(significantPrevWords, word)
-		}
-	}
-
-	// Check if current message contains any significant words from previous message
-	for _, currWord := range currWords {
-		for _, prevWord := range significantPrevWords {
-			if strings.Contains(strings.ToLower(currWord), strings.ToLower(prevWord)) {
-				return true
-			}
-		}
-	}
-
-	return false
-}
-
-// isCommonWord returns true if the word is too common to be useful for topic detection
-func isCommonWord(word string) bool {
-	word = strings.ToLower(word)
-	commonWords := map[string]bool{
-		"the": true, "be": true, "to": true, "of": true, "and": true,
-		"a": true, "in": true, "that": true, "have": true, "i": true,
-		"it": true, "for": true, "not": true, "on": true, "with": true,
-		"he": true, "as": true, "you": true, "do": true, "at": true,
-		"this": true, "but": true, "his": true, "by": true, "from": true,
-		"they": true, "we": true, "say": true, "her": true, "she": true,
-		"or": true, "an": true, "will": true, "my": true, "one": true,
-		"all": true, "would": true, "there": true, "their": true, "what": true,
-		"was": true, "were": true, "been": true, "being": true, "into": true,
-		"who": true, "whom": true, "whose": true, "which": true, "where": true,
-		"when": true, "why": true, "how": true, "any": true, "some": true,
-		"can": true, "could": true, "may": true, "might": true, "must": true,
-		"shall": true, "should": true, "about": true, "many": true, "most": true,
-		"other": true, "such": true, "than": true, "then": true, "these": true,
-		"those": true, "only": true, "very": true, "also": true, "just": true,
-		"know": true, "like": true, "time": true, "make": true, "see": true,
-		"find": true, "want": true, "does": true, "need": true, "going": true,
-		"after": true, "again": true, "our": true, "well": true, "way": true,
-		"even": true, "new": true, "because": true, "give": true, "day": true,
-		"anyone": true, "anybody": true, "anything": true, "everyone": true,
-		"everybody": true, "everything": true, "someone": true, "somebody": true,
-		"something": true, "nothing": true, "nobody": true, "none": true,
-	}
-	return commonWords[word]
-}
-
-// GetChatHistory fetches recent messages from a chat
-func (b *Bot) GetChatHistory(chatID int64, limit int) ([]tgbotapi.Message, error) {
-	var allMessages []tgbotapi.Message
-
-	// Get chat member info to verify permissions
-	chatMember, err := b.api.GetChatMember(tgbotapi.GetChatMemberConfig{
-		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{
-			ChatID: chatID,
-			UserID: b.api.Self.ID,
-		},
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to check bot permissions: %v", err)
+	if err := b.answers.SaveAnswer(ctx, record); err != nil {
+		slog.WarnContext(ctx, "Failed to record answer, sending it without rating buttons", "error", err)
+	} else {
+		replyMsg.ReplyMarkup = feedbackKeyboard(record)
 	}
 
-	// Check if bot has necessary permissions
-	if chatMember.Status != "administrator" {
-		return nil, fmt.Errorf("bot needs to be an administrator to access message history")
-	}
-
-	// Send a temporary message to get current message ID
-	msg := tgbotapi.NewMessage(chatID, "🔍 Fetching message history...")
-	sentMsg, err := b.api.Send(msg)
+	_, err = b.api.Send(replyMsg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send message: %v", err)
-	}
-
-	// Delete the temporary message
-	deleteMsg := tgbotapi.NewDeleteMessage(chatID, sentMsg.MessageID)
-	b.api.Request(deleteMsg)
-
-	// Iterate through recent message IDs
-	for i := 1; i <= limit; i++ {
-		msgID := sentMsg.MessageID - i
-		if msgID <= 0 {
-			break
-		}
-
-		// Try to get the message by replying to it
-		getMsg := tgbotapi.NewMessage(chatID, ".")
-		getMsg.ReplyToMessageID = msgID
-
-		msg, err := b.api.Send(getMsg)
-		if err != nil {
-			// Skip messages we can't access
-			continue
-		}
-
-		// If we got a reply, that means we can access the original message
-		if msg.ReplyToMessage != nil && msg.ReplyToMessage.Text != "" {
-			allMessages = append(allMessages, *msg.ReplyToMessage)
-		}
-
-		// Delete our message
-		deleteMsg = tgbotapi.NewDeleteMessage(chatID, msg.MessageID)
-		b.api.Request(deleteMsg)
-
-		// Rate limiting
-		time.Sleep(100 * time.Millisecond)
+		slog.WarnContext(ctx, "Failed to send response with links, retrying without", "error", err)
+		// Try sending without entities as fallback
+		replyMsg.Entities = nil
+		_, err = b.api.Send(replyMsg)
+		return err
 	}
-
-	return allMessages, nil
+	return nil
 }
 
 // generateMessageURL generates a URL to a specific message
@@ -660,7 +438,7 @@ func (b *Bot) generateMessageURL(chatID int64, messageID int64, username string)
 }
 
 // HandleMessage processes a new message
-func (b *Bot) HandleMessage(msg *tgbotapi.Message) error {
+func (b *Bot) HandleMessage(ctx context.Context, msg *tgbotapi.Message) error {
 	// Convert to our message model
 	message := &models.Message{
 		MessageID:    int64(msg.MessageID),
@@ -669,17 +447,22 @@ func (b *Bot) HandleMessage(msg *tgbotapi.Message) error {
 		UserID:       msg.From.ID,
 		Username:     msg.From.UserName,
 		Text:         msg.Text,
-		CreatedAt:    time.Now(),
+		CreatedAt:    msg.Time(),
 	}
 
+	return b.storeMessage(ctx, message)
+}
+
+// storeMessage stores a message in MongoDB and queues it for indexing
+func (b *Bot) storeMessage(ctx context.Context, message *models.Message) error {
 	// Store in MongoDB
-	if err := b.storage.StoreMessage(message); err != nil {
+	if err := b.storage.StoreMessage(ctx, message); err != nil {
 		return fmt.Errorf("failed to store message: %v", err)
 	}
 
-	// Index in Meilisearch
-	if err := b.search.IndexMessage(message); err != nil {
-		return fmt.Errorf("failed to index message: %v", err)
+	// Queue for batched indexing in Meilisearch
+	if err := b.indexer.Add(ctx, message); err != nil {
+		return fmt.Errorf("failed to queue message for indexing: %v", err)
 	}
 
 	return nil
@@ -0,0 +1,293 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"SearchBot/internal/logging"
+	"SearchBot/internal/query"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// crossChatContextSize caps how many recent messages a private /ask looks at
+// across all of the caller's groups
+const crossChatContextSize = 500
+
+// chatInfoTTL is how long a group's title is reused before asking again,
+// so renamed groups show their new title
+const chatInfoTTL = time.Hour
+
+// chatInfo is how a group is shown in cross-group results
+type chatInfo struct {
+	title    string
+	username string
+}
+
+// cachedChat is a group's chatInfo and when it stops being reused
+type cachedChat struct {
+	info    chatInfo
+	expires time.Time
+}
+
+// chatInfo returns a group's title and public username, asking Telegram
+// when the group wasn't shown within chatInfoTTL
+func (b *Bot) chatInfo(chatID int64) chatInfo {
+	b.chatsMu.Lock()
+	cached, ok := b.chats[chatID]
+	b.chatsMu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.info
+	}
+
+	chat, err := b.api.GetChat(tgbotapi.ChatInfoConfig{ChatConfig: tgbotapi.ChatConfig{ChatID: chatID}})
+	if err != nil {
+		slog.Warn("Failed to get chat info", "chat_id", chatID, "error", err)
+		return chatInfo{title: fmt.Sprintf("Group %d", chatID)}
+	}
+
+	info := chatInfo{title: chat.Title, username: chat.UserName}
+	if info.title == "" {
+		info.title = fmt.Sprintf("Group %d", chatID)
+	}
+
+	b.chatsMu.Lock()
+	now := time.Now()
+	for id, cached := range b.chats {
+		if now.After(cached.expires) {
+			delete(b.chats, id)
+		}
+	}
+	b.chats[chatID] = cachedChat{info: info, expires: now.Add(chatInfoTTL)}
+	b.chatsMu.Unlock()
+	return info
+}
+
+// membershipRefresh is how often a user's membership in a group is recorded
+// again while they keep writing there
+const membershipRefresh = time.Hour
+
+// membership is a user in a group
+type membership struct {
+	chatID int64
+	userID int64
+}
+
+// NoteMember records that a user wrote in a group, so private /search and
+// /ask look there. Repeated messages are only recorded once an hour.
+func (b *Bot) NoteMember(ctx context.Context, chatID, userID int64) {
+	key := membership{chatID: chatID, userID: userID}
+	now := time.Now()
+	b.seenMu.Lock()
+	fresh := now.Sub(b.seen[key]) < membershipRefresh
+	if !fresh {
+		b.pruneSeen(now)
+		b.seen[key] = now
+	}
+	b.seenMu.Unlock()
+	if fresh {
+		return
+	}
+
+	if err := b.members.AddMembership(ctx, userID, chatID, now); err != nil {
+		slog.WarnContext(ctx, "Failed to record membership", "chat_id", chatID, "error", err)
+		b.seenMu.Lock()
+		delete(b.seen, key)
+		b.seenMu.Unlock()
+	}
+}
+
+// pruneSeen forgets memberships recorded longer than membershipRefresh ago,
+// at most once per membershipRefresh, so only recent writers are kept in
+// memory. b.seenMu must be held.
+func (b *Bot) pruneSeen(now time.Time) {
+	if now.Sub(b.seenPruned) < membershipRefresh {
+		return
+	}
+	for key, at := range b.seen {
+		if now.Sub(at) >= membershipRefresh {
+			delete(b.seen, key)
+		}
+	}
+	b.seenPruned = now
+}
+
+// forgetMember removes a user's membership in a group from the index
+func (b *Bot) forgetMember(ctx context.Context, chatID, userID int64) {
+	b.seenMu.Lock()
+	delete(b.seen, membership{chatID: chatID, userID: userID})
+	b.seenMu.Unlock()
+
+	if err := b.members.RemoveMembership(ctx, userID, chatID); err != nil {
+		slog.WarnContext(ctx, "Failed to remove membership", "chat_id", chatID, "error", err)
+	}
+}
+
+// memberGroups returns the groups a user currently belongs to and may run a
+// command in. Only the groups the user was seen in are checked with
+// Telegram, so groups the user has left never show up, and groups found to
+// be left are dropped from the index.
+func (b *Bot) memberGroups(ctx context.Context, userID int64, command string) ([]int64, error) {
+	chatIDs, err := b.members.GetMemberChats(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the user's groups: %v", err)
+	}
+
+	// Private chats and channels never hold shared history
+	var groups []int64
+	for _, chatID := range chatIDs {
+		if chatID < 0 {
+			groups = append(groups, chatID)
+		}
+	}
+
+	members := b.access.MemberChats(userID, groups)
+	isMember := make(map[int64]bool, len(members))
+	for _, chatID := range members {
+		isMember[chatID] = true
+	}
+	for _, chatID := range groups {
+		if isMember[chatID] {
+			continue
+		}
+		// The role is cached by now, failed lookups keep the membership
+		if role, err := b.access.Role(chatID, userID); err == nil && !role.IsMember() {
+			b.forgetMember(ctx, chatID, userID)
+		}
+	}
+
+	var allowed []int64
+	for _, chatID := range members {
+		if b.commandAllowed(ctx, chatID, userID, command) {
+			allowed = append(allowed, chatID)
+		}
+	}
+
+	slog.DebugContext(ctx, "Resolved member groups", "groups", len(groups), "allowed", len(allowed))
+	return allowed, nil
+}
+
+// canSee reports whether a user is currently a member of a group and may run
+// a command there
+func (b *Bot) canSee(ctx context.Context, chatID, userID int64, command string) bool {
+	role, err := b.access.Role(chatID, userID)
+	if err != nil {
+		slog.DebugContext(ctx, "Could not verify membership", "chat_id", chatID, "user_id", userID, "error", err)
+		return false
+	}
+	return role.IsMember() && b.commandAllowed(ctx, chatID, userID, command)
+}
+
+// commandAllowed reports whether a group's policy lets a user run a command
+func (b *Bot) commandAllowed(ctx context.Context, chatID, userID int64, command string) bool {
+	policy := b.Settings(ctx, chatID).CommandPolicy(command)
+	allowed, err := b.access.Allowed(chatID, userID, policy)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to check command access", "chat_id", chatID, "error", err)
+		return false
+	}
+	return allowed
+}
+
+// HandlePrivateSearch handles /search in a private chat by searching every
+// group the caller is a member of
+func (b *Bot) HandlePrivateSearch(ctx context.Context, msg *tgbotapi.Message) error {
+	q := query.Parse(msg.CommandArguments())
+	if q.IsEmpty() {
+		return b.sendMessage(msg.Chat.ID, "Please provide a search query. Example: /search golang\n\n"+query.Syntax)
+	}
+
+	groups, err := b.memberGroups(ctx, msg.From.ID, "search")
+	if err != nil {
+		return err
+	}
+	if len(groups) == 0 {
+		return b.sendMessage(msg.Chat.ID, "I couldn't find any groups we're both in where you may search.")
+	}
+
+	results, err := b.search.Find(ctx, groups, q, int64(b.Settings(ctx, msg.Chat.ID).ResultLimit))
+	if err != nil {
+		if sendErr := b.sendMessage(msg.Chat.ID, "Sorry, an error occurred while searching."); sendErr != nil {
+			slog.ErrorContext(ctx, "Error sending search failure reply", "error", sendErr)
+		}
+		return fmt.Errorf("search failed: %v", err)
+	}
+	if len(results) == 0 {
+		return b.sendMessage(msg.Chat.ID, "No messages found matching your query.")
+	}
+
+	var text strings.Builder
+	text.WriteString("Found messages in your groups:\n\n")
+	for _, result := range results {
+		info := b.chatInfo(result.ChatID)
+		fmt.Fprintf(&text, "[%s] From %s:\n%s\n%s\n\n", info.title, result.Author(), result.Text,
+			b.generateMessageURL(result.ChatID, result.MessageID, info.username))
+	}
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, text.String())
+	reply.DisableWebPagePreview = true
+	_, err = b.api.Send(reply)
+	return err
+}
+
+// handlePrivateAsk answers /ask in a private chat from the recent history of
+// every group the caller is a member of
+func (b *Bot) handlePrivateAsk(ctx context.Context, msg *tgbotapi.Message) error {
+	question := strings.TrimSpace(msg.CommandArguments())
+	if question == "" {
+		return b.sendMessage(msg.Chat.ID, "Please provide a question after /ask")
+	}
+
+	slog.InfoContext(ctx, "Processing cross-group question", logging.Content("question", question))
+
+	groups, err := b.memberGroups(ctx, msg.From.ID, "ask")
+	if err != nil {
+		return err
+	}
+	if len(groups) == 0 {
+		return b.sendMessage(msg.Chat.ID, "I couldn't find any groups we're both in where you may ask questions.")
+	}
+
+	history := askHistory{
+		titles: make(map[int64]string, len(groups)),
+		noAI:   make(map[int64]bool),
+	}
+	for _, chatID := range groups {
+		settings := b.Settings(ctx, chatID)
+		messages, err := b.storage.GetRecentMessages(ctx, chatID, int64(settings.ContextSize))
+		if err != nil {
+			return fmt.Errorf("failed to fetch messages: %v", err)
+		}
+
+		info := b.chatInfo(chatID)
+		for i := range messages {
+			messages[i].ChatUsername = info.username
+		}
+		history.messages = append(history.messages, messages...)
+		history.titles[chatID] = info.title
+		// Respect groups that keep their messages away from the AI
+		if !settings.AIEnabled {
+			history.noAI[chatID] = true
+		}
+	}
+
+	if len(history.messages) == 0 {
+		return b.sendMessage(msg.Chat.ID, "I don't have any messages from your groups yet.")
+	}
+
+	// Keep the newest messages across all groups
+	sort.SliceStable(history.messages, func(i, j int) bool {
+		return history.messages[i].CreatedAt.After(history.messages[j].CreatedAt)
+	})
+	if len(history.messages) > crossChatContextSize {
+		history.messages = history.messages[:crossChatContextSize]
+	}
+
+	slog.DebugContext(ctx, "Loaded recent messages", "groups", len(groups), "count", len(history.messages))
+
+	return b.answerQuestion(ctx, msg.Chat.ID, msg.From.ID, question, history, b.Settings(ctx, msg.Chat.ID))
+}
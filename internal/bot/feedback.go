@@ -0,0 +1,87 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"SearchBot/internal/metrics"
+	"SearchBot/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// feedbackCallbackPrefix marks callback data sent by the rating buttons
+// under /ask answers, followed by "<answer id>:up" or "<answer id>:down"
+const feedbackCallbackPrefix = "feedback:"
+
+// feedbackKeyboard shows the rating buttons for an answer with its counts
+func feedbackKeyboard(answer *models.Answer) tgbotapi.InlineKeyboardMarkup {
+	up, down := answer.Score()
+	label := func(emoji string, count int) string {
+		if count == 0 {
+			return emoji
+		}
+		return fmt.Sprintf("%s %d", emoji, count)
+	}
+	data := feedbackCallbackPrefix + answer.ID.Hex()
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(label("👍", up), data+":up"),
+		tgbotapi.NewInlineKeyboardButtonData(label("👎", down), data+":down"),
+	))
+}
+
+// handleFeedbackCallback records a rating given with the buttons under an
+// answer. Rating again replaces the user's earlier rating.
+func (b *Bot) handleFeedbackCallback(ctx context.Context, query *tgbotapi.CallbackQuery) error {
+	data := strings.TrimPrefix(query.Data, feedbackCallbackPrefix)
+	hex, vote, ok := strings.Cut(data, ":")
+	id, err := primitive.ObjectIDFromHex(hex)
+	if !ok || err != nil {
+		return b.answerCallback(query.ID, "")
+	}
+
+	rating := models.AnswerRating{UserID: query.From.ID, RatedAt: time.Now()}
+	switch vote {
+	case "up":
+		rating.Rating = models.RatingUp
+	case "down":
+		rating.Rating = models.RatingDown
+	default:
+		return b.answerCallback(query.ID, "")
+	}
+
+	answer, err := b.answers.RateAnswer(ctx, id, rating)
+	if err != nil {
+		b.answerCallback(query.ID, "❌ Couldn't save your rating, please try again.")
+		return err
+	}
+	if answer == nil {
+		return b.answerCallback(query.ID, "This answer can no longer be rated.")
+	}
+	metrics.AskRatings.WithLabelValues(vote).Inc()
+
+	edit := tgbotapi.NewEditMessageReplyMarkup(query.Message.Chat.ID, query.Message.MessageID, feedbackKeyboard(answer))
+	if _, err := b.api.Request(edit); err != nil {
+		// Telegram refuses edits that change nothing, e.g. the same vote twice
+		slog.DebugContext(ctx, "Could not update rating buttons", "error", err)
+	}
+	return b.answerCallback(query.ID, "Thanks for your feedback!")
+}
+
+// messageRefs returns references to messages, without duplicates
+func messageRefs(messages []models.Message) []models.MessageRef {
+	refs := make([]models.MessageRef, 0, len(messages))
+	seen := make(map[models.MessageRef]bool)
+	for _, m := range messages {
+		ref := models.MessageRef{ChatID: m.ChatID, MessageID: m.MessageID}
+		if !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
@@ -0,0 +1,156 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"SearchBot/internal/importer"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxExportSize is the largest file the Bot API lets bots download
+const maxExportSize = 20 * 1024 * 1024
+
+// importBatchSize is how many exported messages are stored at once
+const importBatchSize = 500
+
+// importProgressInterval is how often the import progress message is updated
+const importProgressInterval = 5 * time.Second
+
+// ImportInstructions explains how admins can backfill older chat history
+const ImportInstructions = "To index older messages, a group admin can export the chat history " +
+	"from Telegram Desktop (Export chat history → format: JSON, without media) " +
+	"and send the resulting result.json to me in a private message."
+
+// HandleExportUpload imports a Telegram Desktop export sent to the bot in a
+// private chat. Large exports take a while, so it should be called with a
+// context of its own rather than the update's.
+func (b *Bot) HandleExportUpload(ctx context.Context, msg *tgbotapi.Message) error {
+	doc := msg.Document
+	if doc == nil {
+		return nil
+	}
+
+	if !strings.EqualFold(filepath.Ext(doc.FileName), ".json") {
+		return b.sendMessage(msg.Chat.ID,
+			"Please send the chat export as a JSON file (result.json).\n\n"+ImportInstructions)
+	}
+	if doc.FileSize > maxExportSize {
+		return b.sendMessage(msg.Chat.ID,
+			"❌ This export is too large for me to download (limit is 20 MB). "+
+				"Try exporting a shorter date range.")
+	}
+
+	export, err := b.downloadExport(ctx, doc.FileID)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to read export", "user_id", msg.From.ID, "error", err)
+		return b.sendMessage(msg.Chat.ID,
+			"❌ I couldn't read this file. Make sure it's an unmodified JSON export of a group chat.")
+	}
+
+	// Only admins of the exported group may import its history
+	role, err := b.access.Role(export.ChatID, msg.From.ID)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to check import permissions", "import_chat_id", export.ChatID, "error", err)
+		return b.sendMessage(msg.Chat.ID,
+			"❌ I couldn't verify your role in that group. Make sure I've been added to it.")
+	}
+	if !role.IsAdmin() {
+		return b.sendMessage(msg.Chat.ID, "❌ Only group admins can import chat history.")
+	}
+
+	// Look up the public username so imported messages get proper links
+	chat, err := b.api.GetChat(tgbotapi.ChatInfoConfig{
+		ChatConfig: tgbotapi.ChatConfig{ChatID: export.ChatID},
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to get chat info", "import_chat_id", export.ChatID, "error", err)
+	}
+
+	progress, err := b.api.Send(tgbotapi.NewMessage(msg.Chat.ID,
+		fmt.Sprintf("📥 Importing %d messages from %s...", len(export.Messages), export.Title)))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error sending import progress", "error", err)
+	}
+
+	imported, err := b.importMessages(ctx, export, chat.UserName, func(done int) {
+		if progress.MessageID == 0 {
+			return
+		}
+		edit := tgbotapi.NewEditMessageText(msg.Chat.ID, progress.MessageID,
+			fmt.Sprintf("📥 Importing messages from %s: %d of %d done...", export.Title, done, len(export.Messages)))
+		if _, err := b.api.Request(edit); err != nil {
+			slog.WarnContext(ctx, "Error updating import progress", "error", err)
+		}
+	})
+	slog.InfoContext(ctx, "Imported chat export", "import_chat_id", export.ChatID, "imported", imported, "total", len(export.Messages))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to import chat export", "import_chat_id", export.ChatID, "error", err)
+		return b.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ The import stopped after %d new messages from %s. "+
+			"Send the file again to continue, messages already imported are skipped.", imported, export.Title))
+	}
+
+	return b.sendMessage(msg.Chat.ID,
+		fmt.Sprintf("✅ Successfully indexed %d new text messages from %s.", imported, export.Title))
+}
+
+// importMessages stores an export's messages in batches and queues the new
+// ones for indexing. It calls progress after each batch, at most every
+// importProgressInterval, and returns how many messages were new.
+func (b *Bot) importMessages(ctx context.Context, export *importer.Export, chatUsername string, progress func(done int)) (int, error) {
+	imported := 0
+	lastProgress := time.Now()
+	for start := 0; start < len(export.Messages); start += importBatchSize {
+		batch := export.Messages[start:min(start+importBatchSize, len(export.Messages))]
+		for i := range batch {
+			batch[i].ChatUsername = chatUsername
+		}
+
+		inserted, err := b.storage.ImportMessages(ctx, batch)
+		if err != nil {
+			return imported, err
+		}
+		if err := b.indexer.AddAll(ctx, inserted); err != nil {
+			return imported, err
+		}
+		imported += len(inserted)
+
+		if time.Since(lastProgress) >= importProgressInterval {
+			progress(start + len(batch))
+			lastProgress = time.Now()
+		}
+	}
+	return imported, nil
+}
+
+// downloadExport fetches an uploaded file from Telegram and parses it as a chat export
+func (b *Bot) downloadExport(ctx context.Context, fileID string) (*importer.Export, error) {
+	fileURL, err := b.api.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file URL: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download file: status %s", resp.Status)
+	}
+
+	return importer.ParseTelegramExport(io.LimitReader(resp.Body, maxExportSize))
+}
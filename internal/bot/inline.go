@@ -0,0 +1,142 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"SearchBot/internal/query"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	inlineResultLimit     = 20               // Results shown for an inline query
+	inlineCacheTTL        = 30 * time.Second // How long inline results are reused
+	inlineLimitedCacheTTL = 5 * time.Second  // How long Telegram reuses the empty answer to a rate limited user
+	inlineSnippetLen      = 100              // Runes of a message shown in the result list
+	inlineMessageLen      = 1000             // Runes of a message sent into the chat
+)
+
+// inlineKey identifies an inline query by who asked and what they typed
+type inlineKey struct {
+	userID int64
+	query  string
+}
+
+// cachedInline is an inline answer and when it stops being reused
+type cachedInline struct {
+	results []interface{}
+	expires time.Time
+}
+
+// HandleInlineQuery answers "@bot <query>" with matching messages from the
+// groups the user is a member of. Answers are personal, so Telegram never
+// shows one user's results to another.
+func (b *Bot) HandleInlineQuery(ctx context.Context, query *tgbotapi.InlineQuery) error {
+	text := strings.TrimSpace(query.Query)
+
+	answer := tgbotapi.InlineConfig{
+		InlineQueryID: query.ID,
+		Results:       []interface{}{},
+		CacheTime:     int(inlineCacheTTL.Seconds()),
+		IsPersonal:    true,
+	}
+
+	if text != "" {
+		results, wait, err := b.inlineResults(ctx, query.From.ID, text)
+		if err != nil {
+			// Still answer, so the client stops waiting
+			slog.ErrorContext(ctx, "Inline search failed", "error", err)
+			answer.CacheTime = 0
+		}
+		if wait > 0 {
+			// Only briefly keep the empty answer, so results show up again
+			// soon after the user slows down
+			answer.CacheTime = int(inlineLimitedCacheTTL.Seconds())
+		}
+		answer.Results = results
+	}
+
+	if _, err := b.api.Request(answer); err != nil {
+		return fmt.Errorf("failed to answer inline query: %v", err)
+	}
+	return nil
+}
+
+// inlineResults returns the result articles for an inline query, reusing
+// recent answers while the user is still typing. If the user is rate
+// limited it returns no results and how long to wait.
+func (b *Bot) inlineResults(ctx context.Context, userID int64, text string) ([]interface{}, time.Duration, error) {
+	q := query.Parse(text)
+	if q.IsEmpty() {
+		return []interface{}{}, 0, nil
+	}
+
+	key := inlineKey{userID: userID, query: strings.ToLower(text)}
+
+	b.inlineMu.Lock()
+	cached, ok := b.inline[key]
+	b.inlineMu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.results, 0, nil
+	}
+
+	// Inline queries arrive with every keystroke, so they have their own
+	// limit rather than using up the user's /search allowance
+	if b.limits.Inline != nil {
+		if allowed, wait := b.limits.Inline.Allow(userID, userID); !allowed {
+			slog.InfoContext(ctx, "Rate limited inline query", "user_id", userID, "retry_in", wait)
+			return []interface{}{}, wait, nil
+		}
+	}
+
+	groups, err := b.memberGroups(ctx, userID, "search")
+	if err != nil {
+		return []interface{}{}, 0, err
+	}
+
+	results := []interface{}{}
+	if len(groups) > 0 {
+		messages, err := b.search.Find(ctx, groups, q, inlineResultLimit)
+		if err != nil {
+			return []interface{}{}, 0, err
+		}
+
+		for _, message := range messages {
+			info := b.chatInfo(message.ChatID)
+			url := b.generateMessageURL(message.ChatID, message.MessageID, info.username)
+
+			article := tgbotapi.NewInlineQueryResultArticle(message.GetSearchID(),
+				fmt.Sprintf("[%s] %s", info.title, message.Author()),
+				fmt.Sprintf("%s in %s:\n%s\n\n%s", message.Author(), info.title, truncate(message.Text, inlineMessageLen), url))
+			article.Description = truncate(message.Text, inlineSnippetLen)
+			article.URL = url
+			article.HideURL = true
+			results = append(results, article)
+		}
+	}
+
+	b.inlineMu.Lock()
+	now := time.Now()
+	for k, v := range b.inline {
+		if now.After(v.expires) {
+			delete(b.inline, k)
+		}
+	}
+	b.inline[key] = cachedInline{results: results, expires: now.Add(inlineCacheTTL)}
+	b.inlineMu.Unlock()
+
+	return results, 0, nil
+}
+
+// truncate shortens text to at most n runes, marking where it was cut
+func truncate(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,225 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"SearchBot/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// settingsCallbackPrefix marks callback data sent by the /settings keyboard
+const settingsCallbackPrefix = "settings:"
+
+// Values the /settings keyboard cycles through
+var (
+	resultLimitOptions = []int{5, 10, 20, 50}
+	contextSizeOptions = []int{50, 100, 200}
+	retentionOptions   = []int{0, 30, 90, 365}
+	languageOptions    = []string{"", "English", "Spanish", "French", "German", "Russian", "Amharic"}
+	askAccessOptions   = []string{models.AccessEveryone, models.AccessAdmins}
+)
+
+// Settings returns a chat's settings, falling back to the defaults if they
+// can't be loaded
+func (b *Bot) Settings(ctx context.Context, chatID int64) *models.ChatSettings {
+	settings, err := b.settings.GetChatSettings(ctx, chatID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings, using defaults", "chat_id", chatID, "error", err)
+		return models.DefaultChatSettings(chatID)
+	}
+	return settings
+}
+
+// HandleSettingsCommand shows the settings editor to chat admins
+func (b *Bot) HandleSettingsCommand(ctx context.Context, msg *tgbotapi.Message) error {
+	if !msg.Chat.IsGroup() && !msg.Chat.IsSuperGroup() {
+		return b.sendMessage(msg.Chat.ID, "This command only works in groups.")
+	}
+	reply := tgbotapi.NewMessage(msg.Chat.ID, settingsText)
+	reply.ReplyMarkup = settingsKeyboard(b.Settings(ctx, msg.Chat.ID))
+	_, err := b.api.Send(reply)
+	return err
+}
+
+// HandleCallbackQuery handles presses on inline keyboard buttons
+func (b *Bot) HandleCallbackQuery(ctx context.Context, query *tgbotapi.CallbackQuery) error {
+	if strings.HasPrefix(query.Data, settingsCallbackPrefix) && query.Message != nil {
+		return b.handleSettingsCallback(ctx, query)
+	}
+	if strings.HasPrefix(query.Data, feedbackCallbackPrefix) && query.Message != nil {
+		return b.handleFeedbackCallback(ctx, query)
+	}
+	return b.answerCallback(query.ID, "")
+}
+
+// settingsText is shown above the settings keyboard
+const settingsText = "⚙️ Settings for this chat\n\nTap a button to change it."
+
+// handleSettingsCallback applies a change made in the settings editor
+func (b *Bot) handleSettingsCallback(ctx context.Context, query *tgbotapi.CallbackQuery) error {
+	chatID := query.Message.Chat.ID
+	if !b.IsChatAdmin(chatID, query.From.ID) {
+		return b.answerCallback(query.ID, "Only group admins can change settings.")
+	}
+
+	action := strings.TrimPrefix(query.Data, settingsCallbackPrefix)
+	if action == "done" {
+		edit := tgbotapi.NewEditMessageText(chatID, query.Message.MessageID, "✅ Settings saved.")
+		if _, err := b.api.Send(edit); err != nil {
+			slog.WarnContext(ctx, "Failed to close settings editor", "error", err)
+		}
+		return b.answerCallback(query.ID, "")
+	}
+
+	settings, err := b.settings.GetChatSettings(ctx, chatID)
+	if err != nil {
+		b.answerCallback(query.ID, "Failed to load settings, please try again.")
+		return err
+	}
+
+	// Only the changed field is written, so admins editing at the same time
+	// don't undo each other's changes
+	var field string
+	var value interface{}
+	switch action {
+	case "back":
+		b.showSettings(ctx, query, settings)
+		return b.answerCallback(query.ID, "")
+	case "limit":
+		field, value = "result_limit", nextOption(resultLimitOptions, settings.ResultLimit)
+	case "context":
+		field, value = "context_size", nextOption(contextSizeOptions, settings.ContextSize)
+	case "retention":
+		days := nextOption(retentionOptions, settings.RetentionDays)
+		if shortensRetention(settings.RetentionDays, days) {
+			return b.confirmRetention(ctx, query, days)
+		}
+		field, value = "retention_days", days
+	case "lang":
+		field, value = "language", nextOption(languageOptions, settings.Language)
+	case "ai":
+		field, value = "ai_enabled", !settings.AIEnabled
+	case "prompt":
+		field, value = "prompt", nextOption(append([]string{""}, b.prompts.Names()...), settings.Prompt)
+	case "ask":
+		field, value = "commands.ask", models.CommandPolicy{
+			Access: nextOption(askAccessOptions, settings.CommandPolicy("ask").Access),
+		}
+	default:
+		// A confirmed retention change, see confirmRetention
+		days, err := strconv.Atoi(strings.TrimPrefix(action, "retention="))
+		if !strings.HasPrefix(action, "retention=") || err != nil || !slices.Contains(retentionOptions, days) {
+			return b.answerCallback(query.ID, "")
+		}
+		field, value = "retention_days", days
+	}
+
+	settings, err = b.settings.SetChatSetting(ctx, chatID, field, value, query.From.ID)
+	if err != nil {
+		b.answerCallback(query.ID, "Failed to save settings, please try again.")
+		return err
+	}
+
+	b.showSettings(ctx, query, settings)
+	return b.answerCallback(query.ID, "Saved")
+}
+
+// showSettings turns the message of a button press back into the settings editor
+func (b *Bot) showSettings(ctx context.Context, query *tgbotapi.CallbackQuery, settings *models.ChatSettings) {
+	edit := tgbotapi.NewEditMessageTextAndMarkup(query.Message.Chat.ID, query.Message.MessageID, settingsText, settingsKeyboard(settings))
+	if _, err := b.api.Send(edit); err != nil {
+		slog.WarnContext(ctx, "Failed to update settings editor", "error", err)
+	}
+}
+
+// shortensRetention reports whether changing the retention period from
+// current to next days deletes messages that are kept now. 0 keeps
+// messages forever.
+func shortensRetention(current, next int) bool {
+	return next > 0 && (current == 0 || next < current)
+}
+
+// confirmRetention asks before shortening the retention period, as the
+// next purge deletes the older history for good
+func (b *Bot) confirmRetention(ctx context.Context, query *tgbotapi.CallbackQuery, days int) error {
+	text := fmt.Sprintf("⚠️ Keep messages for %d days?\n\n"+
+		"Messages older than %d days will be deleted from this chat's history and search at the next cleanup. "+
+		"This can't be undone.", days, days)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("🗑 Keep %d days", days), fmt.Sprintf("%sretention=%d", settingsCallbackPrefix, days)),
+		tgbotapi.NewInlineKeyboardButtonData("Cancel", settingsCallbackPrefix+"back"),
+	))
+
+	edit := tgbotapi.NewEditMessageTextAndMarkup(query.Message.Chat.ID, query.Message.MessageID, text, keyboard)
+	if _, err := b.api.Send(edit); err != nil {
+		slog.WarnContext(ctx, "Failed to ask for retention confirmation", "error", err)
+	}
+	return b.answerCallback(query.ID, "")
+}
+
+// answerCallback acknowledges a button press, optionally showing a notice
+func (b *Bot) answerCallback(queryID, text string) error {
+	_, err := b.api.Request(tgbotapi.NewCallback(queryID, text))
+	return err
+}
+
+// settingsKeyboard builds the inline keyboard showing the current settings
+func settingsKeyboard(settings *models.ChatSettings) tgbotapi.InlineKeyboardMarkup {
+	onOff := "off"
+	if settings.AIEnabled {
+		onOff = "on"
+	}
+	language := settings.Language
+	if language == "" {
+		language = "auto"
+	}
+	prompt := settings.Prompt
+	if prompt == "" {
+		prompt = "default"
+	}
+	retention := "forever"
+	if settings.RetentionDays > 0 {
+		retention = fmt.Sprintf("%d days", settings.RetentionDays)
+	}
+
+	button := func(text, action string) tgbotapi.InlineKeyboardButton {
+		return tgbotapi.NewInlineKeyboardButtonData(text, settingsCallbackPrefix+action)
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			button(fmt.Sprintf("Search results: %d", settings.ResultLimit), "limit"),
+			button(fmt.Sprintf("/ask context: %d msgs", settings.ContextSize), "context"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			button("AI answers: "+onOff, "ai"),
+			button("Language: "+language, "lang"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			button("/ask allowed for: "+settings.CommandPolicy("ask").Access, "ask"),
+			button("Keep messages: "+retention, "retention"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			button("/ask prompt: "+prompt, "prompt"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			button("✅ Done", "done"),
+		),
+	)
+}
+
+// nextOption returns the option after current, wrapping around to the first
+func nextOption[T comparable](options []T, current T) T {
+	for i, option := range options {
+		if option == current {
+			return options[(i+1)%len(options)]
+		}
+	}
+	return options[0]
+}
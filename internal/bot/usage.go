@@ -0,0 +1,181 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"SearchBot/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// usageHistoryDays is how many days /usage shows
+const usageHistoryDays = 7
+
+// CheckRateLimit reports whether the sender of a command may run it now.
+// If not, it tells them when to try again and returns false.
+func (b *Bot) CheckRateLimit(ctx context.Context, msg *tgbotapi.Message) bool {
+	command := msg.Command()
+	limiter, ok := b.limits.Commands[command]
+	if !ok {
+		return true
+	}
+
+	var userID int64
+	if msg.From != nil {
+		userID = msg.From.ID
+	}
+	allowed, wait := limiter.Allow(msg.Chat.ID, userID)
+	if allowed {
+		return true
+	}
+
+	slog.InfoContext(ctx, "Rate limited command", "user_id", userID, "retry_in", wait)
+	reply := tgbotapi.NewMessage(msg.Chat.ID,
+		fmt.Sprintf("⏳ /%s is being used too often. Please try again in %s.", command, wait.Truncate(time.Second)+time.Second))
+	reply.ReplyToMessageID = msg.MessageID
+	if _, err := b.api.Send(reply); err != nil {
+		slog.ErrorContext(ctx, "Error sending rate limit reply", "error", err)
+	}
+	return false
+}
+
+// Rough cost of an AI call, reserved from the daily quota before it's made
+const (
+	charsPerToken    = 4    // Characters of text per token, on average
+	aiResponseTokens = 1000 // Tokens reserved for the AI's reply
+)
+
+// estimateAITokens guesses how many tokens answering question from
+// messages will use
+func estimateAITokens(question string, messages []models.Message) int64 {
+	chars := utf8.RuneCountInString(question)
+	for _, message := range messages {
+		chars += utf8.RuneCountInString(message.Author()) + utf8.RuneCountInString(message.Text)
+	}
+	return int64((chars+charsPerToken-1)/charsPerToken) + aiResponseTokens
+}
+
+// reserveAIQuota charges the estimated cost of answering question to the
+// daily quota of the chats whose messages the AI reads, before the AI is
+// called, so parallel questions can't overspend it. It returns the tokens
+// reserved per chat, which recordAIUsage settles, and false without
+// reserving anything if any chat's quota is used up. If usage can't be
+// updated the chat is given the benefit of the doubt.
+func (b *Bot) reserveAIQuota(ctx context.Context, question string, messages []models.Message) (map[int64]int64, bool) {
+	quota := b.limits.DailyTokenQuota
+	if quota <= 0 || len(messages) == 0 {
+		return nil, true
+	}
+
+	estimate := models.AIUsage{TotalTokens: estimateAITokens(question, messages)}
+	now := time.Now()
+	reserved := make(map[int64]int64)
+	for chatID, share := range splitAIUsage(estimate, messages) {
+		// A question too large for the whole quota may still use what's left
+		tokens := min(share.TotalTokens, quota)
+		ok, err := b.usage.ReserveAIUsage(ctx, chatID, now, tokens, quota)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to reserve AI usage", "chat_id", chatID, "error", err)
+			continue
+		}
+		if !ok {
+			b.recordAIUsage(ctx, nil, models.AIUsage{}, reserved)
+			return nil, false
+		}
+		reserved[chatID] = tokens
+	}
+	return reserved, true
+}
+
+// recordAIUsage adds the tokens used by an AI call to the daily totals of
+// the chats whose messages it read, less the tokens reserveAIQuota reserved
+// for it. A call reading several groups, like a private /ask, is split
+// among them by their number of messages.
+func (b *Bot) recordAIUsage(ctx context.Context, messages []models.Message, usage models.AIUsage, reserved map[int64]int64) {
+	charges := make(map[int64]models.AIUsage)
+	if usage.Requests > 0 {
+		charges = splitAIUsage(usage, messages)
+	}
+	for chatID, tokens := range reserved {
+		charge := charges[chatID]
+		charge.TotalTokens -= tokens
+		charges[chatID] = charge
+	}
+
+	for chatID, charge := range charges {
+		if charge == (models.AIUsage{}) {
+			continue
+		}
+		if err := b.usage.RecordAIUsage(ctx, chatID, time.Now(), charge); err != nil {
+			slog.ErrorContext(ctx, "Failed to record AI usage", "chat_id", chatID, "error", err)
+		}
+	}
+}
+
+// splitAIUsage divides usage among the chats of messages in proportion to
+// their number of messages. Every chat is charged each request, and tokens
+// are rounded up so quotas are never undercounted.
+func splitAIUsage(usage models.AIUsage, messages []models.Message) map[int64]models.AIUsage {
+	counts := make(map[int64]int64)
+	for _, message := range messages {
+		counts[message.ChatID]++
+	}
+	total := int64(len(messages))
+	share := func(tokens, n int64) int64 {
+		return (tokens*n + total - 1) / total
+	}
+
+	shares := make(map[int64]models.AIUsage, len(counts))
+	for chatID, n := range counts {
+		shares[chatID] = models.AIUsage{
+			Requests:       usage.Requests,
+			PromptTokens:   share(usage.PromptTokens, n),
+			ResponseTokens: share(usage.ResponseTokens, n),
+			TotalTokens:    share(usage.TotalTokens, n),
+		}
+	}
+	return shares
+}
+
+// HandleUsageCommand shows a chat's AI usage over the last days
+func (b *Bot) HandleUsageCommand(ctx context.Context, msg *tgbotapi.Message) error {
+	now := time.Now().UTC()
+	history, err := b.usage.GetAIUsage(ctx, msg.Chat.ID, now.AddDate(0, 0, -(usageHistoryDays-1)), now)
+	if err != nil {
+		return fmt.Errorf("failed to load AI usage: %v", err)
+	}
+
+	byDay := make(map[string]models.DailyUsage, len(history))
+	for _, day := range history {
+		byDay[day.Day] = day
+	}
+
+	var text strings.Builder
+	text.WriteString("📊 AI usage in this chat (UTC)\n\n")
+
+	today := byDay[now.Format("2006-01-02")]
+	if b.limits.DailyTokenQuota > 0 {
+		text.WriteString(fmt.Sprintf("Today: %d of %d tokens (%d requests)\n\n",
+			today.TotalTokens, b.limits.DailyTokenQuota, today.Requests))
+	} else {
+		text.WriteString(fmt.Sprintf("Today: %d tokens (%d requests), no daily quota\n\n",
+			today.TotalTokens, today.Requests))
+	}
+
+	var total models.AIUsage
+	for i := usageHistoryDays - 1; i >= 0; i-- {
+		day := now.AddDate(0, 0, -i).Format("2006-01-02")
+		usage := byDay[day]
+		text.WriteString(fmt.Sprintf("%s: %d tokens, %d requests\n", day, usage.TotalTokens, usage.Requests))
+		total.Requests += usage.Requests
+		total.TotalTokens += usage.TotalTokens
+	}
+	text.WriteString(fmt.Sprintf("\nLast %d days: %d tokens, %d requests", usageHistoryDays, total.TotalTokens, total.Requests))
+
+	return b.sendMessage(msg.Chat.ID, text.String())
+}
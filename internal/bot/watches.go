@@ -0,0 +1,261 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"SearchBot/internal/models"
+	"SearchBot/internal/query"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	maxWatchesPerUser = 20               // Watches a user may keep at once
+	watchThrottle     = 10 * time.Minute // A watch notifies at most this often
+	watchRefresh      = time.Minute      // How often watches added elsewhere are picked up
+	watchQueueSize    = 1000             // Matched messages waiting for their notifications
+	watchSenders      = 4                // Goroutines sending notifications
+	watchSendTimeout  = 30 * time.Second // Deadline for the notifications of one message
+)
+
+// watchNotification is a new message and the watches it matched
+type watchNotification struct {
+	ctx     context.Context // Carries the trace of the update the message came in
+	message models.Message
+	watches []models.Watch
+}
+
+// WatchUsage explains the /watch and /unwatch commands
+const WatchUsage = "Usage:\n" +
+	"/watch <query> - Get a private message when a new message in your groups matches\n" +
+	"/watch - List your watches\n" +
+	"/unwatch <number> - Stop a watch\n" +
+	"/unwatch all - Stop all your watches\n\n" +
+	query.Syntax
+
+// LoadWatches loads every user's watches so new messages can be matched
+// against them
+func (b *Bot) LoadWatches(ctx context.Context) error {
+	watches, err := b.watches.ListWatches(ctx)
+	if err != nil {
+		return err
+	}
+	b.watchIndex.Replace(watches)
+	slog.DebugContext(ctx, "Loaded watches", "count", b.watchIndex.Len())
+	return nil
+}
+
+// RefreshWatches reloads the watches every minute until ctx is done, so
+// watches added or removed through another instance take effect here too.
+// Removed watches stop notifying right away, as ClaimWatch skips them.
+func (b *Bot) RefreshWatches(ctx context.Context) {
+	ticker := time.NewTicker(watchRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.LoadWatches(ctx); err != nil && ctx.Err() == nil {
+				slog.ErrorContext(ctx, "Failed to refresh watches", "error", err)
+			}
+		}
+	}
+}
+
+// HandleWatchCommand adds a watch, or lists the user's watches
+func (b *Bot) HandleWatchCommand(ctx context.Context, msg *tgbotapi.Message) error {
+	if !msg.Chat.IsPrivate() {
+		return b.sendMessage(msg.Chat.ID, "Please send /watch to me in a private chat so I can message you about matches.")
+	}
+
+	watches, err := b.watches.GetWatches(ctx, msg.From.ID)
+	if err != nil {
+		return err
+	}
+
+	text := strings.TrimSpace(msg.CommandArguments())
+	if text == "" {
+		return b.sendMessage(msg.Chat.ID, formatWatches(watches))
+	}
+
+	q := query.Parse(text)
+	if q.IsEmpty() {
+		return b.sendMessage(msg.Chat.ID, WatchUsage)
+	}
+	if len(watches) >= maxWatchesPerUser {
+		return b.sendMessage(msg.Chat.ID,
+			fmt.Sprintf("You already have %d watches. Remove one with /unwatch first.", len(watches)))
+	}
+
+	w := &models.Watch{
+		UserID:    msg.From.ID,
+		Query:     q.String(),
+		CreatedAt: time.Now(),
+	}
+	if err := b.watches.AddWatch(ctx, w); err != nil {
+		return err
+	}
+	b.watchIndex.Add(*w)
+
+	return b.sendMessage(msg.Chat.ID, fmt.Sprintf("🔔 Watching for %q in your groups. "+
+		"I'll message you at most once every %s per watch.", w.Query, watchThrottle))
+}
+
+// HandleUnwatchCommand removes one or all of the user's watches
+func (b *Bot) HandleUnwatchCommand(ctx context.Context, msg *tgbotapi.Message) error {
+	if !msg.Chat.IsPrivate() {
+		return b.sendMessage(msg.Chat.ID, "Please send /unwatch to me in a private chat.")
+	}
+
+	watches, err := b.watches.GetWatches(ctx, msg.From.ID)
+	if err != nil {
+		return err
+	}
+
+	arg := strings.TrimSpace(msg.CommandArguments())
+	var remove []models.Watch
+	if arg == "all" {
+		remove = watches
+	} else {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > len(watches) {
+			return b.sendMessage(msg.Chat.ID, formatWatches(watches)+"\n\n"+WatchUsage)
+		}
+		remove = watches[n-1 : n]
+	}
+
+	for _, w := range remove {
+		if _, err := b.watches.DeleteWatch(ctx, msg.From.ID, w.ID); err != nil {
+			return err
+		}
+		b.watchIndex.Remove(w.ID)
+	}
+
+	return b.sendMessage(msg.Chat.ID, fmt.Sprintf("Removed %d watch(es).", len(remove)))
+}
+
+// formatWatches lists a user's watches, numbered for /unwatch
+func formatWatches(watches []models.Watch) string {
+	if len(watches) == 0 {
+		return "You have no watches. Add one with /watch <query>."
+	}
+	var text strings.Builder
+	text.WriteString("Your watches:\n")
+	for i, w := range watches {
+		fmt.Fprintf(&text, "%d. %s\n", i+1, w.Query)
+	}
+	return strings.TrimSpace(text.String())
+}
+
+// NotifyWatchers queues notifications for the users whose watches match a
+// new message. Matching is done in memory, the membership checks and
+// messages are sent in the background so a popular message doesn't hold up
+// the chat's other updates. If the queue is full the notifications are
+// dropped.
+func (b *Bot) NotifyWatchers(ctx context.Context, message *models.Message) {
+	var matches []models.Watch
+	for _, w := range b.watchIndex.Match(message) {
+		// Never tell users about their own messages
+		if w.UserID != message.UserID {
+			matches = append(matches, w)
+		}
+	}
+	if len(matches) == 0 {
+		return
+	}
+
+	b.notifyMu.Lock()
+	defer b.notifyMu.Unlock()
+	if b.notifyClosed {
+		slog.WarnContext(ctx, "Dropped watch notifications during shutdown", "watches", len(matches))
+		return
+	}
+	select {
+	case b.notifications <- watchNotification{ctx: context.WithoutCancel(ctx), message: *message, watches: matches}:
+	default:
+		slog.WarnContext(ctx, "Watch notification queue is full, dropped notifications", "watches", len(matches))
+	}
+}
+
+// CloseWatchNotifications stops accepting notifications and waits until the
+// queued ones are sent or ctx expires
+func (b *Bot) CloseWatchNotifications(ctx context.Context) error {
+	b.notifyMu.Lock()
+	if !b.notifyClosed {
+		b.notifyClosed = true
+		close(b.notifications)
+	}
+	b.notifyMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.notifiers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("watch notifications still queued: %v", ctx.Err())
+	}
+}
+
+// sendWatchNotifications sends queued notifications until the queue is closed
+func (b *Bot) sendWatchNotifications() {
+	defer b.notifiers.Done()
+	for n := range b.notifications {
+		ctx, cancel := context.WithTimeout(n.ctx, watchSendTimeout)
+		b.notifyWatchers(ctx, &n.message, n.watches)
+		cancel()
+	}
+}
+
+// notifyWatchers messages the users of watches a message matched. Users are
+// only told about messages from groups they are currently a member of and
+// may search.
+func (b *Bot) notifyWatchers(ctx context.Context, message *models.Message, matches []models.Watch) {
+	var info *chatInfo
+	for _, w := range matches {
+		if !b.canSee(ctx, message.ChatID, w.UserID, "search") {
+			continue
+		}
+
+		now := time.Now()
+		if b.watchIndex.Throttled(w.ID, now, watchThrottle) {
+			slog.DebugContext(ctx, "Throttled watch", "user_id", w.UserID)
+			continue
+		}
+		claimed, err := b.watches.ClaimWatch(ctx, w.ID, now, watchThrottle)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to claim watch notification", "error", err)
+			continue
+		}
+		if !claimed {
+			slog.DebugContext(ctx, "Throttled watch", "user_id", w.UserID)
+			continue
+		}
+		b.watchIndex.SetNotified(w.ID, now)
+
+		if info == nil {
+			i := b.chatInfo(message.ChatID)
+			info = &i
+		}
+		text := fmt.Sprintf("🔔 New match for %q in %s:\n%s: %s\n\n%s", w.Query, info.title,
+			message.Author(), truncate(message.Text, inlineMessageLen),
+			b.generateMessageURL(message.ChatID, message.MessageID, info.username))
+
+		reply := tgbotapi.NewMessage(w.UserID, text)
+		reply.DisableWebPagePreview = true
+		if _, err := b.api.Send(reply); err != nil {
+			// Usually the user blocked the bot or never started a chat with it
+			slog.WarnContext(ctx, "Failed to send watch notification", "user_id", w.UserID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,497 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"SearchBot/internal/webhook"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds all settings of the bot
+type Config struct {
+	Telegram    TelegramConfig    `yaml:"telegram"`
+	Webhook     WebhookConfig     `yaml:"webhook"`
+	MongoDB     MongoDBConfig     `yaml:"mongodb"`
+	Meilisearch MeilisearchConfig `yaml:"meilisearch"`
+	Gemini      GeminiConfig      `yaml:"gemini"`
+	Prompts     PromptsConfig     `yaml:"prompts"`
+	Indexer     IndexerConfig     `yaml:"indexer"`
+	Workers     WorkersConfig     `yaml:"workers"`
+	Limits      LimitsConfig      `yaml:"limits"`
+	Ops         OpsConfig         `yaml:"ops"`
+	Log         LogConfig         `yaml:"log"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+
+	ReconcileInterval time.Duration `yaml:"reconcile_interval"` // 0 disables periodic reconciliation
+	RetentionInterval time.Duration `yaml:"retention_interval"` // 0 disables deleting expired messages
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`
+}
+
+// TelegramConfig holds the Telegram Bot API settings
+type TelegramConfig struct {
+	Token       string        `yaml:"token"`
+	Mode        string        `yaml:"mode"` // "polling" or "webhook"
+	Debug       bool          `yaml:"debug"`
+	PollTimeout time.Duration `yaml:"poll_timeout"` // How long each long poll waits for updates
+}
+
+// WebhookConfig holds the settings used in webhook mode
+type WebhookConfig struct {
+	URL            string `yaml:"url"`
+	ListenAddr     string `yaml:"listen_addr"`
+	Secret         string `yaml:"secret"`
+	CertFile       string `yaml:"cert_file"`
+	KeyFile        string `yaml:"key_file"`
+	MaxConnections int    `yaml:"max_connections"`
+}
+
+// MongoDBConfig holds the MongoDB settings
+type MongoDBConfig struct {
+	URI        string `yaml:"uri"`
+	Database   string `yaml:"database"`
+	Collection string `yaml:"collection"` // Base name of the message collections
+}
+
+// MeilisearchConfig holds the Meilisearch settings
+type MeilisearchConfig struct {
+	Host  string `yaml:"host"`
+	Key   string `yaml:"key"`
+	Index string `yaml:"index"` // Base name of the message indexes
+}
+
+// GeminiConfig holds the Gemini AI settings
+type GeminiConfig struct {
+	APIKey string `yaml:"api_key"`
+	Model  string `yaml:"model"`
+}
+
+// PromptsConfig holds the settings of the /ask prompt templates
+type PromptsConfig struct {
+	Dir     string `yaml:"dir"`     // *.tmpl files adding to or replacing the built-in prompts
+	Default string `yaml:"default"` // Prompt used by chats that didn't pick one, empty for the built-in default
+}
+
+// IndexerConfig holds the batching settings of the indexing pipeline
+type IndexerConfig struct {
+	BatchSize     int           `yaml:"batch_size"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+}
+
+// WorkersConfig holds the settings of the update worker pool
+type WorkersConfig struct {
+	Count          int           `yaml:"count"`
+	QueueSize      int           `yaml:"queue_size"`
+	HandlerTimeout time.Duration `yaml:"handler_timeout"`
+}
+
+// LimitsConfig holds the rate limits and AI quota. Zero disables a limit.
+type LimitsConfig struct {
+	AskPerUser    int   `yaml:"ask_per_user"` // Uses per minute
+	AskPerChat    int   `yaml:"ask_per_chat"`
+	SearchPerUser int   `yaml:"search_per_user"`
+	SearchPerChat int   `yaml:"search_per_chat"`
+	InlinePerUser int   `yaml:"inline_per_user"` // Inline queries, sent while the user types
+	DailyAITokens int64 `yaml:"daily_ai_tokens"` // Per chat
+}
+
+// OpsConfig holds the settings of the metrics and health endpoints
+type OpsConfig struct {
+	ListenAddr   string        `yaml:"listen_addr"`   // Empty disables the endpoints
+	CheckTimeout time.Duration `yaml:"check_timeout"` // Per dependency check
+	MaxPollAge   time.Duration `yaml:"max_poll_age"`  // Unhealthy if polling hasn't succeeded for this long
+}
+
+// LogConfig holds the logging settings
+type LogConfig struct {
+	Level         string `yaml:"level"`          // debug, info, warn or error
+	Format        string `yaml:"format"`         // text or json
+	RedactContent bool   `yaml:"redact_content"` // Hide message text and AI prompts/answers
+}
+
+// TracingConfig holds the OpenTelemetry tracing settings
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter"`     // none, stdout or otlp
+	Endpoint    string  `yaml:"endpoint"`     // OTLP/HTTP host:port, empty uses OTEL_EXPORTER_OTLP_ENDPOINT
+	Insecure    bool    `yaml:"insecure"`     // Send OTLP over plain HTTP
+	SampleRatio float64 `yaml:"sample_ratio"` // Fraction of updates traced
+	ServiceName string  `yaml:"service_name"`
+}
+
+// Default returns the configuration used when nothing is overridden
+func Default() *Config {
+	return &Config{
+		Telegram: TelegramConfig{
+			Mode:        "polling",
+			PollTimeout: 20 * time.Second,
+		},
+		Webhook: WebhookConfig{
+			ListenAddr: ":8443",
+		},
+		MongoDB: MongoDBConfig{
+			Database:   "telegram_bot",
+			Collection: "messages",
+		},
+		Meilisearch: MeilisearchConfig{
+			Host:  "http://localhost:7700",
+			Index: "messages",
+		},
+		Gemini: GeminiConfig{
+			Model: "gemini-pro",
+		},
+		Indexer: IndexerConfig{
+			BatchSize:     100,
+			FlushInterval: 2 * time.Second,
+		},
+		Workers: WorkersConfig{
+			Count:          8,
+			QueueSize:      100,
+			HandlerTimeout: 60 * time.Second,
+		},
+		Limits: LimitsConfig{
+			AskPerUser:    3,
+			AskPerChat:    10,
+			SearchPerUser: 10,
+			SearchPerChat: 30,
+			InlinePerUser: 60,
+			DailyAITokens: 500000,
+		},
+		Ops: OpsConfig{
+			ListenAddr:   ":9090",
+			CheckTimeout: 5 * time.Second,
+			MaxPollAge:   3 * time.Minute,
+		},
+		Log: LogConfig{
+			Level:         "info",
+			Format:        "text",
+			RedactContent: true,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			SampleRatio: 1,
+			ServiceName: "searchbot",
+		},
+		ReconcileInterval: 6 * time.Hour,
+		RetentionInterval: time.Hour,
+		ShutdownTimeout:   30 * time.Second,
+	}
+}
+
+// setting describes one option that can be set from the environment or a flag
+type setting struct {
+	flag  string
+	env   []string // The first name is canonical, the others are deprecated aliases
+	usage string
+	field func(c *Config) interface{}
+}
+
+// settings lists every option that can be overridden outside the config file
+var settings = []setting{
+	{"telegram-token", []string{"TELEGRAM_BOT_TOKEN"}, "Telegram bot token",
+		func(c *Config) interface{} { return &c.Telegram.Token }},
+	{"telegram-mode", []string{"TELEGRAM_MODE"}, "how updates are received: polling or webhook",
+		func(c *Config) interface{} { return &c.Telegram.Mode }},
+	{"telegram-debug", []string{"TELEGRAM_DEBUG"}, "log raw Telegram API traffic",
+		func(c *Config) interface{} { return &c.Telegram.Debug }},
+	{"telegram-poll-timeout", []string{"TELEGRAM_POLL_TIMEOUT"}, "how long each long poll waits for updates, shorter than the shutdown timeout",
+		func(c *Config) interface{} { return &c.Telegram.PollTimeout }},
+	{"webhook-url", []string{"WEBHOOK_URL"}, "public https URL Telegram sends updates to",
+		func(c *Config) interface{} { return &c.Webhook.URL }},
+	{"webhook-listen-addr", []string{"WEBHOOK_LISTEN_ADDR"}, "address the webhook server listens on",
+		func(c *Config) interface{} { return &c.Webhook.ListenAddr }},
+	{"webhook-secret", []string{"WEBHOOK_SECRET"}, "secret token Telegram sends with every update",
+		func(c *Config) interface{} { return &c.Webhook.Secret }},
+	{"webhook-cert-file", []string{"WEBHOOK_CERT_FILE"}, "TLS certificate for serving the webhook over HTTPS",
+		func(c *Config) interface{} { return &c.Webhook.CertFile }},
+	{"webhook-key-file", []string{"WEBHOOK_KEY_FILE"}, "TLS key for serving the webhook over HTTPS",
+		func(c *Config) interface{} { return &c.Webhook.KeyFile }},
+	{"webhook-max-connections", []string{"WEBHOOK_MAX_CONNECTIONS"}, "maximum concurrent webhook connections from Telegram",
+		func(c *Config) interface{} { return &c.Webhook.MaxConnections }},
+	{"mongodb-uri", []string{"MONGODB_URI"}, "MongoDB connection string",
+		func(c *Config) interface{} { return &c.MongoDB.URI }},
+	{"mongodb-database", []string{"MONGODB_DATABASE"}, "MongoDB database name",
+		func(c *Config) interface{} { return &c.MongoDB.Database }},
+	{"mongodb-collection", []string{"MONGODB_COLLECTION"}, "base name of the message collections",
+		func(c *Config) interface{} { return &c.MongoDB.Collection }},
+	{"meilisearch-host", []string{"MEILISEARCH_HOST", "MEILI_HOST"}, "Meilisearch URL",
+		func(c *Config) interface{} { return &c.Meilisearch.Host }},
+	{"meilisearch-key", []string{"MEILISEARCH_KEY", "MEILI_KEY"}, "Meilisearch API key",
+		func(c *Config) interface{} { return &c.Meilisearch.Key }},
+	{"meilisearch-index", []string{"MEILISEARCH_INDEX"}, "base name of the message indexes",
+		func(c *Config) interface{} { return &c.Meilisearch.Index }},
+	{"gemini-api-key", []string{"GEMINI_API_KEY"}, "Google Gemini API key",
+		func(c *Config) interface{} { return &c.Gemini.APIKey }},
+	{"gemini-model", []string{"GEMINI_MODEL"}, "Gemini model name",
+		func(c *Config) interface{} { return &c.Gemini.Model }},
+	{"prompts-dir", []string{"PROMPTS_DIR"}, "directory of *.tmpl prompt templates adding to or replacing the built-in ones",
+		func(c *Config) interface{} { return &c.Prompts.Dir }},
+	{"default-prompt", []string{"DEFAULT_PROMPT"}, "prompt /ask uses unless a chat picks another, empty for the built-in default",
+		func(c *Config) interface{} { return &c.Prompts.Default }},
+	{"index-batch-size", []string{"INDEX_BATCH_SIZE"}, "messages indexed per batch",
+		func(c *Config) interface{} { return &c.Indexer.BatchSize }},
+	{"index-flush-interval", []string{"INDEX_FLUSH_INTERVAL"}, "maximum time messages wait before being indexed",
+		func(c *Config) interface{} { return &c.Indexer.FlushInterval }},
+	{"worker-count", []string{"WORKER_COUNT"}, "number of updates processed concurrently",
+		func(c *Config) interface{} { return &c.Workers.Count }},
+	{"worker-queue-size", []string{"WORKER_QUEUE_SIZE"}, "updates buffered per worker",
+		func(c *Config) interface{} { return &c.Workers.QueueSize }},
+	{"handler-timeout", []string{"HANDLER_TIMEOUT"}, "deadline for handling a single update, including its database, search and AI calls",
+		func(c *Config) interface{} { return &c.Workers.HandlerTimeout }},
+	{"ask-per-user", []string{"ASK_PER_USER"}, "/ask uses per minute by one user, 0 for no limit",
+		func(c *Config) interface{} { return &c.Limits.AskPerUser }},
+	{"ask-per-chat", []string{"ASK_PER_CHAT"}, "/ask uses per minute in one chat, 0 for no limit",
+		func(c *Config) interface{} { return &c.Limits.AskPerChat }},
+	{"search-per-user", []string{"SEARCH_PER_USER"}, "/search uses per minute by one user, 0 for no limit",
+		func(c *Config) interface{} { return &c.Limits.SearchPerUser }},
+	{"search-per-chat", []string{"SEARCH_PER_CHAT"}, "/search uses per minute in one chat, 0 for no limit",
+		func(c *Config) interface{} { return &c.Limits.SearchPerChat }},
+	{"inline-per-user", []string{"INLINE_PER_USER"}, "inline queries per minute by one user, 0 for no limit",
+		func(c *Config) interface{} { return &c.Limits.InlinePerUser }},
+	{"daily-ai-tokens", []string{"DAILY_AI_TOKENS"}, "AI tokens each chat may use per day, 0 for no limit",
+		func(c *Config) interface{} { return &c.Limits.DailyAITokens }},
+	{"ops-listen-addr", []string{"OPS_LISTEN_ADDR", "METRICS_LISTEN_ADDR"}, "address serving /metrics, /healthz and /readyz, empty to disable",
+		func(c *Config) interface{} { return &c.Ops.ListenAddr }},
+	{"health-check-timeout", []string{"HEALTH_CHECK_TIMEOUT"}, "deadline for each dependency health check",
+		func(c *Config) interface{} { return &c.Ops.CheckTimeout }},
+	{"health-max-poll-age", []string{"HEALTH_MAX_POLL_AGE"}, "report unhealthy if polling Telegram hasn't succeeded for this long",
+		func(c *Config) interface{} { return &c.Ops.MaxPollAge }},
+	{"log-level", []string{"LOG_LEVEL"}, "minimum level logged: debug, info, warn or error",
+		func(c *Config) interface{} { return &c.Log.Level }},
+	{"log-format", []string{"LOG_FORMAT"}, "log format: text or json",
+		func(c *Config) interface{} { return &c.Log.Format }},
+	{"log-redact-content", []string{"LOG_REDACT_CONTENT"}, "hide message text and AI prompts and answers from logs",
+		func(c *Config) interface{} { return &c.Log.RedactContent }},
+	{"tracing-exporter", []string{"TRACING_EXPORTER"}, "where traces are sent: none, stdout or otlp",
+		func(c *Config) interface{} { return &c.Tracing.Exporter }},
+	{"tracing-endpoint", []string{"TRACING_ENDPOINT"}, "OTLP/HTTP collector host:port, empty to use OTEL_EXPORTER_OTLP_ENDPOINT",
+		func(c *Config) interface{} { return &c.Tracing.Endpoint }},
+	{"tracing-insecure", []string{"TRACING_INSECURE"}, "send OTLP traces over plain HTTP",
+		func(c *Config) interface{} { return &c.Tracing.Insecure }},
+	{"tracing-sample-ratio", []string{"TRACING_SAMPLE_RATIO"}, "fraction of updates traced, between 0 and 1",
+		func(c *Config) interface{} { return &c.Tracing.SampleRatio }},
+	{"tracing-service-name", []string{"TRACING_SERVICE_NAME"}, "service name attached to traces",
+		func(c *Config) interface{} { return &c.Tracing.ServiceName }},
+	{"reconcile-interval", []string{"RECONCILE_INTERVAL"}, "how often the search index is re-synced, 0 to disable",
+		func(c *Config) interface{} { return &c.ReconcileInterval }},
+	{"retention-interval", []string{"RETENTION_INTERVAL"}, "how often messages past a chat's retention period are deleted, 0 to disable",
+		func(c *Config) interface{} { return &c.RetentionInterval }},
+	{"shutdown-timeout", []string{"SHUTDOWN_TIMEOUT"}, "how long shutdown waits for in-flight work",
+		func(c *Config) interface{} { return &c.ShutdownTimeout }},
+}
+
+// Load builds the configuration from defaults, an optional YAML file, the
+// environment and command line flags, in increasing order of precedence.
+// The file is given with -config or CONFIG_FILE. Environment variables
+// that are set but empty count, e.g. OPS_LISTEN_ADDR= disables the ops
+// endpoints. It returns the arguments left after the flags, e.g. a
+// subcommand. Subcommands only talk to MongoDB
+// and Meilisearch, so the Telegram and Gemini settings are only checked when
+// the bot itself runs. All problems are reported together.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, []string, error) {
+	flags := flag.NewFlagSet("searchbot", flag.ContinueOnError)
+	defaultFile, _ := lookupEnv("CONFIG_FILE")
+	configFile := flags.String("config", defaultFile, "path to a YAML config file")
+
+	// Remember flag values so they can be applied after the file and environment
+	flagValues := make(map[string]string)
+	for _, s := range settings {
+		name := s.flag
+		flags.Func(name, s.usage+" (env "+s.env[0]+")", func(value string) error {
+			flagValues[name] = value
+			return nil
+		})
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	cfg := Default()
+
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var problems []string
+	for _, s := range settings {
+		for i, name := range s.env {
+			value, ok := lookupEnv(name)
+			if !ok {
+				continue
+			}
+			if i > 0 {
+				slog.Warn("Deprecated environment variable", "name", name, "use", s.env[0])
+			}
+			if err := set(s.field(cfg), value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+			}
+			break
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := flagValues[s.flag]; ok {
+			if err := set(s.field(cfg), value); err != nil {
+				problems = append(problems, fmt.Sprintf("-%s: %v", s.flag, err))
+			}
+		}
+	}
+
+	problems = append(problems, cfg.validate(flags.NArg() == 0)...)
+	if len(problems) > 0 {
+		return nil, nil, &ValidationError{Problems: problems}
+	}
+
+	return cfg, flags.Args(), nil
+}
+
+// loadFile overrides the configuration with the values from a YAML file
+func (c *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %v", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true) // Catch typos in option names
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// set parses a string value into the field it points to
+func set(field interface{}, value string) error {
+	switch f := field.(type) {
+	case *string:
+		*f = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		*f = n
+	case *int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		*f = n
+	case *float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		*f = n
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		*f = b
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		*f = d
+	default:
+		return fmt.Errorf("unsupported setting type %T", field)
+	}
+	return nil
+}
+
+// Secrets returns the configured credentials, which must never be logged
+func (c *Config) Secrets() []string {
+	return []string{c.Telegram.Token, c.Webhook.Secret, c.Meilisearch.Key, c.Gemini.APIKey}
+}
+
+// ValidationError lists every problem found in a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// validate returns the problems with the configuration. Settings only the
+// bot uses are skipped unless runsBot is set.
+func (c *Config) validate(runsBot bool) []string {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	if runsBot {
+		check(c.Telegram.Token != "", "telegram token is required (TELEGRAM_BOT_TOKEN)")
+		check(c.Telegram.Mode == "polling" || c.Telegram.Mode == "webhook",
+			"telegram mode must be polling or webhook, got %q", c.Telegram.Mode)
+		check(c.Gemini.APIKey != "", "Gemini API key is required (GEMINI_API_KEY)")
+		check(c.Gemini.Model != "", "Gemini model is required")
+	}
+
+	if runsBot && c.Telegram.Mode == "webhook" {
+		webhookURL, err := url.Parse(c.Webhook.URL)
+		check(err == nil && webhookURL.Scheme == "https" && webhookURL.Host != "",
+			"webhook URL must be an absolute https URL (WEBHOOK_URL), got %q", c.Webhook.URL)
+		check(c.Webhook.ListenAddr != "", "webhook listen address is required (WEBHOOK_LISTEN_ADDR)")
+		check(webhook.ValidSecret(c.Webhook.Secret),
+			"webhook secret must be 1-256 characters of A-Z, a-z, 0-9, _ and - (WEBHOOK_SECRET)")
+		check((c.Webhook.CertFile == "") == (c.Webhook.KeyFile == ""),
+			"webhook TLS needs both a certificate and a key file")
+		check(c.Webhook.MaxConnections >= 0, "webhook max connections must not be negative")
+	}
+
+	check(c.MongoDB.URI != "", "MongoDB URI is required (MONGODB_URI)")
+	check(c.MongoDB.Database != "", "MongoDB database name is required")
+	check(c.MongoDB.Collection != "", "MongoDB collection name is required")
+
+	meiliURL, err := url.Parse(c.Meilisearch.Host)
+	check(err == nil && meiliURL.Scheme != "" && meiliURL.Host != "",
+		"Meilisearch host must be a URL like http://localhost:7700 (MEILISEARCH_HOST), got %q", c.Meilisearch.Host)
+	check(c.Meilisearch.Index != "", "Meilisearch index name is required")
+
+	check(c.Indexer.BatchSize > 0, "index batch size must be positive")
+	check(c.Indexer.FlushInterval > 0, "index flush interval must be positive")
+	check(c.Workers.Count > 0, "worker count must be positive")
+	check(c.Workers.QueueSize > 0, "worker queue size must be positive")
+	check(c.Workers.HandlerTimeout > 0, "handler timeout must be positive")
+	check(c.Limits.AskPerUser >= 0 && c.Limits.AskPerChat >= 0 &&
+		c.Limits.SearchPerUser >= 0 && c.Limits.SearchPerChat >= 0 &&
+		c.Limits.InlinePerUser >= 0, "rate limits must not be negative")
+	check(c.Limits.DailyAITokens >= 0, "daily AI token quota must not be negative")
+	check(c.Ops.CheckTimeout > 0, "health check timeout must be positive")
+	if runsBot && c.Telegram.Mode == "polling" {
+		check(c.Telegram.PollTimeout >= time.Second, "telegram poll timeout must be at least 1s")
+		check(c.Ops.MaxPollAge > c.Telegram.PollTimeout, "health max poll age must be longer than the telegram poll timeout")
+		check(c.ShutdownTimeout > c.Telegram.PollTimeout, "shutdown timeout must be longer than the telegram poll timeout")
+	}
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "log level must be debug, info, warn or error, got %q", c.Log.Level)
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log format must be text or json, got %q", c.Log.Format)
+	check(c.Tracing.Exporter == "none" || c.Tracing.Exporter == "stdout" || c.Tracing.Exporter == "otlp",
+		"tracing exporter must be none, stdout or otlp, got %q", c.Tracing.Exporter)
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing sample ratio must be between 0 and 1")
+	check(c.Tracing.ServiceName != "", "tracing service name is required")
+	check(c.ReconcileInterval >= 0, "reconcile interval must not be negative")
+	check(c.RetentionInterval >= 0, "retention interval must not be negative")
+	check(c.ShutdownTimeout > 0, "shutdown timeout must be positive")
+
+	return problems
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// env returns a lookup function over a fixed set of environment variables
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+// required are the settings the bot can't start without
+var required = map[string]string{
+	"TELEGRAM_BOT_TOKEN": "token",
+	"GEMINI_API_KEY":     "key",
+	"MONGODB_URI":        "mongodb://localhost:27017",
+}
+
+// withRequired adds the required settings to vars
+func withRequired(vars map[string]string) map[string]string {
+	merged := make(map[string]string, len(required)+len(vars))
+	for name, value := range required {
+		merged[name] = value
+	}
+	for name, value := range vars {
+		merged[name] = value
+	}
+	return merged
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	content := "workers:\n  count: 4\nops:\n  listen_addr: \":9100\"\nmeilisearch:\n  host: http://file:7700\n"
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		args      []string
+		env       map[string]string
+		workers   int
+		opsAddr   string
+		meiliHost string
+	}{
+		{
+			name:      "defaults",
+			workers:   8,
+			opsAddr:   ":9090",
+			meiliHost: "http://localhost:7700",
+		},
+		{
+			name:      "file overrides defaults",
+			args:      []string{"-config", file},
+			workers:   4,
+			opsAddr:   ":9100",
+			meiliHost: "http://file:7700",
+		},
+		{
+			name:      "file from CONFIG_FILE",
+			env:       map[string]string{"CONFIG_FILE": file},
+			workers:   4,
+			opsAddr:   ":9100",
+			meiliHost: "http://file:7700",
+		},
+		{
+			name:      "env overrides file",
+			args:      []string{"-config", file},
+			env:       map[string]string{"WORKER_COUNT": "5", "MEILISEARCH_HOST": "http://env:7700"},
+			workers:   5,
+			opsAddr:   ":9100",
+			meiliHost: "http://env:7700",
+		},
+		{
+			name:      "flag overrides env",
+			args:      []string{"-config", file, "-worker-count", "6"},
+			env:       map[string]string{"WORKER_COUNT": "5"},
+			workers:   6,
+			opsAddr:   ":9100",
+			meiliHost: "http://file:7700",
+		},
+		{
+			name:      "empty env overrides file",
+			args:      []string{"-config", file},
+			env:       map[string]string{"OPS_LISTEN_ADDR": ""},
+			workers:   4,
+			opsAddr:   "",
+			meiliHost: "http://file:7700",
+		},
+		{
+			name:      "deprecated alias",
+			env:       map[string]string{"MEILI_HOST": "http://alias:7700"},
+			workers:   8,
+			opsAddr:   ":9090",
+			meiliHost: "http://alias:7700",
+		},
+		{
+			name:      "canonical name wins over alias",
+			env:       map[string]string{"MEILISEARCH_HOST": "http://env:7700", "MEILI_HOST": "http://alias:7700"},
+			workers:   8,
+			opsAddr:   ":9090",
+			meiliHost: "http://env:7700",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, _, err := Load(tt.args, env(withRequired(tt.env)))
+			if err != nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+			if cfg.Workers.Count != tt.workers {
+				t.Errorf("worker count is %d, want %d", cfg.Workers.Count, tt.workers)
+			}
+			if cfg.Ops.ListenAddr != tt.opsAddr {
+				t.Errorf("ops listen address is %q, want %q", cfg.Ops.ListenAddr, tt.opsAddr)
+			}
+			if cfg.Meilisearch.Host != tt.meiliHost {
+				t.Errorf("Meilisearch host is %q, want %q", cfg.Meilisearch.Host, tt.meiliHost)
+			}
+		})
+	}
+}
+
+func TestLoadValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		problems []string // Substrings of the expected problems, in order
+	}{
+		{
+			name: "valid",
+			env:  required,
+		},
+		{
+			name:     "all problems reported together",
+			env:      map[string]string{"WORKER_COUNT": "many", "TELEGRAM_MODE": "push", "LOG_FORMAT": "xml"},
+			problems: []string{"WORKER_COUNT", "telegram token", "telegram mode", "Gemini API key", "MongoDB URI", "log format"},
+		},
+		{
+			name:     "bad flag values",
+			args:     []string{"-shutdown-timeout", "soon"},
+			env:      required,
+			problems: []string{"-shutdown-timeout"},
+		},
+		{
+			name: "subcommands don't need bot secrets",
+			args: []string{"reindex"},
+			env:  map[string]string{"MONGODB_URI": "mongodb://localhost:27017", "TELEGRAM_MODE": "push"},
+		},
+		{
+			name: "webhook settings",
+			env: withRequired(map[string]string{
+				"TELEGRAM_MODE":     "webhook",
+				"WEBHOOK_URL":       "http://example.com/hook",
+				"WEBHOOK_SECRET":    "not secret!",
+				"WEBHOOK_CERT_FILE": "cert.pem",
+			}),
+			problems: []string{"webhook URL", "webhook secret", "webhook TLS"},
+		},
+		{
+			name:     "poll timeout must fit the shutdown timeout",
+			env:      withRequired(map[string]string{"TELEGRAM_POLL_TIMEOUT": "5m"}),
+			problems: []string{"health max poll age", "shutdown timeout"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Load(tt.args, env(tt.env))
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Fatalf("Load failed: %v", err)
+				}
+				return
+			}
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("got error %v, want a ValidationError", err)
+			}
+			if len(validationErr.Problems) != len(tt.problems) {
+				t.Fatalf("got %d problems, want %d:\n%v", len(validationErr.Problems), len(tt.problems), err)
+			}
+			for i, want := range tt.problems {
+				if !strings.Contains(validationErr.Problems[i], want) {
+					t.Errorf("problem %d is %q, want it to mention %q", i, validationErr.Problems[i], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"SearchBot/internal/logging"
+	"SearchBot/internal/metrics"
+	"SearchBot/internal/tracing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrClosed is returned by Submit once the dispatcher is shutting down
+var ErrClosed = errors.New("dispatcher is shut down")
+
+// Handler processes a single update
+type Handler func(ctx context.Context, update tgbotapi.Update)
+
+// Config controls the dispatcher's concurrency
+type Config struct {
+	Workers        int           // Number of updates processed concurrently
+	QueueSize      int           // Updates buffered per worker before Submit blocks
+	HandlerTimeout time.Duration // Deadline for handling a single update
+}
+
+// DefaultConfig returns the default dispatcher settings
+func DefaultConfig() Config {
+	return Config{
+		Workers:        8,
+		QueueSize:      100,
+		HandlerTimeout: 60 * time.Second,
+	}
+}
+
+// abortTimeout is how long Shutdown waits for handlers to return once they
+// have been canceled
+const abortTimeout = 5 * time.Second
+
+// Dispatcher hands updates to a bounded pool of workers. Every chat has a
+// queue of its own, so updates for one chat are handled one at a time, in
+// the order they were received, while a slow update only holds up its own
+// chat. Chats with waiting updates take turns for the next free worker.
+type Dispatcher struct {
+	handler Handler
+	config  Config
+	wg      sync.WaitGroup
+
+	// slots holds a token for every update waiting in a queue, so Submit
+	// blocks once Workers * QueueSize updates are waiting
+	slots chan struct{}
+
+	// ready lists the chats with waiting updates that no worker is handling.
+	// A chat is listed at most once and only while it holds a slot, so
+	// sending never blocks.
+	ready chan int64
+
+	// mu guards the chat queues and closed. A chat has a queue while it is
+	// listed in ready or one of its updates is being handled.
+	mu     sync.Mutex
+	chats  map[int64][]tgbotapi.Update
+	closed bool
+
+	// ctx is the parent of every handler context, canceled to abort
+	// in-flight work when a shutdown deadline expires
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewDispatcher creates a dispatcher and starts its workers
+func NewDispatcher(handler Handler, config Config) *Dispatcher {
+	defaults := DefaultConfig()
+	if config.Workers <= 0 {
+		config.Workers = defaults.Workers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaults.QueueSize
+	}
+	if config.HandlerTimeout <= 0 {
+		config.HandlerTimeout = defaults.HandlerTimeout
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	capacity := config.Workers * config.QueueSize
+	d := &Dispatcher{
+		handler: handler,
+		config:  config,
+		slots:   make(chan struct{}, capacity),
+		ready:   make(chan int64, capacity),
+		chats:   make(map[int64][]tgbotapi.Update),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	for i := 0; i < config.Workers; i++ {
+		d.wg.Add(1)
+		go d.worker()
+	}
+
+	return d
+}
+
+// Submit queues an update for its chat. It blocks while the dispatcher is
+// full, which slows down polling instead of buffering without bound, and
+// returns early if ctx is done.
+func (d *Dispatcher) Submit(ctx context.Context, update tgbotapi.Update) error {
+	if d.isClosed() {
+		return ErrClosed
+	}
+
+	select {
+	case d.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		<-d.slots
+		return ErrClosed
+	}
+
+	chatID := ChatID(update)
+	queue, scheduled := d.chats[chatID]
+	d.chats[chatID] = append(queue, update)
+	if !scheduled {
+		d.ready <- chatID
+	}
+	return nil
+}
+
+// isClosed reports whether Shutdown has been called
+func (d *Dispatcher) isClosed() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.closed
+}
+
+// QueueDepth returns the number of updates waiting to be handled
+func (d *Dispatcher) QueueDepth() int {
+	return len(d.slots)
+}
+
+// Shutdown stops accepting updates and waits for the workers to drain the
+// queues. If ctx expires first, in-flight handlers are canceled, the
+// remaining queued updates are dropped and ctx's error is returned. Handlers
+// that ignore the cancellation are given up on after abortTimeout.
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	d.closed = true
+	if len(d.chats) == 0 {
+		close(d.ready)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		d.cancel()
+		return nil
+	case <-ctx.Done():
+		slog.Warn("Shutdown deadline reached, aborting queued updates", "count", d.QueueDepth())
+		d.cancel()
+	}
+
+	select {
+	case <-done:
+	case <-time.After(abortTimeout):
+		slog.Error("Handlers still running after being canceled, giving up on them")
+	}
+	return ctx.Err()
+}
+
+// worker handles the next update of whichever chat has waited longest. A
+// chat with more updates waiting goes to the back of the line, so busy
+// chats can't starve the others.
+func (d *Dispatcher) worker() {
+	defer d.wg.Done()
+
+	for chatID := range d.ready {
+		d.mu.Lock()
+		update := d.chats[chatID][0]
+		d.chats[chatID] = d.chats[chatID][1:]
+		d.mu.Unlock()
+		<-d.slots
+
+		// Drop whatever is left once the dispatcher has been aborted
+		if d.ctx.Err() == nil {
+			d.handle(update)
+		}
+
+		d.mu.Lock()
+		if len(d.chats[chatID]) > 0 {
+			d.ready <- chatID
+		} else {
+			delete(d.chats, chatID)
+			// The last queue is drained, let the workers exit
+			if d.closed && len(d.chats) == 0 {
+				close(d.ready)
+			}
+		}
+		d.mu.Unlock()
+	}
+}
+
+// handle runs the handler with a timeout and recovers from panics so one bad
+// update can't take down the worker
+func (d *Dispatcher) handle(update tgbotapi.Update) {
+	ctx, cancel := context.WithTimeout(d.ctx, d.config.HandlerTimeout)
+	defer cancel()
+
+	updateType := UpdateType(update)
+	chatID := ChatID(update)
+	ctx = logging.With(ctx, "update_id", update.UpdateID, "chat_id", chatID, "update_type", updateType)
+	defer func(start time.Time) {
+		metrics.UpdatesProcessed.WithLabelValues(updateType).Inc()
+		metrics.UpdateDuration.WithLabelValues(updateType).Observe(time.Since(start).Seconds())
+	}(time.Now())
+
+	// Each update is the root of its own trace
+	ctx, span := tracing.Start(ctx, "telegram.update",
+		attribute.Int("update_id", update.UpdateID),
+		attribute.Int64("chat_id", chatID),
+		attribute.String("update_type", updateType))
+	var panicErr error
+	defer func() { tracing.End(span, panicErr) }()
+
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr = fmt.Errorf("panic: %v", r)
+			slog.ErrorContext(ctx, "Panic while handling update", "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+
+	d.handler(ctx, update)
+}
+
+// ChatID returns the chat an update belongs to, or the sender for updates
+// that aren't tied to a chat
+func ChatID(update tgbotapi.Update) int64 {
+	switch {
+	case update.Message != nil:
+		return update.Message.Chat.ID
+	case update.EditedMessage != nil:
+		return update.EditedMessage.Chat.ID
+	case update.ChannelPost != nil:
+		return update.ChannelPost.Chat.ID
+	case update.EditedChannelPost != nil:
+		return update.EditedChannelPost.Chat.ID
+	case update.MyChatMember != nil:
+		return update.MyChatMember.Chat.ID
+	case update.ChatMember != nil:
+		return update.ChatMember.Chat.ID
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil:
+		return update.CallbackQuery.Message.Chat.ID
+	case update.CallbackQuery != nil:
+		return update.CallbackQuery.From.ID
+	case update.InlineQuery != nil:
+		return update.InlineQuery.From.ID
+	case update.ChosenInlineResult != nil:
+		return update.ChosenInlineResult.From.ID
+	default:
+		return 0
+	}
+}
+
+// UpdateType names the kind of an update, e.g. "message" or "callback_query"
+func UpdateType(update tgbotapi.Update) string {
+	switch {
+	case update.Message != nil:
+		return "message"
+	case update.EditedMessage != nil:
+		return "edited_message"
+	case update.ChannelPost != nil:
+		return "channel_post"
+	case update.EditedChannelPost != nil:
+		return "edited_channel_post"
+	case update.MyChatMember != nil:
+		return "my_chat_member"
+	case update.ChatMember != nil:
+		return "chat_member"
+	case update.CallbackQuery != nil:
+		return "callback_query"
+	case update.InlineQuery != nil:
+		return "inline_query"
+	case update.ChosenInlineResult != nil:
+		return "chosen_inline_result"
+	default:
+		return "other"
+	}
+}
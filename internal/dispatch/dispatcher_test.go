@@ -0,0 +1,117 @@
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// message returns a message update for a chat
+func message(chatID int64, updateID int) tgbotapi.Update {
+	return tgbotapi.Update{
+		UpdateID: updateID,
+		Message:  &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}},
+	}
+}
+
+func TestDispatcherKeepsChatOrder(t *testing.T) {
+	const chats, perChat = 5, 50
+
+	var mu sync.Mutex
+	handled := make(map[int64][]int)
+	active := make(map[int64]bool)
+	handler := func(ctx context.Context, update tgbotapi.Update) {
+		chatID := ChatID(update)
+		mu.Lock()
+		if active[chatID] {
+			t.Errorf("chat %d handled two updates at once", chatID)
+		}
+		active[chatID] = true
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		active[chatID] = false
+		handled[chatID] = append(handled[chatID], update.UpdateID)
+		mu.Unlock()
+	}
+
+	d := NewDispatcher(handler, Config{Workers: 4, QueueSize: 10})
+	for i := 0; i < perChat; i++ {
+		for chatID := int64(1); chatID <= chats; chatID++ {
+			if err := d.Submit(context.Background(), message(chatID, i)); err != nil {
+				t.Fatalf("Submit failed: %v", err)
+			}
+		}
+	}
+	if err := d.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	for chatID := int64(1); chatID <= chats; chatID++ {
+		updates := handled[chatID]
+		if len(updates) != perChat {
+			t.Fatalf("chat %d had %d updates handled, want %d", chatID, len(updates), perChat)
+		}
+		for i, updateID := range updates {
+			if updateID != i {
+				t.Fatalf("chat %d handled update %d in position %d", chatID, updateID, i)
+			}
+		}
+	}
+}
+
+func TestDispatcherShutdownDrainsQueues(t *testing.T) {
+	var handled atomic.Int32
+	d := NewDispatcher(func(ctx context.Context, update tgbotapi.Update) {
+		time.Sleep(time.Millisecond)
+		handled.Add(1)
+	}, Config{Workers: 2})
+
+	for i := 0; i < 20; i++ {
+		if err := d.Submit(context.Background(), message(int64(i%3), i)); err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
+	}
+	if err := d.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if n := handled.Load(); n != 20 {
+		t.Errorf("%d updates handled, want 20", n)
+	}
+
+	if err := d.Submit(context.Background(), message(1, 20)); !errors.Is(err, ErrClosed) {
+		t.Errorf("Submit after Shutdown returned %v, want ErrClosed", err)
+	}
+}
+
+func TestDispatcherShutdownDeadline(t *testing.T) {
+	var handled atomic.Int32
+	d := NewDispatcher(func(ctx context.Context, update tgbotapi.Update) {
+		// Hold up the chat until the dispatcher gives up on it
+		<-ctx.Done()
+		handled.Add(1)
+	}, Config{Workers: 1})
+
+	for i := 0; i < 5; i++ {
+		if err := d.Submit(context.Background(), message(1, i)); err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := d.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown returned %v, want a deadline error", err)
+	}
+	// The in-flight update is canceled and the queued ones are dropped
+	if n := handled.Load(); n != 1 {
+		t.Errorf("%d updates handled, want 1", n)
+	}
+}
@@ -0,0 +1,181 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check returns an error if a dependency is unhealthy
+type Check func(ctx context.Context) error
+
+// namedCheck is a check and the name it's reported under
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Result is the outcome of a single check
+type Result struct {
+	Status     string `json:"status"` // "ok" or "fail"
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// Report is the outcome of a set of checks
+type Report struct {
+	Status string            `json:"status"` // "ok" if every check passed, "fail" otherwise
+	Checks map[string]Result `json:"checks"`
+}
+
+// OK reports whether every check passed
+func (r Report) OK() bool {
+	return r.Status == "ok"
+}
+
+// Checker runs liveness and readiness checks. Liveness checks only fail if
+// the bot itself is wedged and should be restarted; readiness checks also
+// cover the backends it needs to do useful work.
+type Checker struct {
+	timeout   time.Duration
+	liveness  []namedCheck
+	readiness []namedCheck
+}
+
+// NewChecker creates a new Checker that gives each check timeout to finish
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// AddLiveness adds a check to both /healthz and /readyz
+func (c *Checker) AddLiveness(name string, check Check) {
+	c.liveness = append(c.liveness, namedCheck{name: name, check: check})
+}
+
+// AddReadiness adds a check to /readyz
+func (c *Checker) AddReadiness(name string, check Check) {
+	c.readiness = append(c.readiness, namedCheck{name: name, check: check})
+}
+
+// Live runs the liveness checks
+func (c *Checker) Live(ctx context.Context) Report {
+	return c.run(ctx, c.liveness)
+}
+
+// Ready runs the liveness and readiness checks
+func (c *Checker) Ready(ctx context.Context) Report {
+	checks := make([]namedCheck, 0, len(c.liveness)+len(c.readiness))
+	checks = append(checks, c.liveness...)
+	checks = append(checks, c.readiness...)
+	return c.run(ctx, checks)
+}
+
+// run runs checks concurrently, each with its own timeout
+func (c *Checker) run(ctx context.Context, checks []namedCheck) Report {
+	report := Report{Status: "ok", Checks: make(map[string]Result, len(checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, nc := range checks {
+		wg.Add(1)
+		go func(nc namedCheck) {
+			defer wg.Done()
+			result := c.runOne(ctx, nc.check)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[nc.name] = result
+			if result.Status != "ok" {
+				report.Status = "fail"
+			}
+		}(nc)
+	}
+	wg.Wait()
+
+	return report
+}
+
+// runOne runs a check, giving up once it exceeds the timeout. Checks of
+// clients that don't take a context keep running in the background.
+func (c *Checker) runOne(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- check(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", c.timeout)
+	}
+
+	result := Result{Status: "ok", DurationMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Status = "fail"
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// LivenessHandler serves /healthz
+func (c *Checker) LivenessHandler() http.Handler {
+	return reportHandler(c.Live)
+}
+
+// ReadinessHandler serves /readyz
+func (c *Checker) ReadinessHandler() http.Handler {
+	return reportHandler(c.Ready)
+}
+
+// reportHandler serves a report as JSON, with status 503 if a check failed
+func reportHandler(run func(context.Context) Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := run(r.Context())
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if !report.OK() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
+
+// Heartbeat records when something last succeeded, e.g. polling for updates
+type Heartbeat struct {
+	last atomic.Int64 // Unix nanoseconds
+}
+
+// NewHeartbeat creates a new Heartbeat that starts beating now
+func NewHeartbeat() *Heartbeat {
+	h := &Heartbeat{}
+	h.Beat()
+	return h
+}
+
+// Beat records a success
+func (h *Heartbeat) Beat() {
+	h.last.Store(time.Now().UnixNano())
+}
+
+// Age returns the time since the last success
+func (h *Heartbeat) Age() time.Duration {
+	return time.Since(time.Unix(0, h.last.Load()))
+}
+
+// Check fails once the last success is older than maxAge
+func (h *Heartbeat) Check(maxAge time.Duration) Check {
+	return func(ctx context.Context) error {
+		if age := h.Age(); age > maxAge {
+			return fmt.Errorf("last success %s ago, limit is %s", age.Round(time.Second), maxAge)
+		}
+		return nil
+	}
+}
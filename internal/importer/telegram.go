@@ -0,0 +1,175 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"SearchBot/internal/models"
+)
+
+// Export is a parsed Telegram Desktop chat export (result.json)
+type Export struct {
+	ChatID   int64
+	Title    string
+	Messages []models.Message
+}
+
+// rawExport mirrors the parts of the Telegram Desktop JSON export we use
+type rawExport struct {
+	Name     string       `json:"name"`
+	Type     string       `json:"type"`
+	ID       int64        `json:"id"`
+	Messages []rawMessage `json:"messages"`
+}
+
+type rawMessage struct {
+	ID           int64           `json:"id"`
+	Type         string          `json:"type"`
+	Date         string          `json:"date"`
+	DateUnixtime string          `json:"date_unixtime"`
+	From         string          `json:"from"`
+	FromID       string          `json:"from_id"`
+	Text         json.RawMessage `json:"text"`
+}
+
+// ParseTelegramExport reads a Telegram Desktop JSON export of a group chat
+func ParseTelegramExport(r io.Reader) (*Export, error) {
+	var raw rawExport
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to decode export: %v", err)
+	}
+
+	chatID, err := exportChatID(raw.Type, raw.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &Export{
+		ChatID: chatID,
+		Title:  raw.Name,
+	}
+
+	for _, m := range raw.Messages {
+		// Skip service messages (joins, pins, title changes...)
+		if m.Type != "message" {
+			continue
+		}
+
+		text, err := flattenText(m.Text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode text of message %d: %v", m.ID, err)
+		}
+		// Only store text messages
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		createdAt, err := messageTime(m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse date of message %d: %v", m.ID, err)
+		}
+
+		export.Messages = append(export.Messages, models.Message{
+			MessageID: m.ID,
+			ChatID:    chatID,
+			UserID:    senderID(m.FromID),
+			// Exports only contain display names, not @usernames
+			DisplayName: m.From,
+			Text:        text,
+			CreatedAt:   createdAt,
+		})
+	}
+
+	return export, nil
+}
+
+// exportChatID converts the export's chat ID into the Bot API chat ID
+func exportChatID(chatType string, id int64) (int64, error) {
+	if id == 0 {
+		return 0, fmt.Errorf("export does not contain a chat ID")
+	}
+
+	switch chatType {
+	case "private_supergroup", "public_supergroup":
+		// Supergroups use the -100 prefix in the Bot API
+		chatID, err := strconv.ParseInt(fmt.Sprintf("-100%d", id), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid supergroup ID %d: %v", id, err)
+		}
+		return chatID, nil
+	case "private_group":
+		return -id, nil
+	default:
+		return 0, fmt.Errorf("unsupported chat type %q: only group exports can be imported", chatType)
+	}
+}
+
+// flattenText joins the export's text field, which is either a plain string
+// or a list of plain strings and formatted entities
+func flattenText(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 {
+		return "", nil
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+
+	var parts []json.RawMessage
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", err
+	}
+
+	var builder strings.Builder
+	for _, part := range parts {
+		var plain string
+		if err := json.Unmarshal(part, &plain); err == nil {
+			builder.WriteString(plain)
+			continue
+		}
+
+		var entity struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(part, &entity); err != nil {
+			return "", err
+		}
+		builder.WriteString(entity.Text)
+	}
+
+	return builder.String(), nil
+}
+
+// messageTime returns the original send time of an exported message
+func messageTime(m rawMessage) (time.Time, error) {
+	// Newer exports include the exact unix timestamp
+	if m.DateUnixtime != "" {
+		seconds, err := strconv.ParseInt(m.DateUnixtime, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(seconds, 0), nil
+	}
+
+	// Older exports only have the local time of the exporting machine
+	return time.ParseInLocation("2006-01-02T15:04:05", m.Date, time.Local)
+}
+
+// senderID extracts the numeric ID from a from_id like "user123456"
+func senderID(fromID string) int64 {
+	for _, prefix := range []string{"user", "channel"} {
+		if strings.HasPrefix(fromID, prefix) {
+			id, err := strconv.ParseInt(strings.TrimPrefix(fromID, prefix), 10, 64)
+			if err != nil {
+				return 0
+			}
+			return id
+		}
+	}
+	return 0
+}
@@ -0,0 +1,143 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// export is a Telegram Desktop export of a supergroup with one message of
+// every kind the importer has to deal with
+const export = `{
+  "name": "Gophers",
+  "type": "private_supergroup",
+  "id": 1234567890,
+  "messages": [
+    {
+      "id": 1,
+      "type": "service",
+      "date": "2024-05-06T09:00:00",
+      "date_unixtime": "1714986000",
+      "actor": "Alice Smith",
+      "action": "invite_members",
+      "text": ""
+    },
+    {
+      "id": 2,
+      "type": "message",
+      "date": "2024-05-06T09:01:00",
+      "date_unixtime": "1714986060",
+      "from": "Alice Smith",
+      "from_id": "user1000",
+      "text": "Plain text"
+    },
+    {
+      "id": 3,
+      "type": "message",
+      "date": "2024-05-06T09:02:00",
+      "date_unixtime": "1714986120",
+      "from": "Bob",
+      "from_id": "user1001",
+      "text": [
+        "See ",
+        {"type": "link", "text": "https://go.dev"},
+        " and ",
+        {"type": "bold", "text": "read it"},
+        "!"
+      ],
+      "text_entities": [
+        {"type": "plain", "text": "See "},
+        {"type": "link", "text": "https://go.dev"},
+        {"type": "plain", "text": " and "},
+        {"type": "bold", "text": "read it"},
+        {"type": "plain", "text": "!"}
+      ]
+    },
+    {
+      "id": 4,
+      "type": "message",
+      "date": "2024-05-06T09:03:00",
+      "date_unixtime": "1714986180",
+      "from": "Bob",
+      "from_id": "user1001",
+      "photo": "photos/photo_1.jpg",
+      "text": ""
+    },
+    {
+      "id": 5,
+      "type": "message",
+      "date": "2024-05-06T09:04:00",
+      "from": "News",
+      "from_id": "channel42",
+      "text": "Posted by a channel, in an older export"
+    }
+  ]
+}`
+
+func TestParseTelegramExport(t *testing.T) {
+	got, err := ParseTelegramExport(strings.NewReader(export))
+	if err != nil {
+		t.Fatalf("ParseTelegramExport failed: %v", err)
+	}
+
+	if got.ChatID != -1001234567890 {
+		t.Errorf("chat ID is %d, want -1001234567890", got.ChatID)
+	}
+	if got.Title != "Gophers" {
+		t.Errorf("title is %q, want Gophers", got.Title)
+	}
+
+	want := []struct {
+		id          int64
+		userID      int64
+		displayName string
+		text        string
+		createdAt   time.Time
+	}{
+		{id: 2, userID: 1000, displayName: "Alice Smith", text: "Plain text", createdAt: time.Unix(1714986060, 0)},
+		{id: 3, userID: 1001, displayName: "Bob", text: "See https://go.dev and read it!", createdAt: time.Unix(1714986120, 0)},
+		{id: 5, userID: 42, displayName: "News", text: "Posted by a channel, in an older export",
+			createdAt: time.Date(2024, 5, 6, 9, 4, 0, 0, time.Local)},
+	}
+	if len(got.Messages) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(got.Messages), len(want))
+	}
+	for i, w := range want {
+		m := got.Messages[i]
+		if m.MessageID != w.id || m.ChatID != got.ChatID || m.UserID != w.userID ||
+			m.DisplayName != w.displayName || m.Username != "" || m.Text != w.text || !m.CreatedAt.Equal(w.createdAt) {
+			t.Errorf("message %d is %+v, want %+v", i, m, w)
+		}
+	}
+}
+
+func TestParseTelegramExportErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		export string
+		want   string
+	}{
+		{name: "not JSON", export: "result.html", want: "failed to decode export"},
+		{name: "private chat", export: `{"type": "personal_chat", "id": 1}`, want: "only group exports"},
+		{name: "no chat ID", export: `{"type": "private_group"}`, want: "does not contain a chat ID"},
+		{
+			name:   "bad text",
+			export: `{"type": "private_group", "id": 1, "messages": [{"id": 7, "type": "message", "text": 3}]}`,
+			want:   "text of message 7",
+		},
+		{
+			name:   "bad date",
+			export: `{"type": "private_group", "id": 1, "messages": [{"id": 8, "type": "message", "text": "hi", "date": "yesterday"}]}`,
+			want:   "date of message 8",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTelegramExport(strings.NewReader(tt.export))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// Config controls how logs are written
+type Config struct {
+	Level         string   // debug, info, warn or error
+	Format        string   // text or json
+	RedactContent bool     // Hide message text and AI prompts/answers
+	Secrets       []string // Values that must never appear in logs, e.g. API keys
+}
+
+// redactContent is set by Setup and read by Content
+var redactContent atomic.Bool
+
+// Patterns of credentials that may appear in errors and URLs
+var (
+	telegramToken  = regexp.MustCompile(`\d{6,12}:[A-Za-z0-9_-]{30,}`)
+	googleAPIKey   = regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)
+	uriCredentials = regexp.MustCompile(`([A-Za-z][A-Za-z0-9+.-]*://[^/\s:@]+):[^/\s@]+@`)
+	secretParams   = regexp.MustCompile(`(?i)([?&](?:key|token|api_key|apikey|secret|password)=)[^&\s]+`)
+)
+
+// Setup creates the logger described by config and makes it the default for
+// both log/slog and the standard log package
+func Setup(w io.Writer, config Config) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(config.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", config.Level)
+	}
+
+	scrubber := newScrubber(config.Secrets)
+	opts := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			return scrubber.attr(a)
+		},
+	}
+
+	var handler slog.Handler
+	switch config.Format {
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text", "":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q", config.Format)
+	}
+
+	redactContent.Store(config.RedactContent)
+
+	logger := slog.New(contextHandler{handler})
+	slog.SetDefault(logger)
+	return logger, nil
+}
+
+// Content returns an attribute holding chat content such as message text,
+// which is replaced by its length when content redaction is on
+func Content(key, value string) slog.Attr {
+	if redactContent.Load() {
+		return slog.String(key, fmt.Sprintf("[redacted %d chars]", len(value)))
+	}
+	return slog.String(key, value)
+}
+
+// RedactsContent reports whether chat content is hidden from logs
+func RedactsContent() bool {
+	return redactContent.Load()
+}
+
+// scrubber removes credentials from log output
+type scrubber struct {
+	secrets *strings.Replacer
+}
+
+// newScrubber creates a scrubber that also hides the given literal secrets
+func newScrubber(secrets []string) *scrubber {
+	var pairs []string
+	for _, secret := range secrets {
+		// Very short values would redact unrelated text
+		if len(secret) >= 8 {
+			pairs = append(pairs, secret, "[redacted]")
+		}
+	}
+	return &scrubber{secrets: strings.NewReplacer(pairs...)}
+}
+
+// attr scrubs string and error attributes, including the message itself
+func (s *scrubber) attr(a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, s.scrub(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, s.scrub(err.Error()))
+		}
+	}
+	return a
+}
+
+// scrub replaces credentials in text
+func (s *scrubber) scrub(text string) string {
+	text = s.secrets.Replace(text)
+	text = telegramToken.ReplaceAllString(text, "[redacted-token]")
+	text = googleAPIKey.ReplaceAllString(text, "[redacted-key]")
+	text = uriCredentials.ReplaceAllString(text, "$1:[redacted]@")
+	text = secretParams.ReplaceAllString(text, "$1[redacted]")
+	return text
+}
+
+// Scrub replaces credentials in text with placeholders, for output that
+// doesn't go through the logger
+func Scrub(text string) string {
+	return newScrubber(nil).scrub(text)
+}
+
+// ctxKey is the context key of the request fields
+type ctxKey struct{}
+
+// With returns a context whose log records carry the given fields, e.g.
+// With(ctx, "chat_id", chatID). Log with slog's *Context functions to use them.
+func With(ctx context.Context, args ...any) context.Context {
+	var attrs []slog.Attr
+	if existing, ok := ctx.Value(ctxKey{}).([]slog.Attr); ok {
+		attrs = append(attrs, existing...)
+	}
+	record := slog.Record{}
+	record.Add(args...)
+	record.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	return context.WithValue(ctx, ctxKey{}, attrs)
+}
+
+// contextHandler adds the fields stored with With to each record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(ctxKey{}).([]slog.Attr); ok {
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// BotLogger adapts slog to the logger interface of the Telegram library,
+// logging its output at debug level
+type BotLogger struct{}
+
+func (BotLogger) Println(v ...interface{}) {
+	slog.Debug(strings.TrimSuffix(fmt.Sprintln(v...), "\n"), "component", "telegram")
+}
+
+func (BotLogger) Printf(format string, v ...interface{}) {
+	slog.Debug(fmt.Sprintf(format, v...), "component", "telegram")
+}
@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric name
+const namespace = "searchbot"
+
+var (
+	// UpdatesProcessed counts handled Telegram updates by type
+	UpdatesProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "updates_processed_total",
+		Help:      "Telegram updates handled, by update type.",
+	}, []string{"type"})
+
+	// UpdateDuration measures how long handling an update takes
+	UpdateDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "update_duration_seconds",
+		Help:      "Time spent handling a Telegram update, by update type.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"type"})
+
+	// MessagesStored counts messages written to storage
+	MessagesStored = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_stored_total",
+		Help:      "Messages stored in MongoDB.",
+	})
+
+	// MessagesIndexed counts messages confirmed indexed by the search backend
+	MessagesIndexed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_indexed_total",
+		Help:      "Messages indexed in Meilisearch.",
+	})
+
+	// StorageDuration measures storage operation latency
+	StorageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "storage_operation_duration_seconds",
+		Help:      "MongoDB operation latency, by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	// StorageErrors counts failed storage operations
+	StorageErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "storage_errors_total",
+		Help:      "Failed MongoDB operations, by operation.",
+	}, []string{"operation"})
+
+	// SearchDuration measures search backend operation latency
+	SearchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "search_operation_duration_seconds",
+		Help:      "Meilisearch operation latency, by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	// SearchErrors counts failed search backend operations
+	SearchErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "search_errors_total",
+		Help:      "Failed Meilisearch operations, by operation.",
+	}, []string{"operation"})
+
+	// AIRequestDuration measures AI call latency
+	AIRequestDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ai_request_duration_seconds",
+		Help:      "Gemini request latency.",
+		Buckets:   []float64{.25, .5, 1, 2, 4, 8, 15, 30, 60},
+	})
+
+	// AIErrors counts failed AI calls
+	AIErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ai_errors_total",
+		Help:      "Failed Gemini requests.",
+	})
+
+	// AITokens counts tokens used by AI calls, by kind (prompt or response)
+	AITokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ai_tokens_total",
+		Help:      "Gemini tokens used, by kind.",
+	}, []string{"kind"})
+
+	// AIParseFailures counts AI answers that weren't the expected JSON
+	AIParseFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ai_parse_failures_total",
+		Help:      "Gemini answers that could not be parsed.",
+	})
+
+	// AskAnswers counts /ask answers by where the relevant messages came from:
+	// "ai", "keyword" (the fallback) or "none"
+	AskAnswers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ask_answers_total",
+		Help:      "/ask answers, by source of the relevant messages.",
+	}, []string{"source"})
+
+	// AskRatings counts ratings given to /ask answers: "up" or "down"
+	AskRatings = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ask_ratings_total",
+		Help:      "Ratings given to /ask answers, by rating.",
+	}, []string{"rating"})
+)
+
+// RegisterQueueDepth exports the number of updates waiting for a worker
+func RegisterQueueDepth(depth func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "worker_queue_depth",
+		Help:      "Updates waiting for a worker.",
+	}, func() float64 { return float64(depth()) })
+}
+
+// ObserveStorage records the latency and outcome of a storage operation
+func ObserveStorage(operation string, start time.Time, err error) {
+	StorageDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		StorageErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// ObserveSearch records the latency and outcome of a search backend operation
+func ObserveSearch(operation string, start time.Time, err error) {
+	SearchDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		SearchErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Ratings a user can give an answer
+const (
+	RatingUp   = 1
+	RatingDown = -1
+)
+
+// MessageRef identifies a stored message
+type MessageRef struct {
+	ChatID    int64 `bson:"chat_id" json:"chat_id"`
+	MessageID int64 `bson:"message_id" json:"message_id"`
+}
+
+// AnswerRating is one user's opinion of an answer
+type AnswerRating struct {
+	UserID  int64     `bson:"user_id" json:"user_id"`
+	Rating  int       `bson:"rating" json:"rating"` // RatingUp or RatingDown
+	RatedAt time.Time `bson:"rated_at" json:"rated_at"`
+}
+
+// Answer records an /ask answer and how it was produced, so ratings can be
+// used to tune prompts and build evaluation sets
+type Answer struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ChatID        int64              `bson:"chat_id" json:"chat_id"` // Where the question was asked
+	UserID        int64              `bson:"user_id" json:"user_id"` // Who asked
+	Question      string             `bson:"question" json:"question"`
+	Candidates    []MessageRef       `bson:"candidates" json:"candidates"` // Messages the answer was chosen from
+	Cited         []MessageRef       `bson:"cited" json:"cited"`           // Messages the answer linked to
+	Explanation   string             `bson:"explanation" json:"explanation"`
+	Source        string             `bson:"source" json:"source"` // "ai" or "keyword"
+	Model         string             `bson:"model,omitempty" json:"model,omitempty"`
+	PromptVersion string             `bson:"prompt_version,omitempty" json:"prompt_version,omitempty"` // Prompt name and hash, e.g. ask-v2@fd6baf8f
+	Ratings       []AnswerRating     `bson:"ratings,omitempty" json:"ratings,omitempty"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Score returns how many users rated the answer up and down
+func (a *Answer) Score() (up, down int) {
+	for _, r := range a.Ratings {
+		switch r.Rating {
+		case RatingUp:
+			up++
+		case RatingDown:
+			down++
+		}
+	}
+	return up, down
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Bookmark is a message a user saved for later. It refers to the stored
+// message by chat and message ID, so it always shows the latest edit.
+type Bookmark struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    int64              `bson:"user_id" json:"user_id"`
+	ChatID    int64              `bson:"chat_id" json:"chat_id"`
+	MessageID int64              `bson:"message_id" json:"message_id"`
+	Tags      []string           `bson:"tags,omitempty" json:"tags,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// HasTag reports whether the bookmark is tagged with tag
+func (b *Bookmark) HasTag(tag string) bool {
+	for _, t := range b.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
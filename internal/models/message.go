@@ -15,8 +15,21 @@ type Message struct {
 	ChatUsername string             `bson:"chat_username,omitempty" json:"chat_username"`
 	UserID       int64              `bson:"user_id" json:"user_id"`
 	Username     string             `bson:"username" json:"username"`
+	DisplayName  string             `bson:"display_name,omitempty" json:"display_name,omitempty"` // Sender's name when the @username is unknown, e.g. in imported history
 	Text         string             `bson:"text" json:"text"`
 	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	EditedAt     time.Time          `bson:"edited_at,omitempty" json:"edited_at,omitempty"`
+}
+
+// Author names the sender, as @username if it is known
+func (m *Message) Author() string {
+	if m.Username != "" {
+		return "@" + m.Username
+	}
+	if m.DisplayName != "" {
+		return m.DisplayName
+	}
+	return "unknown user"
 }
 
 // GetSearchID returns a unique ID for Meilisearch indexing
@@ -0,0 +1,86 @@
+package models
+
+import "time"
+
+// Who may use a command
+const (
+	AccessEveryone  = "everyone"
+	AccessAdmins    = "admins"
+	AccessAllowlist = "allowlist" // Admins and the listed users
+)
+
+// CommandPolicy decides who may use a command in a chat
+type CommandPolicy struct {
+	Access       string  `bson:"access" json:"access"`
+	AllowedUsers []int64 `bson:"allowed_users,omitempty" json:"allowed_users,omitempty"` // Used with AccessAllowlist
+}
+
+// defaultCommandPolicies apply to commands a chat hasn't configured.
+// Commands not listed here are open to everyone.
+var defaultCommandPolicies = map[string]CommandPolicy{
+	"ask":         {Access: AccessEveryone},
+	"search":      {Access: AccessEveryone},
+	"reindex":     {Access: AccessAdmins},
+	"settings":    {Access: AccessAdmins},
+	"permissions": {Access: AccessAdmins},
+	"usage":       {Access: AccessAdmins},
+}
+
+// ConfigurableCommands are the commands whose policy admins may change.
+// /settings, /permissions and /usage always stay admin-only.
+var ConfigurableCommands = []string{"ask", "search", "status", "reindex"}
+
+// IsConfigurableCommand reports whether admins may change a command's policy
+func IsConfigurableCommand(command string) bool {
+	for _, c := range ConfigurableCommands {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
+
+// ChatSettings holds the per-chat behaviour that admins can change with /settings
+type ChatSettings struct {
+	ChatID        int64                    `bson:"chat_id" json:"chat_id"`
+	ResultLimit   int                      `bson:"result_limit" json:"result_limit"`     // Max results shown by /search
+	ContextSize   int                      `bson:"context_size" json:"context_size"`     // Recent messages /ask looks at
+	Language      string                   `bson:"language" json:"language"`             // Answer language, empty to match the question
+	AIEnabled     bool                     `bson:"ai_enabled" json:"ai_enabled"`         // Use Gemini for /ask
+	Prompt        string                   `bson:"prompt" json:"prompt"`                 // Prompt /ask uses, empty for the deployment's default
+	RetentionDays int                      `bson:"retention_days" json:"retention_days"` // Delete older messages, 0 keeps them forever
+	Commands      map[string]CommandPolicy `bson:"commands,omitempty" json:"commands,omitempty"`
+	UpdatedAt     time.Time                `bson:"updated_at,omitempty" json:"updated_at"`
+	UpdatedBy     int64                    `bson:"updated_by,omitempty" json:"updated_by"`
+}
+
+// DefaultChatSettings returns the settings of a chat that never changed them
+func DefaultChatSettings(chatID int64) *ChatSettings {
+	return &ChatSettings{
+		ChatID:      chatID,
+		ResultLimit: 50,
+		ContextSize: 100,
+		AIEnabled:   true,
+	}
+}
+
+// CommandPolicy returns who may use a command in this chat
+func (s *ChatSettings) CommandPolicy(command string) CommandPolicy {
+	if IsConfigurableCommand(command) {
+		if policy, ok := s.Commands[command]; ok {
+			return policy
+		}
+	}
+	if policy, ok := defaultCommandPolicies[command]; ok {
+		return policy
+	}
+	return CommandPolicy{Access: AccessEveryone}
+}
+
+// SetCommandPolicy changes who may use a command in this chat
+func (s *ChatSettings) SetCommandPolicy(command string, policy CommandPolicy) {
+	if s.Commands == nil {
+		s.Commands = make(map[string]CommandPolicy)
+	}
+	s.Commands[command] = policy
+}
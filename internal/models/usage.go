@@ -0,0 +1,16 @@
+package models
+
+// AIUsage counts AI requests and the tokens they used
+type AIUsage struct {
+	Requests       int64 `bson:"requests" json:"requests"`
+	PromptTokens   int64 `bson:"prompt_tokens" json:"prompt_tokens"`
+	ResponseTokens int64 `bson:"response_tokens" json:"response_tokens"`
+	TotalTokens    int64 `bson:"total_tokens" json:"total_tokens"`
+}
+
+// DailyUsage is a chat's AI usage on one day
+type DailyUsage struct {
+	ChatID  int64  `bson:"chat_id" json:"chat_id"`
+	Day     string `bson:"day" json:"day"` // UTC date as YYYY-MM-DD
+	AIUsage `bson:",inline"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Watch is a user's saved search. New messages matching it are sent to the
+// user in a private chat.
+type Watch struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID         int64              `bson:"user_id" json:"user_id"`
+	Query          string             `bson:"query" json:"query"` // In the /search syntax
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	LastNotifiedAt time.Time          `bson:"last_notified_at,omitempty" json:"last_notified_at,omitempty"`
+}
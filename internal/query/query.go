@@ -0,0 +1,177 @@
+package query
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Syntax explains the search syntax shared by /search, inline search and /watch
+const Syntax = "Search syntax:\n" +
+	"word - messages containing the word\n" +
+	"\"exact phrase\" - messages containing the phrase\n" +
+	"-word - leave out messages containing the word\n" +
+	"from:username - only messages by this user"
+
+// Query is a parsed search query
+type Query struct {
+	Terms    []string // Words a match must contain, lowercase
+	Phrases  []string // Phrases a match must contain, lowercase
+	Excluded []string // Words a match must not contain, lowercase
+	From     string   // Username a match must be sent by, without the @
+}
+
+// Parse parses a search query. It never fails: anything that isn't a
+// phrase, an exclusion or a from: filter is searched for as a word.
+func Parse(text string) Query {
+	var q Query
+	for _, token := range tokenize(text) {
+		switch {
+		case token.quoted:
+			if phrase := strings.ToLower(strings.TrimSpace(token.text)); phrase != "" {
+				q.Phrases = append(q.Phrases, phrase)
+			}
+		case strings.HasPrefix(strings.ToLower(token.text), "from:"):
+			if from := username(token.text[len("from:"):]); from != "" {
+				q.From = from
+			}
+		case strings.HasPrefix(token.text, "-") && len(token.text) > 1:
+			q.Excluded = append(q.Excluded, Words(token.text[1:])...)
+		default:
+			words := Words(token.text)
+			if len(words) > 1 {
+				// Keep words like "docker-compose" together
+				q.Phrases = append(q.Phrases, strings.ToLower(token.text))
+			} else {
+				q.Terms = append(q.Terms, words...)
+			}
+		}
+	}
+	return q
+}
+
+// IsEmpty reports whether the query has nothing to search for
+func (q Query) IsEmpty() bool {
+	return len(q.Terms) == 0 && len(q.Phrases) == 0 && q.From == ""
+}
+
+// Text returns the words and phrases to hand to a full text search engine
+func (q Query) Text() string {
+	parts := append([]string(nil), q.Terms...)
+	for _, phrase := range q.Phrases {
+		parts = append(parts, `"`+phrase+`"`)
+	}
+	return strings.Join(parts, " ")
+}
+
+// String returns the query in the syntax Parse accepts
+func (q Query) String() string {
+	parts := []string{q.Text()}
+	for _, word := range q.Excluded {
+		parts = append(parts, "-"+word)
+	}
+	if q.From != "" {
+		parts = append(parts, "from:"+q.From)
+	}
+	return strings.TrimSpace(strings.Join(parts, " "))
+}
+
+// Matches reports whether a message sent by username matches the query.
+// Words must match whole words, ignoring case.
+func (q Query) Matches(text, username string) bool {
+	if q.IsEmpty() {
+		return false
+	}
+	if q.From != "" && !strings.EqualFold(q.From, username) {
+		return false
+	}
+
+	words := wordSet(text)
+	for _, term := range q.Terms {
+		if !words[term] {
+			return false
+		}
+	}
+	if q.Excludes(text) {
+		return false
+	}
+
+	lower := strings.ToLower(text)
+	for _, phrase := range q.Phrases {
+		if !strings.Contains(lower, phrase) {
+			return false
+		}
+	}
+	return true
+}
+
+// Excludes reports whether text contains one of the query's excluded words
+func (q Query) Excludes(text string) bool {
+	words := wordSet(text)
+	for _, word := range q.Excluded {
+		if words[word] {
+			return true
+		}
+	}
+	return false
+}
+
+// wordSet returns the set of lowercase words in text
+func wordSet(text string) map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range Words(text) {
+		words[word] = true
+	}
+	return words
+}
+
+// Words splits text into lowercase words
+func Words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+}
+
+// username cleans up a Telegram username, returning "" if it isn't one
+func username(text string) string {
+	text = strings.TrimPrefix(text, "@")
+	for _, r := range text {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return ""
+		}
+	}
+	return text
+}
+
+// token is a whitespace separated part of a query, or a quoted phrase
+type token struct {
+	text   string
+	quoted bool
+}
+
+// tokenize splits a query into tokens. An unterminated quote runs to the end.
+func tokenize(text string) []token {
+	var tokens []token
+	var current strings.Builder
+	quoted := false
+
+	flush := func() {
+		if current.Len() > 0 || quoted {
+			tokens = append(tokens, token{text: current.String(), quoted: quoted})
+		}
+		current.Reset()
+	}
+
+	for _, r := range text {
+		switch {
+		case r == '"' || r == '“' || r == '”':
+			flush()
+			quoted = !quoted
+		case unicode.IsSpace(r) && !quoted:
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
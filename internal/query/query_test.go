@@ -0,0 +1,75 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text string
+		want Query
+	}{
+		{text: "Docker build", want: Query{Terms: []string{"docker", "build"}}},
+		{text: `"Exact Phrase" word`, want: Query{Phrases: []string{"exact phrase"}, Terms: []string{"word"}}},
+		{text: "“curly quotes”", want: Query{Phrases: []string{"curly quotes"}}},
+		{text: `word "unterminated phrase`, want: Query{Terms: []string{"word"}, Phrases: []string{"unterminated phrase"}}},
+		{text: `"" "  "`, want: Query{}},
+		{text: "-spam word", want: Query{Excluded: []string{"spam"}, Terms: []string{"word"}}},
+		{text: "- word", want: Query{Terms: []string{"word"}}},
+		{text: "-docker-compose", want: Query{Excluded: []string{"docker", "compose"}}},
+		{text: "docker-compose up", want: Query{Phrases: []string{"docker-compose"}, Terms: []string{"up"}}},
+		{text: "from:@Alice deploy", want: Query{From: "Alice", Terms: []string{"deploy"}}},
+		{text: "FROM:bob", want: Query{From: "bob"}},
+		{text: "from:not-a-user", want: Query{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := Parse(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		query    string
+		text     string
+		username string
+		want     bool
+	}{
+		{query: "go", text: "Go is fun", want: true},
+		{query: "go", text: "going home", want: false},
+		{query: "go fun", text: "go is hard", want: false},
+		{query: `"is fun"`, text: "Go IS FUN", want: true},
+		{query: `"fun is"`, text: "Go is fun", want: false},
+		{query: "go -hard", text: "go is hard", want: false},
+		{query: "go -hard", text: "go is hardly fun", want: true},
+		{query: "docker-compose", text: "run Docker-Compose up", want: true},
+		{query: "docker-compose", text: "run docker compose up", want: false},
+		{query: "from:bob", text: "anything", username: "Bob", want: true},
+		{query: "from:@bob go", text: "go", username: "alice", want: false},
+		{query: "from:bob", text: "imported message", username: "", want: false},
+		{query: "", text: "anything", want: false},
+		{query: "-go", text: "anything", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query+"/"+tt.text, func(t *testing.T) {
+			if got := Parse(tt.query).Matches(tt.text, tt.username); got != tt.want {
+				t.Errorf("Parse(%q).Matches(%q, %q) = %v, want %v", tt.query, tt.text, tt.username, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	for _, text := range []string{"go build", `"exact phrase" -spam`, "docker-compose from:alice"} {
+		q := Parse(text)
+		if again := Parse(q.String()); !reflect.DeepEqual(again, q) {
+			t.Errorf("Parse(%q) = %+v after a round trip, want %+v", q.String(), again, q)
+		}
+	}
+}
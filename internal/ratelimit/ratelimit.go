@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// idleTimeout is how long an unused bucket is kept. A bucket idle this long
+// has refilled completely, so dropping it doesn't change any decision.
+const idleTimeout = 10 * time.Minute
+
+// Config sets how often a command may be used. Zero disables a limit.
+type Config struct {
+	PerUser int // Uses per minute by one user in one chat
+	PerChat int // Uses per minute by everyone in one chat
+}
+
+// bucket is a token bucket and when it was last used
+type bucket struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// userKey identifies a user in a chat
+type userKey struct {
+	chatID int64
+	userID int64
+}
+
+// Limiter applies per-user and per-chat token buckets to one command. The
+// buckets live in memory, so each replica of the bot limits on its own.
+type Limiter struct {
+	config Config
+
+	mu        sync.Mutex
+	users     map[userKey]*bucket
+	chats     map[int64]*bucket
+	lastPrune time.Time
+}
+
+// NewLimiter creates a new Limiter
+func NewLimiter(config Config) *Limiter {
+	return &Limiter{
+		config:    config,
+		users:     make(map[userKey]*bucket),
+		chats:     make(map[int64]*bucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// Allow takes a token from both the user's and the chat's bucket. If either
+// is empty nothing is taken and it returns how long to wait before retrying.
+func (l *Limiter) Allow(chatID, userID int64) (bool, time.Duration) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastPrune) > idleTimeout {
+		l.prune(now)
+	}
+
+	var reservations []*rate.Reservation
+	if l.config.PerUser > 0 {
+		user := getBucket(l.users, userKey{chatID: chatID, userID: userID}, l.config.PerUser, now)
+		reservations = append(reservations, user.limiter.ReserveN(now, 1))
+	}
+	if l.config.PerChat > 0 {
+		chat := getBucket(l.chats, chatID, l.config.PerChat, now)
+		reservations = append(reservations, chat.limiter.ReserveN(now, 1))
+	}
+
+	var wait time.Duration
+	for _, r := range reservations {
+		if delay := r.DelayFrom(now); delay > wait {
+			wait = delay
+		}
+	}
+	if wait > 0 {
+		for _, r := range reservations {
+			r.CancelAt(now)
+		}
+		return false, wait
+	}
+	return true, 0
+}
+
+// getBucket returns the bucket for key, creating a full one if needed
+func getBucket[K comparable](buckets map[K]*bucket, key K, perMinute int, now time.Time) *bucket {
+	b, ok := buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Every(time.Minute/time.Duration(perMinute)), perMinute)}
+		buckets[key] = b
+	}
+	b.lastUsed = now
+	return b
+}
+
+// prune drops buckets that haven't been used for a while
+func (l *Limiter) prune(now time.Time) {
+	for key, b := range l.users {
+		if now.Sub(b.lastUsed) > idleTimeout {
+			delete(l.users, key)
+		}
+	}
+	for key, b := range l.chats {
+		if now.Sub(b.lastUsed) > idleTimeout {
+			delete(l.chats, key)
+		}
+	}
+	l.lastPrune = now
+}
@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// take calls Allow n times and returns how many calls were allowed
+func take(l *Limiter, chatID, userID int64, n int) int {
+	allowed := 0
+	for i := 0; i < n; i++ {
+		if ok, _ := l.Allow(chatID, userID); ok {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestLimiterPerUserAndPerChat(t *testing.T) {
+	l := NewLimiter(Config{PerUser: 3, PerChat: 5})
+
+	if n := take(l, 1, 10, 5); n != 3 {
+		t.Errorf("user 10 was allowed %d uses, want 3", n)
+	}
+	// Another user has a bucket of their own but shares the chat's
+	if n := take(l, 1, 11, 5); n != 2 {
+		t.Errorf("user 11 was allowed %d uses, want 2", n)
+	}
+	// Other chats aren't affected
+	if n := take(l, 2, 10, 5); n != 3 {
+		t.Errorf("user 10 was allowed %d uses in another chat, want 3", n)
+	}
+}
+
+func TestLimiterDenialTakesNothing(t *testing.T) {
+	l := NewLimiter(Config{PerUser: 2, PerChat: 3})
+
+	// User 10 keeps trying after running out, which must not drain the chat
+	if n := take(l, 1, 10, 10); n != 2 {
+		t.Errorf("user 10 was allowed %d uses, want 2", n)
+	}
+	if n := take(l, 1, 11, 10); n != 1 {
+		t.Errorf("user 11 was allowed %d uses, want 1", n)
+	}
+}
+
+func TestLimiterRefills(t *testing.T) {
+	// A token every 50ms, so the test doesn't wait long
+	l := NewLimiter(Config{PerUser: 1200})
+
+	// Tokens come back while the bucket is emptied, so count on a few more
+	var wait time.Duration
+	for i := 0; ; i++ {
+		ok, w := l.Allow(1, 10)
+		if !ok {
+			if i < 1200 {
+				t.Fatalf("use denied after %d uses, want at least 1200", i)
+			}
+			wait = w
+			break
+		}
+	}
+	if wait <= 0 || wait > 50*time.Millisecond {
+		t.Fatalf("wait is %s, want at most 50ms", wait)
+	}
+
+	time.Sleep(wait)
+	if ok, wait := l.Allow(1, 10); !ok {
+		t.Errorf("use denied after the bucket refilled, wait %s", wait)
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	l := NewLimiter(Config{})
+	if n := take(l, 1, 10, 100); n != 100 {
+		t.Errorf("%d uses allowed without limits, want 100", n)
+	}
+}
@@ -0,0 +1,226 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"SearchBot/internal/models"
+	"SearchBot/internal/search"
+	"SearchBot/internal/storage"
+	"SearchBot/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// batchSize is how many documents are sent to Meilisearch at once
+const batchSize = 500
+
+// taskTimeout is how long to wait for Meilisearch to apply a batch
+const taskTimeout = time.Minute
+
+// Report summarizes the reconciliation of one chat
+type Report struct {
+	ChatID       int64
+	StoredCount  int64
+	IndexedCount int64
+	Reindexed    int
+	Removed      int
+	Skipped      bool // Counts matched so the message IDs weren't compared
+}
+
+// String returns a human readable summary of the report
+func (r Report) String() string {
+	if r.Skipped {
+		return fmt.Sprintf("chat %d: in sync (%d messages)", r.ChatID, r.StoredCount)
+	}
+	return fmt.Sprintf("chat %d: %d stored, %d indexed, %d re-indexed, %d orphans removed",
+		r.ChatID, r.StoredCount, r.IndexedCount, r.Reindexed, r.Removed)
+}
+
+// Reconciler brings the search index back in sync with MongoDB, which is
+// the source of truth for stored messages
+type Reconciler struct {
+	storage storage.MessageStorage
+	search  *search.MeiliSearch
+}
+
+// NewReconciler creates a new Reconciler instance
+func NewReconciler(storage storage.MessageStorage, search *search.MeiliSearch) *Reconciler {
+	return &Reconciler{
+		storage: storage,
+		search:  search,
+	}
+}
+
+// ReconcileChat compares a chat's stored and indexed messages, re-indexes
+// missing documents and removes orphans from the index. Unless full is set
+// the message IDs are only compared when the counts differ.
+func (r *Reconciler) ReconcileChat(ctx context.Context, chatID int64, full bool) (_ Report, err error) {
+	ctx, span := tracing.Start(ctx, "reconcile.chat",
+		attribute.Int64("chat_id", chatID),
+		attribute.Bool("full", full))
+	defer func() { tracing.End(span, err) }()
+
+	report := Report{ChatID: chatID}
+
+	storedCount, err := r.storage.CountMessages(ctx, chatID)
+	if err != nil {
+		return report, fmt.Errorf("failed to count stored messages: %v", err)
+	}
+	indexedCount, err := r.search.CountMessages(ctx, chatID)
+	if err != nil {
+		return report, fmt.Errorf("failed to count indexed messages: %v", err)
+	}
+	report.StoredCount = storedCount
+	report.IndexedCount = indexedCount
+
+	if !full && storedCount == indexedCount {
+		report.Skipped = true
+		return report, nil
+	}
+
+	indexedIDs, err := r.search.GetMessageIDs(ctx, chatID)
+	if err != nil {
+		return report, fmt.Errorf("failed to fetch indexed message IDs: %v", err)
+	}
+
+	indexed := make(map[int64]bool, len(indexedIDs))
+	for _, messageID := range indexedIDs {
+		indexed[messageID] = true
+	}
+
+	// Read the stored messages page by page and re-index those missing from
+	// the index, so only their IDs are kept in memory. Pages rather than one
+	// stream, as waiting for Meilisearch could outlast an idle MongoDB cursor.
+	storedIDs := make(map[int64]bool, storedCount)
+	query := storage.PageQuery{ChatID: chatID, Limit: batchSize, Direction: storage.Forward}
+	for {
+		page, err := r.storage.GetMessagesPage(ctx, query)
+		if err != nil {
+			return report, fmt.Errorf("failed to read stored messages: %v", err)
+		}
+
+		var missing []models.Message
+		for _, msg := range page.Messages {
+			storedIDs[msg.MessageID] = true
+			if !indexed[msg.MessageID] {
+				missing = append(missing, msg)
+			}
+		}
+		if len(missing) > 0 {
+			taskUID, err := r.search.IndexMessages(ctx, chatID, missing)
+			if err != nil {
+				return report, fmt.Errorf("failed to re-index messages: %v", err)
+			}
+			if err := r.waitForTask(ctx, taskUID); err != nil {
+				return report, fmt.Errorf("failed to re-index messages: %v", err)
+			}
+			report.Reindexed += len(missing)
+		}
+
+		if !page.More {
+			break
+		}
+		query.After = page.Next
+	}
+
+	// Documents in the index without a stored message
+	var orphans []int64
+	for _, messageID := range indexedIDs {
+		if !storedIDs[messageID] {
+			orphans = append(orphans, messageID)
+		}
+	}
+
+	for start := 0; start < len(orphans); start += batchSize {
+		batch := orphans[start:min(start+batchSize, len(orphans))]
+		taskUID, err := r.search.DeleteMessages(ctx, chatID, batch)
+		if err != nil {
+			return report, fmt.Errorf("failed to remove orphaned documents: %v", err)
+		}
+		if err := r.waitForTask(ctx, taskUID); err != nil {
+			return report, fmt.Errorf("failed to remove orphaned documents: %v", err)
+		}
+		report.Removed += len(batch)
+	}
+
+	return report, nil
+}
+
+// waitForTask waits for Meilisearch to apply a batch, for at most taskTimeout
+func (r *Reconciler) waitForTask(ctx context.Context, taskUID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, taskTimeout)
+	defer cancel()
+	return r.search.WaitForTask(ctx, taskUID)
+}
+
+// ReconcileAll reconciles every chat with stored messages. It first moves
+// messages that older versions still running during a rolling upgrade wrote
+// to per-group collections, so they are stored and indexed like the rest.
+func (r *Reconciler) ReconcileAll(ctx context.Context, full bool) ([]Report, error) {
+	moved, err := r.storage.MoveGroupCollections(ctx)
+	if err != nil {
+		slog.Error("Failed to move group collections", "error", err)
+	} else if moved > 0 {
+		slog.Warn("Moved messages written by an older version", "messages", moved)
+	}
+
+	chatIDs, err := r.storage.GetChatIDs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chats: %v", err)
+	}
+	sort.Slice(chatIDs, func(i, j int) bool { return chatIDs[i] < chatIDs[j] })
+
+	var reports []Report
+	var failed int
+	for _, chatID := range chatIDs {
+		report, err := r.ReconcileChat(ctx, chatID, full)
+		if ctx.Err() != nil {
+			return reports, fmt.Errorf("reconciliation interrupted: %v", ctx.Err())
+		}
+		if err != nil {
+			slog.Error("Failed to reconcile chat", "chat_id", chatID, "error", err)
+			failed++
+			continue
+		}
+		reports = append(reports, report)
+	}
+
+	if failed > 0 {
+		return reports, fmt.Errorf("failed to reconcile %d of %d chats", failed, len(chatIDs))
+	}
+	return reports, nil
+}
+
+// RunPeriodically reconciles all chats every interval until ctx is done
+func (r *Reconciler) RunPeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.runOnce(ctx)
+		}
+	}
+}
+
+// runOnce reconciles all chats as one trace and logs what changed
+func (r *Reconciler) runOnce(ctx context.Context) {
+	ctx, span := tracing.Start(ctx, "reconcile.periodic")
+	reports, err := r.ReconcileAll(ctx, false)
+	tracing.End(span, err)
+	if err != nil {
+		slog.ErrorContext(ctx, "Periodic reconciliation failed", "error", err)
+	}
+	for _, report := range reports {
+		if !report.Skipped {
+			slog.InfoContext(ctx, "Reconciled chat", "chat_id", report.ChatID, "report", report.String())
+		}
+	}
+}
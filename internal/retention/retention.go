@@ -0,0 +1,119 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"SearchBot/internal/search"
+	"SearchBot/internal/storage"
+	"SearchBot/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// chatTimeout is how long deleting one chat's expired messages may take
+const chatTimeout = time.Minute
+
+// Enforcer deletes messages, and the /ask answers quoting them, that are
+// older than their chat's retention period
+type Enforcer struct {
+	storage  storage.MessageStorage
+	settings storage.SettingsStorage
+	answers  storage.AnswerStorage
+	search   *search.MeiliSearch
+}
+
+// NewEnforcer creates a new Enforcer instance
+func NewEnforcer(storage storage.MessageStorage, settings storage.SettingsStorage, answers storage.AnswerStorage, search *search.MeiliSearch) *Enforcer {
+	return &Enforcer{
+		storage:  storage,
+		settings: settings,
+		answers:  answers,
+		search:   search,
+	}
+}
+
+// EnforceAll applies the retention period of every chat that has one
+func (e *Enforcer) EnforceAll(ctx context.Context) error {
+	allSettings, err := e.settings.ListChatSettings(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list chat settings: %v", err)
+	}
+
+	var failed int
+	for _, settings := range allSettings {
+		if settings.RetentionDays <= 0 {
+			continue
+		}
+
+		cutoff := time.Now().AddDate(0, 0, -settings.RetentionDays)
+		if err := e.enforceChat(ctx, settings.ChatID, cutoff); err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("retention interrupted: %v", ctx.Err())
+			}
+			slog.Error("Failed to apply retention", "chat_id", settings.ChatID, "error", err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to apply retention to %d chats", failed)
+	}
+	return nil
+}
+
+// enforceChat deletes a chat's messages older than cutoff from both stores,
+// and the answers asked in the chat or chosen from its messages before then
+func (e *Enforcer) enforceChat(ctx context.Context, chatID int64, cutoff time.Time) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, chatTimeout)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "retention.chat", attribute.Int64("chat_id", chatID))
+	defer func() { tracing.End(span, err) }()
+
+	// Remove from the index first so search never returns deleted messages
+	if err := e.search.DeleteMessagesBefore(ctx, chatID, cutoff); err != nil {
+		return err
+	}
+
+	deleted, err := e.storage.DeleteMessagesBefore(ctx, chatID, cutoff)
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		slog.Info("Deleted expired messages", "chat_id", chatID, "deleted", deleted, "before", cutoff.Format(time.DateOnly))
+	}
+
+	// Answers keep the question, the explanation and links to the messages
+	deleted, err = e.answers.DeleteAnswersBefore(ctx, chatID, cutoff)
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		slog.Info("Deleted expired answers", "chat_id", chatID, "deleted", deleted, "before", cutoff.Format(time.DateOnly))
+	}
+	return nil
+}
+
+// RunPeriodically applies retention every interval until ctx is done
+func (e *Enforcer) RunPeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			runCtx, span := tracing.Start(ctx, "retention.periodic")
+			err := e.EnforceAll(runCtx)
+			tracing.End(span, err)
+			if err != nil {
+				slog.ErrorContext(runCtx, "Periodic retention failed", "error", err)
+			}
+		}
+	}
+}
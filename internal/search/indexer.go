@@ -0,0 +1,276 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"SearchBot/internal/metrics"
+	"SearchBot/internal/models"
+	"SearchBot/internal/storage"
+	"SearchBot/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// IndexerConfig controls how the indexer batches messages
+type IndexerConfig struct {
+	BatchSize     int           // Flush a chat once this many messages are buffered
+	FlushInterval time.Duration // Flush all chats at least this often
+	TaskTimeout   time.Duration // How long indexing one batch, including Meilisearch's confirmation, may take
+	ReplayLimit   int64         // How many queued messages to reload at once
+}
+
+// DefaultIndexerConfig returns the default batching settings
+func DefaultIndexerConfig() IndexerConfig {
+	return IndexerConfig{
+		BatchSize:     100,
+		FlushInterval: 2 * time.Second,
+		TaskTimeout:   30 * time.Second,
+		ReplayLimit:   1000,
+	}
+}
+
+// Indexer buffers messages per chat and indexes them in batches in the
+// background. Every message is persisted in a durable queue before it is
+// buffered and only removed once Meilisearch confirms it was indexed, so
+// nothing is lost while the search backend is unavailable.
+type Indexer struct {
+	search *MeiliSearch
+	queue  storage.IndexQueue
+	config IndexerConfig
+
+	mu      sync.Mutex
+	buffers map[int64][]models.Message
+	replay  bool // Reload the durable queue before the next flush
+
+	flushCh chan int64
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+}
+
+// NewIndexer creates an indexer and starts its background flush loop
+func NewIndexer(search *MeiliSearch, queue storage.IndexQueue, config IndexerConfig) *Indexer {
+	defaults := DefaultIndexerConfig()
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaults.BatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaults.FlushInterval
+	}
+	if config.TaskTimeout <= 0 {
+		config.TaskTimeout = defaults.TaskTimeout
+	}
+	if config.ReplayLimit <= 0 {
+		config.ReplayLimit = defaults.ReplayLimit
+	}
+
+	idx := &Indexer{
+		search:  search,
+		queue:   queue,
+		config:  config,
+		buffers: make(map[int64][]models.Message),
+		replay:  true, // Pick up anything left over from a previous run
+		flushCh: make(chan int64, 64),
+		stopCh:  make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+
+	go idx.run()
+	return idx
+}
+
+// Add queues a message for indexing
+func (idx *Indexer) Add(ctx context.Context, msg *models.Message) error {
+	return idx.AddAll(ctx, []models.Message{*msg})
+}
+
+// AddAll queues messages for indexing
+func (idx *Indexer) AddAll(ctx context.Context, msgs []models.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	// Persist first so the messages survive a crash or a backend outage
+	if err := idx.queue.EnqueueForIndexing(ctx, msgs); err != nil {
+		return fmt.Errorf("failed to enqueue messages: %v", err)
+	}
+
+	var full []int64
+	idx.mu.Lock()
+	for _, msg := range msgs {
+		idx.buffers[msg.ChatID] = append(idx.buffers[msg.ChatID], msg)
+	}
+	for chatID, buffer := range idx.buffers {
+		if len(buffer) >= idx.config.BatchSize {
+			full = append(full, chatID)
+		}
+	}
+	idx.mu.Unlock()
+
+	for _, chatID := range full {
+		// Don't block the caller if a flush is already pending
+		select {
+		case idx.flushCh <- chatID:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Close stops the background loop and flushes all buffered messages. If ctx
+// expires before the flush completes, the remaining messages stay in the
+// durable queue and are indexed on the next start.
+func (idx *Indexer) Close(ctx context.Context) error {
+	close(idx.stopCh)
+	<-idx.doneCh
+
+	failed := idx.flushAll(ctx)
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("flush interrupted, unflushed messages remain queued: %v", err)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d chats could not be flushed and remain queued", failed)
+	}
+	return nil
+}
+
+// run flushes chats when their buffer is full or the flush interval elapses
+func (idx *Indexer) run() {
+	defer close(idx.doneCh)
+
+	// Each batch gets its own deadline, see indexBatch
+	ctx := context.Background()
+
+	ticker := time.NewTicker(idx.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-idx.stopCh:
+			return
+		case chatID := <-idx.flushCh:
+			idx.flushChat(ctx, chatID)
+		case <-ticker.C:
+			idx.replayQueue(ctx)
+			idx.flushAll(ctx)
+		}
+	}
+}
+
+// replayQueue reloads queued messages into the buffers after a failure
+func (idx *Indexer) replayQueue(ctx context.Context) {
+	idx.mu.Lock()
+	replay := idx.replay
+	idx.mu.Unlock()
+	if !replay {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, idx.config.TaskTimeout)
+	defer cancel()
+
+	pending, err := idx.queue.PendingForIndexing(ctx, idx.config.ReplayLimit)
+	if err != nil {
+		slog.Error("Failed to load index queue", "error", err)
+		return
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	// Merge into the buffers, skipping messages that are already buffered
+	buffered := make(map[string]bool)
+	for _, msgs := range idx.buffers {
+		for _, msg := range msgs {
+			buffered[msg.GetSearchID()] = true
+		}
+	}
+	for _, msg := range pending {
+		if !buffered[msg.GetSearchID()] {
+			idx.buffers[msg.ChatID] = append(idx.buffers[msg.ChatID], msg)
+		}
+	}
+	// Keep replaying until the queue has been drained
+	idx.replay = int64(len(pending)) == idx.config.ReplayLimit
+
+	if len(pending) > 0 {
+		slog.Info("Replaying queued messages for indexing", "count", len(pending))
+	}
+}
+
+// flushAll flushes every chat with buffered messages and returns how many failed
+func (idx *Indexer) flushAll(ctx context.Context) int {
+	idx.mu.Lock()
+	chatIDs := make([]int64, 0, len(idx.buffers))
+	for chatID := range idx.buffers {
+		chatIDs = append(chatIDs, chatID)
+	}
+	idx.mu.Unlock()
+
+	failed := 0
+	for _, chatID := range chatIDs {
+		if err := idx.flushChat(ctx, chatID); err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+// flushChat indexes a chat's buffered messages in batches
+func (idx *Indexer) flushChat(ctx context.Context, chatID int64) error {
+	idx.mu.Lock()
+	batch := idx.buffers[chatID]
+	delete(idx.buffers, chatID)
+	idx.mu.Unlock()
+
+	for len(batch) > 0 {
+		n := min(len(batch), idx.config.BatchSize)
+		if err := idx.indexBatch(ctx, chatID, batch[:n]); err != nil {
+			slog.Error("Failed to index messages, keeping them queued", "chat_id", chatID, "count", len(batch), "error", err)
+
+			// The messages are still in the durable queue, reload them later
+			idx.mu.Lock()
+			idx.replay = true
+			idx.mu.Unlock()
+			return err
+		}
+		batch = batch[n:]
+	}
+
+	return nil
+}
+
+// indexBatch sends one batch to Meilisearch, waits for the task to succeed
+// and then removes the batch from the durable queue
+func (idx *Indexer) indexBatch(ctx context.Context, chatID int64, batch []models.Message) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, idx.config.TaskTimeout)
+	defer cancel()
+
+	// Batches mix messages from many updates, so each gets its own trace
+	ctx, span := tracing.Start(ctx, "indexer.index_batch",
+		attribute.Int64("chat_id", chatID),
+		attribute.Int("messages", len(batch)))
+	defer func() { tracing.End(span, err) }()
+
+	taskUID, err := idx.search.IndexMessages(ctx, chatID, batch)
+	if err != nil {
+		return err
+	}
+
+	if err := idx.search.WaitForTask(ctx, taskUID); err != nil {
+		return err
+	}
+	metrics.MessagesIndexed.Add(float64(len(batch)))
+
+	// Indexing succeeded, a failed ack only means the batch is indexed again later
+	if err := idx.queue.AckIndexed(ctx, chatID, batch); err != nil {
+		slog.Warn("Failed to ack indexed messages", "chat_id", chatID, "error", err)
+	}
+
+	slog.Debug("Indexed messages", "chat_id", chatID, "count", len(batch), "task_uid", taskUID)
+	return nil
+}
@@ -1,23 +1,43 @@
 package search
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"SearchBot/internal/metrics"
 	"SearchBot/internal/models"
+	"SearchBot/internal/query"
+	"SearchBot/internal/tracing"
 
 	"github.com/meilisearch/meilisearch-go"
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // MeiliSearch handles search functionality using Meilisearch
 type MeiliSearch struct {
 	client        *meilisearch.Client
+	httpClient    *fasthttp.Client
 	baseIndexName string
 	maxRetries    int
 	retryDelay    time.Duration
+
+	// indexes tracks which indexes already have their settings applied
+	indexes   map[string]*indexState
+	indexesMu sync.Mutex
+}
+
+// indexState tracks whether an index's settings have been applied
+type indexState struct {
+	lock       chan struct{} // Held while the settings are being applied
+	configured atomic.Bool
 }
 
 type SearchStrategy struct {
@@ -28,27 +48,92 @@ type SearchStrategy struct {
 
 // NewMeiliSearch creates a new MeiliSearch instance
 func NewMeiliSearch(host, apiKey, baseIndexName string) *MeiliSearch {
-	client := meilisearch.NewClient(meilisearch.ClientConfig{
+	// Keep our own HTTP client so its connections can be closed on shutdown
+	httpClient := &fasthttp.Client{
+		Name: "meilisearch-client",
+		// Reuse the most recently-used idle connection.
+		ConnPoolStrategy: fasthttp.LIFO,
+	}
+	client := meilisearch.NewFastHTTPCustomClient(meilisearch.ClientConfig{
 		Host:    host,
 		APIKey:  apiKey,
 		Timeout: 10 * time.Second, // Add timeout
-	})
+	}, httpClient)
 
 	return &MeiliSearch{
 		client:        client,
+		httpClient:    httpClient,
 		baseIndexName: baseIndexName,
 		maxRetries:    3,               // Maximum number of retries
 		retryDelay:    2 * time.Second, // Delay between retries
+		indexes:       make(map[string]*indexState),
 	}
 }
 
-// withRetry executes an operation with retry logic
-func (m *MeiliSearch) withRetry(operation string, fn func() error) error {
+// Close releases the idle connections held by the Meilisearch client
+func (m *MeiliSearch) Close() {
+	m.httpClient.CloseIdleConnections()
+}
+
+// Healthy returns an error unless Meilisearch reports itself available
+func (m *MeiliSearch) Healthy(ctx context.Context) error {
+	health, err := await(ctx, m.client.Health)
+	if err != nil {
+		return fmt.Errorf("failed to get Meilisearch health: %v", err)
+	}
+	if health.Status != "available" {
+		return fmt.Errorf("Meilisearch is %s", health.Status)
+	}
+	return nil
+}
+
+// observe starts a span for a Meilisearch operation and returns a function
+// that ends it and records the operation's latency and outcome
+func observe(ctx context.Context, operation string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "meilisearch."+operation,
+		attribute.String("db.system", "meilisearch"),
+		attribute.String("db.operation", operation))
+	return ctx, func(err error) {
+		metrics.ObserveSearch(operation, start, err)
+		tracing.End(span, err)
+	}
+}
+
+// await runs a Meilisearch request and returns its result, or ctx's error if
+// ctx is done first. The client can't cancel requests, so an abandoned one
+// still runs to completion (bounded by the client timeout) in the background.
+func await[T any](ctx context.Context, request func() (T, error)) (T, error) {
+	type result struct {
+		value T
+		err   error
+	}
+	results := make(chan result, 1)
+	go func() {
+		value, err := request()
+		results <- result{value, err}
+	}()
+
+	select {
+	case r := <-results:
+		return r.value, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// withRetry executes an operation with retry logic, giving up when ctx is done
+func (m *MeiliSearch) withRetry(ctx context.Context, operation string, fn func() error) error {
 	var lastErr error
 	for i := 0; i <= m.maxRetries; i++ {
 		if i > 0 {
-			log.Printf("Retrying %s (attempt %d/%d) after error: %v", operation, i, m.maxRetries, lastErr)
-			time.Sleep(m.retryDelay * time.Duration(i)) // Exponential backoff
+			slog.WarnContext(ctx, "Retrying Meilisearch operation", "operation", operation, "attempt", i, "max_retries", m.maxRetries, "error", lastErr)
+			select {
+			case <-time.After(m.retryDelay * time.Duration(i)): // Exponential backoff
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 		}
 
 		if err := fn(); err != nil {
@@ -62,11 +147,12 @@ func (m *MeiliSearch) withRetry(operation string, fn func() error) error {
 
 // getGroupIndex gets or creates an index for a specific group
 func (m *MeiliSearch) getGroupIndex(chatID int64) string {
-	return fmt.Sprintf("messages_group_%d", chatID)
+	return fmt.Sprintf("%s_group_%d", m.baseIndexName, chatID)
 }
 
-// configureIndex configures the settings for an index
-func (m *MeiliSearch) configureIndex(indexName string) error {
+// configureIndex applies the settings of an index, creating it if needed,
+// and waits until Meilisearch has applied them
+func (m *MeiliSearch) configureIndex(ctx context.Context, indexName string) error {
 	index := m.client.Index(indexName)
 
 	// Configure index settings
@@ -74,6 +160,7 @@ func (m *MeiliSearch) configureIndex(indexName string) error {
 		SearchableAttributes: []string{
 			"text",
 			"username",
+			"display_name",
 		},
 		FilterableAttributes: []string{
 			"chat_id",
@@ -89,60 +176,307 @@ func (m *MeiliSearch) configureIndex(indexName string) error {
 	}
 
 	// Update index settings
-	_, err := index.UpdateSettings(settings)
+	task, err := await(ctx, func() (*meilisearch.TaskInfo, error) {
+		return index.UpdateSettings(settings)
+	})
 	if err != nil {
 		return fmt.Errorf("failed to update index settings: %v", err)
 	}
 
+	// Filters on the new attributes fail until the settings are applied
+	if err := m.WaitForTask(ctx, task.TaskUID); err != nil {
+		return fmt.Errorf("failed to apply index settings: %v", err)
+	}
+
 	return nil
 }
 
+// indexState returns the state of an index, adding it on first use
+func (m *MeiliSearch) indexState(indexName string) *indexState {
+	m.indexesMu.Lock()
+	defer m.indexesMu.Unlock()
+
+	state, ok := m.indexes[indexName]
+	if !ok {
+		state = &indexState{lock: make(chan struct{}, 1)}
+		m.indexes[indexName] = state
+	}
+	return state
+}
+
+// ensureIndex configures an index once and remembers that it was done.
+// Callers using the same index wait for its settings to be applied, other
+// indexes are configured concurrently.
+func (m *MeiliSearch) ensureIndex(ctx context.Context, indexName string) error {
+	state := m.indexState(indexName)
+	if state.configured.Load() {
+		return nil
+	}
+
+	select {
+	case state.lock <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-state.lock }()
+
+	if state.configured.Load() {
+		return nil
+	}
+	if err := m.configureIndex(ctx, indexName); err != nil {
+		return err
+	}
+
+	state.configured.Store(true)
+	return nil
+}
+
+// existingIndex reports whether an index exists and makes sure its settings
+// are applied if it does, so reads don't create indexes for chats that were
+// never indexed
+func (m *MeiliSearch) existingIndex(ctx context.Context, indexName string) (bool, error) {
+	if m.indexState(indexName).configured.Load() {
+		return true, nil
+	}
+
+	_, err := await(ctx, func() (*meilisearch.Index, error) {
+		return m.client.GetIndex(indexName)
+	})
+	if err != nil {
+		if isIndexNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get index: %v", err)
+	}
+
+	if err := m.ensureIndex(ctx, indexName); err != nil {
+		return false, fmt.Errorf("failed to configure index: %v", err)
+	}
+	return true, nil
+}
+
+// messageDocument converts a message into a Meilisearch document
+func messageDocument(msg *models.Message) map[string]interface{} {
+	return map[string]interface{}{
+		// Unique ID for the message that includes both chat ID and message ID
+		"message_uid":  msg.GetSearchID(),
+		"message_id":   msg.MessageID,
+		"chat_id":      msg.ChatID,
+		"user_id":      msg.UserID,
+		"username":     msg.Username,
+		"display_name": msg.DisplayName,
+		"text":         msg.Text,
+		"created_at":   msg.CreatedAt.Unix(), // Store as Unix timestamp for sorting
+	}
+}
+
 // IndexMessage indexes a message in Meilisearch
-func (m *MeiliSearch) IndexMessage(msg *models.Message) error {
+func (m *MeiliSearch) IndexMessage(ctx context.Context, msg *models.Message) error {
+	_, err := m.IndexMessages(ctx, msg.ChatID, []models.Message{*msg})
+	return err
+}
+
+// IndexMessages adds a batch of messages from one chat to its index and
+// returns the UID of the Meilisearch task processing them
+func (m *MeiliSearch) IndexMessages(ctx context.Context, chatID int64, msgs []models.Message) (_ int64, err error) {
+	ctx, done := observe(ctx, "index_messages")
+	defer func() { done(err) }()
+
 	// Get the index for this group
-	indexName := m.getGroupIndex(msg.ChatID)
+	indexName := m.getGroupIndex(chatID)
 	index := m.client.Index(indexName)
 
 	// Configure index settings first
-	if err := m.configureIndex(indexName); err != nil {
-		return fmt.Errorf("failed to configure index: %v", err)
+	if err := m.ensureIndex(ctx, indexName); err != nil {
+		return 0, fmt.Errorf("failed to configure index: %v", err)
 	}
 
-	// Create a unique ID for the message that includes both chat ID and message ID
-	messageUID := fmt.Sprintf("%d-%d", msg.ChatID, msg.MessageID)
+	documents := make([]map[string]interface{}, 0, len(msgs))
+	for i := range msgs {
+		documents = append(documents, messageDocument(&msgs[i]))
+	}
 
-	// Create document to index
-	document := map[string]interface{}{
-		"message_uid": messageUID,
-		"message_id":  msg.MessageID,
-		"chat_id":     msg.ChatID,
-		"user_id":     msg.UserID,
-		"username":    msg.Username,
-		"text":        msg.Text,
-		"created_at":  msg.CreatedAt.Unix(), // Store as Unix timestamp for sorting
+	// Add documents to index
+	task, err := await(ctx, func() (*meilisearch.TaskInfo, error) {
+		return index.AddDocuments(documents, "message_uid")
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to add documents: %v", err)
 	}
 
-	// Add document to index
-	_, err := index.AddDocuments([]map[string]interface{}{document})
+	return task.TaskUID, nil
+}
+
+// WaitForTask waits until Meilisearch has finished processing a task or ctx
+// is done, and returns an error if the task did not succeed
+func (m *MeiliSearch) WaitForTask(ctx context.Context, taskUID int64) (err error) {
+	ctx, done := observe(ctx, "wait_for_task")
+	defer func() { done(err) }()
+
+	task, err := m.client.WaitForTask(taskUID, meilisearch.WaitParams{
+		Context:  ctx,
+		Interval: 100 * time.Millisecond,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to add document: %v", err)
+		return fmt.Errorf("failed to wait for task %d: %v", taskUID, err)
+	}
+
+	if task.Status != meilisearch.TaskStatusSucceeded {
+		return fmt.Errorf("task %d %s: %s", taskUID, task.Status, task.Error.Message)
 	}
 
 	return nil
 }
 
-// SearchMessages searches for messages in a group's index
-func (m *MeiliSearch) SearchMessages(chatID int64, searchReq *meilisearch.SearchRequest) ([]models.Message, error) {
+// isIndexNotFound reports whether err means the index doesn't exist yet
+func isIndexNotFound(err error) bool {
+	var apiErr *meilisearch.Error
+	return errors.As(err, &apiErr) && apiErr.MeilisearchApiError.Code == "index_not_found"
+}
+
+// CountMessages returns the number of documents in a group's index
+func (m *MeiliSearch) CountMessages(ctx context.Context, chatID int64) (_ int64, err error) {
+	ctx, done := observe(ctx, "count_messages")
+	defer func() { done(err) }()
+
+	index := m.client.Index(m.getGroupIndex(chatID))
+
+	stats, err := await(ctx, index.GetStats)
+	if err != nil {
+		if isIndexNotFound(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to get index stats: %v", err)
+	}
+
+	return stats.NumberOfDocuments, nil
+}
+
+// GetMessageIDs returns the IDs of all messages in a group's index
+func (m *MeiliSearch) GetMessageIDs(ctx context.Context, chatID int64) (_ []int64, err error) {
+	ctx, done := observe(ctx, "get_message_ids")
+	defer func() { done(err) }()
+
+	index := m.client.Index(m.getGroupIndex(chatID))
+
+	const pageSize = 1000
+	var messageIDs []int64
+	for offset := int64(0); ; offset += pageSize {
+		var page meilisearch.DocumentsResult
+		_, err := await(ctx, func() (struct{}, error) {
+			return struct{}{}, index.GetDocuments(&meilisearch.DocumentsQuery{
+				Offset: offset,
+				Limit:  pageSize,
+				Fields: []string{"message_id"},
+			}, &page)
+		})
+		if err != nil {
+			if isIndexNotFound(err) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("failed to fetch documents: %v", err)
+		}
+
+		for _, doc := range page.Results {
+			if messageID, ok := doc["message_id"].(float64); ok {
+				messageIDs = append(messageIDs, int64(messageID))
+			}
+		}
+
+		if int64(len(page.Results)) < pageSize {
+			return messageIDs, nil
+		}
+	}
+}
+
+// DeleteMessages removes messages from a group's index and returns the UID
+// of the Meilisearch task processing the deletion
+func (m *MeiliSearch) DeleteMessages(ctx context.Context, chatID int64, messageIDs []int64) (_ int64, err error) {
+	ctx, done := observe(ctx, "delete_messages")
+	defer func() { done(err) }()
+
+	index := m.client.Index(m.getGroupIndex(chatID))
+
+	uids := make([]string, 0, len(messageIDs))
+	for _, messageID := range messageIDs {
+		uids = append(uids, (&models.Message{ChatID: chatID, MessageID: messageID}).GetSearchID())
+	}
+
+	task, err := await(ctx, func() (*meilisearch.TaskInfo, error) {
+		return index.DeleteDocuments(uids)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete documents: %v", err)
+	}
+
+	return task.TaskUID, nil
+}
+
+// DeleteMessagesBefore removes a group's messages created before a given
+// time and waits until Meilisearch has applied the deletion
+func (m *MeiliSearch) DeleteMessagesBefore(ctx context.Context, chatID int64, before time.Time) (err error) {
+	ctx, done := observe(ctx, "delete_messages_before")
+	defer func() { done(err) }()
+
+	// The filter needs the index's settings
 	indexName := m.getGroupIndex(chatID)
+	exists, err := m.existingIndex(ctx, indexName)
+	if err != nil || !exists {
+		return err
+	}
 
-	// Configure index settings
-	if err := m.configureIndex(indexName); err != nil {
-		return nil, fmt.Errorf("failed to configure index: %v", err)
+	index := m.client.Index(indexName)
+	task, err := await(ctx, func() (*meilisearch.TaskInfo, error) {
+		return index.DeleteDocumentsByFilter(fmt.Sprintf("created_at < %d", before.Unix()))
+	})
+	if err != nil {
+		if isIndexNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete documents: %v", err)
+	}
+
+	return m.WaitForTask(ctx, task.TaskUID)
+}
+
+// DeleteIndex deletes a group's index with all its documents
+func (m *MeiliSearch) DeleteIndex(ctx context.Context, chatID int64) (err error) {
+	ctx, done := observe(ctx, "delete_index")
+	defer func() { done(err) }()
+
+	indexName := m.getGroupIndex(chatID)
+	m.indexState(indexName).configured.Store(false)
+
+	task, err := await(ctx, func() (*meilisearch.TaskInfo, error) {
+		return m.client.DeleteIndex(indexName)
+	})
+	if err != nil {
+		if isIndexNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete index: %v", err)
+	}
+
+	return m.WaitForTask(ctx, task.TaskUID)
+}
+
+// SearchMessages searches for messages in a group's index
+func (m *MeiliSearch) SearchMessages(ctx context.Context, chatID int64, searchReq *meilisearch.SearchRequest) (_ []models.Message, err error) {
+	ctx, done := observe(ctx, "search_messages")
+	defer func() { done(err) }()
+
+	// Chats that were never indexed have nothing to find
+	indexName := m.getGroupIndex(chatID)
+	exists, err := m.existingIndex(ctx, indexName)
+	if err != nil || !exists {
+		return nil, err
 	}
 
 	// Perform search
 	index := m.client.Index(indexName)
-	searchRes, err := index.Search("", searchReq)
+	searchRes, err := await(ctx, func() (*meilisearch.SearchResponse, error) {
+		return index.Search("", searchReq)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("search failed: %v", err)
 	}
@@ -150,34 +484,127 @@ func (m *MeiliSearch) SearchMessages(chatID int64, searchReq *meilisearch.Search
 	// Convert hits to messages
 	var messages []models.Message
 	for _, hit := range searchRes.Hits {
-		msg := models.Message{}
+		messages = append(messages, hitToMessage(hit))
+	}
 
-		// Convert map[string]interface{} to Message struct
-		if messageID, ok := hit.(map[string]interface{})["message_id"].(float64); ok {
-			msg.MessageID = int64(messageID)
-		}
-		if chatID, ok := hit.(map[string]interface{})["chat_id"].(float64); ok {
-			msg.ChatID = int64(chatID)
-		}
-		if userID, ok := hit.(map[string]interface{})["user_id"].(float64); ok {
-			msg.UserID = int64(userID)
-		}
-		if username, ok := hit.(map[string]interface{})["username"].(string); ok {
-			msg.Username = username
+	return messages, nil
+}
+
+// SearchChats runs a search across several chats' indexes at once and
+// returns up to searchReq.Limit hits, newest first
+func (m *MeiliSearch) SearchChats(ctx context.Context, chatIDs []int64, searchReq *meilisearch.SearchRequest) (_ []models.Message, err error) {
+	ctx, done := observe(ctx, "search_chats")
+	defer func() { done(err) }()
+
+	// Chats that were never indexed have nothing to find, and a missing
+	// index would fail the whole search
+	queries := make([]meilisearch.SearchRequest, 0, len(chatIDs))
+	for _, chatID := range chatIDs {
+		indexName := m.getGroupIndex(chatID)
+		exists, err := m.existingIndex(ctx, indexName)
+		if err != nil {
+			return nil, err
 		}
-		if text, ok := hit.(map[string]interface{})["text"].(string); ok {
-			msg.Text = text
+		if !exists {
+			continue
 		}
-		if timestamp, ok := hit.(map[string]interface{})["created_at"].(float64); ok {
-			msg.CreatedAt = time.Unix(int64(timestamp), 0)
+		query := *searchReq
+		query.IndexUID = indexName
+		queries = append(queries, query)
+	}
+	if len(queries) == 0 {
+		return nil, nil
+	}
+
+	searchRes, err := await(ctx, func() (*meilisearch.MultiSearchResponse, error) {
+		return m.client.MultiSearch(&meilisearch.MultiSearchRequest{Queries: queries})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %v", err)
+	}
+
+	var messages []models.Message
+	for _, result := range searchRes.Results {
+		for _, hit := range result.Hits {
+			messages = append(messages, hitToMessage(hit))
 		}
+	}
 
-		messages = append(messages, msg)
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.After(messages[j].CreatedAt)
+	})
+	if searchReq.Limit > 0 && int64(len(messages)) > searchReq.Limit {
+		messages = messages[:searchReq.Limit]
 	}
+	return messages, nil
+}
 
+// Find searches several chats for messages matching a parsed query and
+// returns up to limit of them, newest first
+func (m *MeiliSearch) Find(ctx context.Context, chatIDs []int64, q query.Query, limit int64) ([]models.Message, error) {
+	searchReq := &meilisearch.SearchRequest{
+		Query:                q.Text(),
+		Limit:                limit,
+		AttributesToSearchOn: []string{"text"},
+		Sort:                 []string{"created_at:desc"},
+	}
+	if q.From != "" {
+		searchReq.Filter = fmt.Sprintf("username = %q", q.From)
+	}
+	// Meilisearch can't leave words out, so fetch extra hits to filter here
+	if len(q.Excluded) > 0 {
+		searchReq.Limit = limit * 3
+	}
+
+	messages, err := m.SearchChats(ctx, chatIDs, searchReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(q.Excluded) > 0 {
+		kept := messages[:0]
+		for _, msg := range messages {
+			if !q.Excludes(msg.Text) {
+				kept = append(kept, msg)
+			}
+		}
+		messages = kept
+		if int64(len(messages)) > limit {
+			messages = messages[:limit]
+		}
+	}
 	return messages, nil
 }
 
+// hitToMessage converts a search hit back into a message
+func hitToMessage(hit interface{}) models.Message {
+	msg := models.Message{}
+	fields, _ := hit.(map[string]interface{})
+
+	if messageID, ok := fields["message_id"].(float64); ok {
+		msg.MessageID = int64(messageID)
+	}
+	if chatID, ok := fields["chat_id"].(float64); ok {
+		msg.ChatID = int64(chatID)
+	}
+	if userID, ok := fields["user_id"].(float64); ok {
+		msg.UserID = int64(userID)
+	}
+	if username, ok := fields["username"].(string); ok {
+		msg.Username = username
+	}
+	if displayName, ok := fields["display_name"].(string); ok {
+		msg.DisplayName = displayName
+	}
+	if text, ok := fields["text"].(string); ok {
+		msg.Text = text
+	}
+	if timestamp, ok := fields["created_at"].(float64); ok {
+		msg.CreatedAt = time.Unix(int64(timestamp), 0)
+	}
+	return msg
+}
+
 // fetchMessageContext fetches messages before and after each message to provide conversation context
 func (m *MeiliSearch) fetchMessageContext(messages []models.Message) ([]models.Message, error) {
 	const contextWindow = 30 * time.Second // Reduced from 2 minutes to 30 seconds for tighter context
@@ -234,6 +661,9 @@ func (m *MeiliSearch) fetchMessageContext(messages []models.Message) ([]models.M
 				if username, ok := doc["username"].(string); ok {
 					message.Username = username
 				}
+				if displayName, ok := doc["display_name"].(string); ok {
+					message.DisplayName = displayName
+				}
 				if text, ok := doc["text"].(string); ok {
 					message.Text = text
 				}
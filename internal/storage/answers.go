@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"SearchBot/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// answersCollection holds /ask answers and their ratings
+const answersCollection = "answers"
+
+// getAnswersCollection returns the answers collection
+func (s *MongoDB) getAnswersCollection() *mongo.Collection {
+	return s.client.Database(s.database).Collection(answersCollection)
+}
+
+// SaveAnswer stores an answer and sets its ID
+func (s *MongoDB) SaveAnswer(ctx context.Context, answer *models.Answer) (err error) {
+	ctx, done := observe(ctx, "save_answer")
+	defer func() { done(err) }()
+
+	result, err := s.getAnswersCollection().InsertOne(ctx, answer)
+	if err != nil {
+		return fmt.Errorf("failed to store answer: %v", err)
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		answer.ID = id
+	}
+	return nil
+}
+
+// RateAnswer records a user's rating of an answer, replacing their earlier
+// rating, and returns the updated answer, or nil if it doesn't exist
+func (s *MongoDB) RateAnswer(ctx context.Context, id primitive.ObjectID, rating models.AnswerRating) (_ *models.Answer, err error) {
+	ctx, done := observe(ctx, "rate_answer")
+	defer func() { done(err) }()
+
+	// Drop the user's earlier rating and add the new one in a single update,
+	// so concurrent ratings by the same user never leave two behind
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"ratings": bson.M{"$concatArrays": bson.A{
+			bson.M{"$filter": bson.M{
+				"input": bson.M{"$ifNull": bson.A{"$ratings", bson.A{}}},
+				"cond":  bson.M{"$ne": bson.A{"$$this.user_id", rating.UserID}},
+			}},
+			bson.A{bson.M{"$literal": rating}},
+		}},
+	}}}}
+
+	var answer models.Answer
+	err = s.getAnswersCollection().FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&answer)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to rate answer: %v", err)
+	}
+	return &answer, nil
+}
+
+// DeleteAnswersBefore deletes the answers created before a given time that
+// were asked in a chat or chosen from its messages, and returns how many
+// were deleted
+func (s *MongoDB) DeleteAnswersBefore(ctx context.Context, chatID int64, before time.Time) (_ int64, err error) {
+	ctx, done := observe(ctx, "delete_answers_before")
+	defer func() { done(err) }()
+
+	result, err := s.getAnswersCollection().DeleteMany(ctx, bson.M{
+		"created_at": bson.M{"$lt": before},
+		"$or": bson.A{
+			bson.M{"chat_id": chatID},
+			bson.M{"candidates.chat_id": chatID},
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete answers: %v", err)
+	}
+	return result.DeletedCount, nil
+}
+
+// StreamRatedAnswers calls fn for every answer with at least one rating,
+// oldest first. It stops at the first error returned by fn and returns it.
+func (s *MongoDB) StreamRatedAnswers(ctx context.Context, fn func(*models.Answer) error) (err error) {
+	ctx, done := observe(ctx, "stream_rated_answers")
+	defer func() { done(err) }()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetBatchSize(streamBatchSize)
+	cursor, err := s.getAnswersCollection().Find(ctx, bson.M{"ratings.0": bson.M{"$exists": true}}, opts)
+	if err != nil {
+		return fmt.Errorf("failed to fetch answers: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var answer models.Answer
+		if err := cursor.Decode(&answer); err != nil {
+			return fmt.Errorf("failed to decode answer: %v", err)
+		}
+		if err := fn(&answer); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to read answers: %v", err)
+	}
+	return nil
+}
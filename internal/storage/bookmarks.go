@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"SearchBot/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// bookmarksCollection holds the messages users saved
+const bookmarksCollection = "bookmarks"
+
+// getBookmarksCollection returns the bookmarks collection
+func (s *MongoDB) getBookmarksCollection() *mongo.Collection {
+	return s.client.Database(s.database).Collection(bookmarksCollection)
+}
+
+// SaveBookmark stores a bookmark. Saving a message again adds the new tags
+// to the existing bookmark.
+func (s *MongoDB) SaveBookmark(ctx context.Context, bookmark *models.Bookmark) (err error) {
+	ctx, done := observe(ctx, "save_bookmark")
+	defer func() { done(err) }()
+
+	filter := bson.M{
+		"user_id":    bookmark.UserID,
+		"chat_id":    bookmark.ChatID,
+		"message_id": bookmark.MessageID,
+	}
+	tags := bookmark.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	update := bson.M{
+		"$setOnInsert": bson.M{"created_at": bookmark.CreatedAt},
+		"$addToSet":    bson.M{"tags": bson.M{"$each": tags}},
+	}
+	opts := options.Update().SetUpsert(true)
+
+	if _, err := s.getBookmarksCollection().UpdateOne(ctx, filter, update, opts); err != nil {
+		return fmt.Errorf("failed to store bookmark: %v", err)
+	}
+	return nil
+}
+
+// GetBookmarks retrieves a user's bookmarks, newest first
+func (s *MongoDB) GetBookmarks(ctx context.Context, userID int64) (_ []models.Bookmark, err error) {
+	ctx, done := observe(ctx, "get_bookmarks")
+	defer func() { done(err) }()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+	cursor, err := s.getBookmarksCollection().Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch bookmarks: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var bookmarks []models.Bookmark
+	if err := cursor.All(ctx, &bookmarks); err != nil {
+		return nil, fmt.Errorf("failed to decode bookmarks: %v", err)
+	}
+	return bookmarks, nil
+}
+
+// DeleteBookmark deletes a user's bookmark of a message and reports whether
+// it existed
+func (s *MongoDB) DeleteBookmark(ctx context.Context, userID, chatID, messageID int64) (_ bool, err error) {
+	ctx, done := observe(ctx, "delete_bookmark")
+	defer func() { done(err) }()
+
+	result, err := s.getBookmarksCollection().DeleteOne(ctx, bson.M{
+		"user_id":    userID,
+		"chat_id":    chatID,
+		"message_id": messageID,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete bookmark: %v", err)
+	}
+	return result.DeletedCount > 0, nil
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"SearchBot/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexQueueCollection holds messages that are stored but not yet indexed
+const indexQueueCollection = "index_queue"
+
+// queuedMessage is a message waiting in the index queue
+type queuedMessage struct {
+	models.Message `bson:",inline"`
+	EnqueuedAt     time.Time `bson:"enqueued_at"`
+}
+
+// getIndexQueueCollection returns the index queue collection
+func (s *MongoDB) getIndexQueueCollection() *mongo.Collection {
+	return s.client.Database(s.database).Collection(indexQueueCollection)
+}
+
+// EnqueueForIndexing persists messages until they are confirmed indexed
+func (s *MongoDB) EnqueueForIndexing(ctx context.Context, msgs []models.Message) (err error) {
+	ctx, done := observe(ctx, "enqueue_for_indexing")
+	defer func() { done(err) }()
+
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	collection := s.getIndexQueueCollection()
+
+	now := time.Now()
+	writes := make([]mongo.WriteModel, 0, len(msgs))
+	for _, msg := range msgs {
+		msg.ID = primitive.NilObjectID // The queue has its own document IDs
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{
+				"message_id": msg.MessageID,
+				"chat_id":    msg.ChatID,
+			}).
+			SetUpdate(bson.M{"$set": queuedMessage{Message: msg, EnqueuedAt: now}}).
+			SetUpsert(true))
+	}
+
+	if _, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return fmt.Errorf("failed to enqueue messages: %v", err)
+	}
+
+	return nil
+}
+
+// PendingForIndexing returns the oldest messages still waiting to be indexed
+func (s *MongoDB) PendingForIndexing(ctx context.Context, limit int64) (_ []models.Message, err error) {
+	ctx, done := observe(ctx, "pending_for_indexing")
+	defer func() { done(err) }()
+
+	collection := s.getIndexQueueCollection()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "enqueued_at", Value: 1}}).
+		SetLimit(limit)
+
+	cursor, err := collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch queued messages: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var queued []queuedMessage
+	if err := cursor.All(ctx, &queued); err != nil {
+		return nil, fmt.Errorf("failed to decode queued messages: %v", err)
+	}
+
+	messages := make([]models.Message, 0, len(queued))
+	for _, q := range queued {
+		q.Message.ID = primitive.NilObjectID
+		messages = append(messages, q.Message)
+	}
+	return messages, nil
+}
+
+// AckIndexed removes messages that were confirmed indexed from the queue.
+// A message is only removed if its queued version is the one that was
+// indexed, so an edit queued while the original was being indexed stays
+// queued.
+func (s *MongoDB) AckIndexed(ctx context.Context, chatID int64, msgs []models.Message) (err error) {
+	ctx, done := observe(ctx, "ack_indexed")
+	defer func() { done(err) }()
+
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	collection := s.getIndexQueueCollection()
+
+	writes := make([]mongo.WriteModel, 0, len(msgs))
+	for _, msg := range msgs {
+		writes = append(writes, mongo.NewDeleteOneModel().SetFilter(bson.M{
+			"chat_id":      chatID,
+			"message_id":   msg.MessageID,
+			"username":     msg.Username,
+			"text":         msg.Text,
+			"display_name": optionalField(msg.DisplayName, msg.DisplayName == ""),
+			"edited_at":    optionalField(msg.EditedAt, msg.EditedAt.IsZero()),
+		}))
+	}
+
+	if _, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return fmt.Errorf("failed to ack indexed messages: %v", err)
+	}
+
+	return nil
+}
+
+// optionalField matches a field stored with omitempty: missing if the value
+// is empty, and equal to value otherwise
+func optionalField(value interface{}, empty bool) interface{} {
+	if empty {
+		return bson.M{"$exists": false}
+	}
+	return value
+}
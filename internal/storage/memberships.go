@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// membershipsCollection records which groups each user was seen in
+const membershipsCollection = "memberships"
+
+// getMembershipsCollection returns the memberships collection
+func (s *MongoDB) getMembershipsCollection() *mongo.Collection {
+	return s.client.Database(s.database).Collection(membershipsCollection)
+}
+
+// AddMembership records that a user was seen in a chat
+func (s *MongoDB) AddMembership(ctx context.Context, userID, chatID int64, at time.Time) (err error) {
+	ctx, done := observe(ctx, "add_membership")
+	defer func() { done(err) }()
+
+	_, err = s.getMembershipsCollection().UpdateOne(ctx,
+		bson.M{"user_id": userID, "chat_id": chatID},
+		bson.M{"$max": bson.M{"seen_at": at}},
+		options.Update().SetUpsert(true))
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("failed to store membership: %v", err)
+	}
+	return nil
+}
+
+// RemoveMembership forgets that a user belongs to a chat
+func (s *MongoDB) RemoveMembership(ctx context.Context, userID, chatID int64) (err error) {
+	ctx, done := observe(ctx, "remove_membership")
+	defer func() { done(err) }()
+
+	if _, err := s.getMembershipsCollection().DeleteOne(ctx, bson.M{"user_id": userID, "chat_id": chatID}); err != nil {
+		return fmt.Errorf("failed to delete membership: %v", err)
+	}
+	return nil
+}
+
+// GetMemberChats returns the chats a user was seen in and hasn't left since
+func (s *MongoDB) GetMemberChats(ctx context.Context, userID int64) (_ []int64, err error) {
+	ctx, done := observe(ctx, "get_member_chats")
+	defer func() { done(err) }()
+
+	opts := options.Find().SetProjection(bson.M{"chat_id": 1}).SetSort(bson.D{{Key: "chat_id", Value: 1}})
+	cursor, err := s.getMembershipsCollection().Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch memberships: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var memberships []struct {
+		ChatID int64 `bson:"chat_id"`
+	}
+	if err := cursor.All(ctx, &memberships); err != nil {
+		return nil, fmt.Errorf("failed to decode memberships: %v", err)
+	}
+
+	chatIDs := make([]int64, len(memberships))
+	for i, membership := range memberships {
+		chatIDs[i] = membership.ChatID
+	}
+	return chatIDs, nil
+}
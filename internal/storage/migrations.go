@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"SearchBot/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrationsCollection records which schema migrations have been applied
+const migrationsCollection = "schema_migrations"
+
+// migrationBatchSize is how many documents a migration writes at once
+const migrationBatchSize = 1000
+
+// migration moves the database from one schema version to the next. Every
+// migration must be safe to run again if it was interrupted.
+type migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, s *MongoDB) error
+}
+
+// migrations are applied in order. Never change or reorder a released one,
+// add a new version instead.
+var migrations = []migration{
+	{1, "move per-group message collections into a single collection", migrateToSingleCollection},
+	{2, "record the groups users were seen in from their stored messages", migrateMemberships},
+}
+
+// appliedMigration is the record of a migration that has run
+type appliedMigration struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// getMigrationsCollection returns the applied migrations collection
+func (s *MongoDB) getMigrationsCollection() *mongo.Collection {
+	return s.client.Database(s.database).Collection(migrationsCollection)
+}
+
+// migrate applies the schema migrations that haven't run yet, in order
+func (s *MongoDB) migrate(ctx context.Context) error {
+	collection := s.getMigrationsCollection()
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return fmt.Errorf("failed to fetch applied migrations: %v", err)
+	}
+	var records []appliedMigration
+	if err := cursor.All(ctx, &records); err != nil {
+		return fmt.Errorf("failed to decode applied migrations: %v", err)
+	}
+	applied := make(map[int]bool, len(records))
+	for _, record := range records {
+		applied[record.Version] = true
+	}
+
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+
+		slog.InfoContext(ctx, "Applying schema migration", "version", m.Version, "description", m.Description)
+		start := time.Now()
+		if err := m.Up(ctx, s); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %v", m.Version, m.Description, err)
+		}
+
+		_, err := collection.InsertOne(ctx, appliedMigration{
+			Version:     m.Version,
+			Description: m.Description,
+			AppliedAt:   time.Now(),
+		})
+		// Another instance may have applied it at the same time
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("failed to record migration %d: %v", m.Version, err)
+		}
+		slog.InfoContext(ctx, "Applied schema migration", "version", m.Version, "duration", time.Since(start))
+	}
+
+	return nil
+}
+
+// movingSuffix marks a group collection that is being moved
+const movingSuffix = "_moving"
+
+// migrateToSingleCollection moves the messages of every "<base>_group_<chat
+// id>" collection into the shared messages collection and drops the old
+// collections. Messages already moved are overwritten, so an interrupted run
+// can simply be repeated. The shared collection's indexes exist already, see
+// EnsureSchema.
+func migrateToSingleCollection(ctx context.Context, s *MongoDB) error {
+	_, err := s.MoveGroupCollections(ctx)
+	return err
+}
+
+// MoveGroupCollections moves the messages of every "<base>_group_<chat id>"
+// collection into the shared messages collection and returns how many it
+// moved. Older versions still running during a rolling upgrade recreate
+// these collections, so the reconciler calls it again after migration 1.
+// Each collection is renamed before it is copied, so messages an older
+// version writes meanwhile land in a new collection for the next run
+// instead of being dropped with the copied one.
+func (s *MongoDB) MoveGroupCollections(ctx context.Context) (_ int, err error) {
+	ctx, done := observe(ctx, "move_group_collections")
+	defer func() { done(err) }()
+
+	messages := s.getMessagesCollection()
+
+	database := s.client.Database(s.database)
+	prefix := s.baseCollectionName + "_group_"
+	names, err := database.ListCollectionNames(ctx, bson.M{
+		"name": bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list group collections: %v", err)
+	}
+
+	// Finish interrupted moves first, their names are needed again below
+	sort.Slice(names, func(i, j int) bool {
+		return strings.HasSuffix(names[i], movingSuffix) && !strings.HasSuffix(names[j], movingSuffix)
+	})
+
+	var total int
+	for _, name := range names {
+		chatID, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, prefix), movingSuffix), 10, 64)
+		if err != nil {
+			slog.WarnContext(ctx, "Skipping unexpected collection", "collection", name)
+			continue
+		}
+
+		from := name
+		if !strings.HasSuffix(name, movingSuffix) {
+			from = name + movingSuffix
+			rename := bson.D{
+				{Key: "renameCollection", Value: s.database + "." + name},
+				{Key: "to", Value: s.database + "." + from},
+			}
+			if err := s.client.Database("admin").RunCommand(ctx, rename).Err(); err != nil {
+				return total, fmt.Errorf("failed to rename %s: %v", name, err)
+			}
+		}
+
+		moved, err := moveGroupCollection(ctx, database.Collection(from), messages, chatID)
+		total += moved
+		if err != nil {
+			return total, fmt.Errorf("failed to move %s: %v", name, err)
+		}
+		slog.InfoContext(ctx, "Moved group collection", "collection", name, "chat_id", chatID, "messages", moved)
+	}
+
+	return total, nil
+}
+
+// moveGroupCollection copies a group collection's messages into the shared
+// collection in batches, drops it and returns how many messages were copied
+func moveGroupCollection(ctx context.Context, from, to *mongo.Collection, chatID int64) (int, error) {
+	cursor, err := from.Find(ctx, bson.M{}, options.Find().SetBatchSize(migrationBatchSize))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read messages: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var moved int
+	writes := make([]mongo.WriteModel, 0, migrationBatchSize)
+	flush := func() error {
+		if len(writes) == 0 {
+			return nil
+		}
+		if _, err := to.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			return fmt.Errorf("failed to write messages: %v", err)
+		}
+		moved += len(writes)
+		writes = writes[:0]
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		var msg models.Message
+		if err := cursor.Decode(&msg); err != nil {
+			return moved, fmt.Errorf("failed to decode message: %v", err)
+		}
+		// The shared collection assigns its own IDs, and the collection name
+		// is the authority on which chat a message belongs to
+		msg.ID = primitive.NilObjectID
+		msg.ChatID = chatID
+
+		writes = append(writes, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"chat_id": chatID, "message_id": msg.MessageID}).
+			SetReplacement(msg).
+			SetUpsert(true))
+		if len(writes) == migrationBatchSize {
+			if err := flush(); err != nil {
+				return moved, err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return moved, fmt.Errorf("failed to read messages: %v", err)
+	}
+	if err := flush(); err != nil {
+		return moved, err
+	}
+
+	if err := from.Drop(ctx); err != nil {
+		return moved, fmt.Errorf("failed to drop collection: %v", err)
+	}
+	return moved, nil
+}
+
+// migrateMemberships fills the membership index with the groups each user
+// has stored messages in, so private /search and /ask find them without
+// waiting for the users to write again. Memberships already recorded keep
+// their newer seen_at, so running it again changes nothing.
+func migrateMemberships(ctx context.Context, s *MongoDB) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": bson.M{"$gt": 0}, "chat_id": bson.M{"$lt": 0}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     bson.M{"user_id": "$user_id", "chat_id": "$chat_id"},
+			"seen_at": bson.M{"$max": "$created_at"},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":     0,
+			"user_id": "$_id.user_id",
+			"chat_id": "$_id.chat_id",
+			"seen_at": 1,
+		}}},
+		{{Key: "$merge", Value: bson.M{
+			"into":           membershipsCollection,
+			"on":             bson.A{"user_id", "chat_id"},
+			"whenMatched":    "keepExisting",
+			"whenNotMatched": "insert",
+		}}},
+	}
+	cursor, err := s.getMessagesCollection().Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return fmt.Errorf("failed to record memberships: %v", err)
+	}
+	return cursor.Close(ctx)
+}
@@ -3,14 +3,17 @@ package storage
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
+	"SearchBot/internal/metrics"
 	"SearchBot/internal/models"
+	"SearchBot/internal/tracing"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // MongoDB implements MessageStorage interface
@@ -20,8 +23,8 @@ type MongoDB struct {
 	baseCollectionName string
 }
 
-// NewMongoDB creates a new MongoDB instance
-func NewMongoDB(uri, database, baseCollectionName string) (*MongoDB, error) {
+// NewMongoDB connects to MongoDB, giving up when ctx is done
+func NewMongoDB(ctx context.Context, uri, database, baseCollectionName string) (*MongoDB, error) {
 	// Create client options with longer timeouts for Atlas
 	clientOptions := options.Client().
 		ApplyURI(uri).
@@ -29,11 +32,7 @@ func NewMongoDB(uri, database, baseCollectionName string) (*MongoDB, error) {
 		SetConnectTimeout(30 * time.Second).
 		SetSocketTimeout(30 * time.Second)
 
-	log.Printf("Attempting to connect to MongoDB...")
-
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	slog.Debug("Attempting to connect to MongoDB")
 
 	// Connect to MongoDB
 	client, err := mongo.Connect(ctx, clientOptions)
@@ -45,12 +44,12 @@ func NewMongoDB(uri, database, baseCollectionName string) (*MongoDB, error) {
 	if err := client.Ping(ctx, nil); err != nil {
 		// Try to disconnect if ping fails
 		if disconnectErr := client.Disconnect(ctx); disconnectErr != nil {
-			log.Printf("Warning: Failed to disconnect after ping failure: %v", disconnectErr)
+			slog.Warn("Failed to disconnect after ping failure", "error", disconnectErr)
 		}
 		return nil, fmt.Errorf("failed to ping MongoDB: %v", err)
 	}
 
-	log.Printf("Successfully connected to MongoDB")
+	slog.Debug("Successfully connected to MongoDB")
 
 	return &MongoDB{
 		client:             client,
@@ -59,18 +58,69 @@ func NewMongoDB(uri, database, baseCollectionName string) (*MongoDB, error) {
 	}, nil
 }
 
-// getGroupCollection returns the collection for a specific group
-func (s *MongoDB) getGroupCollection(chatID int64) *mongo.Collection {
-	collectionName := fmt.Sprintf("%s_group_%d", s.baseCollectionName, chatID)
-	return s.client.Database(s.database).Collection(collectionName)
+// getMessagesCollection returns the collection holding the messages of all
+// chats, keyed by chat_id
+func (s *MongoDB) getMessagesCollection() *mongo.Collection {
+	return s.client.Database(s.database).Collection(s.baseCollectionName)
+}
+
+// observe starts a span for a MongoDB operation and returns a function that
+// ends it and records the operation's latency and outcome
+func observe(ctx context.Context, operation string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "mongodb."+operation,
+		attribute.String("db.system", "mongodb"),
+		attribute.String("db.operation", operation))
+	return ctx, func(err error) {
+		metrics.ObserveStorage(operation, start, err)
+		tracing.End(span, err)
+	}
+}
+
+// GetChatIDs returns the IDs of all chats with stored messages
+func (s *MongoDB) GetChatIDs(ctx context.Context) (_ []int64, err error) {
+	ctx, done := observe(ctx, "get_chat_ids")
+	defer func() { done(err) }()
+
+	values, err := s.getMessagesCollection().Distinct(ctx, "chat_id", bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chats: %v", err)
+	}
+
+	chatIDs := make([]int64, 0, len(values))
+	for _, value := range values {
+		switch chatID := value.(type) {
+		case int64:
+			chatIDs = append(chatIDs, chatID)
+		case int32:
+			chatIDs = append(chatIDs, int64(chatID))
+		default:
+			slog.WarnContext(ctx, "Skipping unexpected chat ID", "chat_id", value)
+		}
+	}
+
+	return chatIDs, nil
+}
+
+// CountMessages returns the number of stored messages for a specific chat
+func (s *MongoDB) CountMessages(ctx context.Context, chatID int64) (_ int64, err error) {
+	ctx, done := observe(ctx, "count_messages")
+	defer func() { done(err) }()
+
+	count, err := s.getMessagesCollection().CountDocuments(ctx, bson.M{"chat_id": chatID})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count messages: %v", err)
+	}
+
+	return count, nil
 }
 
 // StoreMessage stores a message in MongoDB
-func (s *MongoDB) StoreMessage(msg *models.Message) error {
-	collection := s.getGroupCollection(msg.ChatID)
+func (s *MongoDB) StoreMessage(ctx context.Context, msg *models.Message) (err error) {
+	ctx, done := observe(ctx, "store_message")
+	defer func() { done(err) }()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	collection := s.getMessagesCollection()
 
 	// Try to update existing message
 	filter := bson.M{
@@ -86,42 +136,62 @@ func (s *MongoDB) StoreMessage(msg *models.Message) error {
 		return fmt.Errorf("failed to store message: %v", err)
 	}
 
+	metrics.MessagesStored.Inc()
 	if result.UpsertedCount > 0 {
-		log.Printf("Inserted new message: %d", msg.MessageID)
+		slog.Debug("Inserted new message", "chat_id", msg.ChatID, "message_id", msg.MessageID)
 	} else if result.ModifiedCount > 0 {
-		log.Printf("Updated existing message: %d", msg.MessageID)
+		slog.Debug("Updated existing message", "chat_id", msg.ChatID, "message_id", msg.MessageID)
 	}
 
 	return nil
 }
 
-// GetMessagesByChat retrieves messages for a specific chat
-func (s *MongoDB) GetMessagesByChat(chatID int64) ([]models.Message, error) {
-	collection := s.getGroupCollection(chatID)
+// ImportMessages stores messages from a chat export and returns the ones
+// that weren't stored yet. Messages already stored are left as they are,
+// as they were received live and know more than the export, e.g. the
+// sender's @username.
+func (s *MongoDB) ImportMessages(ctx context.Context, msgs []models.Message) (_ []models.Message, err error) {
+	ctx, done := observe(ctx, "import_messages")
+	defer func() { done(err) }()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+
+	writes := make([]mongo.WriteModel, 0, len(msgs))
+	for _, msg := range msgs {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{
+				"message_id": msg.MessageID,
+				"chat_id":    msg.ChatID,
+			}).
+			SetUpdate(bson.M{"$setOnInsert": msg}).
+			SetUpsert(true))
+	}
 
-	cursor, err := collection.Find(ctx, bson.M{})
+	result, err := s.getMessagesCollection().BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch messages: %v", err)
+		return nil, fmt.Errorf("failed to import messages: %v", err)
 	}
-	defer cursor.Close(ctx)
 
-	var messages []models.Message
-	if err := cursor.All(ctx, &messages); err != nil {
-		return nil, fmt.Errorf("failed to decode messages: %v", err)
+	// UpsertedIDs is keyed by the index of the write that inserted
+	inserted := make([]models.Message, 0, len(result.UpsertedIDs))
+	for i, msg := range msgs {
+		if _, ok := result.UpsertedIDs[int64(i)]; ok {
+			inserted = append(inserted, msg)
+		}
 	}
+	metrics.MessagesStored.Add(float64(len(inserted)))
 
-	return messages, nil
+	return inserted, nil
 }
 
 // GetMessage retrieves a specific message
-func (s *MongoDB) GetMessage(chatID int64, messageID int64) (*models.Message, error) {
-	collection := s.getGroupCollection(chatID)
+func (s *MongoDB) GetMessage(ctx context.Context, chatID int64, messageID int64) (_ *models.Message, err error) {
+	ctx, done := observe(ctx, "get_message")
+	defer func() { done(err) }()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	collection := s.getMessagesCollection()
 
 	filter := bson.M{
 		"message_id": messageID,
@@ -129,7 +199,7 @@ func (s *MongoDB) GetMessage(chatID int64, messageID int64) (*models.Message, er
 	}
 
 	var message models.Message
-	err := collection.FindOne(ctx, filter).Decode(&message)
+	err = collection.FindOne(ctx, filter).Decode(&message)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, nil
@@ -140,47 +210,101 @@ func (s *MongoDB) GetMessage(chatID int64, messageID int64) (*models.Message, er
 	return &message, nil
 }
 
-func (s *MongoDB) Close() error {
-	if s.client != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		return s.client.Disconnect(ctx)
-	}
-	return nil
+// MessageKey identifies a stored message
+type MessageKey struct {
+	ChatID    int64
+	MessageID int64
 }
 
-// GetRecentMessages retrieves recent messages from a specific group
-func (s *MongoDB) GetRecentMessages(groupID int64, limit int64) ([]models.Message, error) {
-	collection := s.getGroupCollection(groupID)
+// GetMessages retrieves several messages at once. Messages that aren't
+// stored are missing from the result.
+func (s *MongoDB) GetMessages(ctx context.Context, keys []MessageKey) (_ map[MessageKey]models.Message, err error) {
+	ctx, done := observe(ctx, "get_messages")
+	defer func() { done(err) }()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	messages := make(map[MessageKey]models.Message, len(keys))
+	if len(keys) == 0 {
+		return messages, nil
+	}
 
-	opts := options.Find().
-		SetSort(bson.D{{Key: "created_at", Value: -1}}).
-		SetLimit(limit)
+	// One clause per chat, so each uses the chat_id, message_id index
+	byChat := make(map[int64][]int64)
+	for _, key := range keys {
+		byChat[key.ChatID] = append(byChat[key.ChatID], key.MessageID)
+	}
+	clauses := make(bson.A, 0, len(byChat))
+	for chatID, messageIDs := range byChat {
+		clauses = append(clauses, bson.M{"chat_id": chatID, "message_id": bson.M{"$in": messageIDs}})
+	}
 
-	cursor, err := collection.Find(ctx, bson.M{}, opts)
+	cursor, err := s.getMessagesCollection().Find(ctx, bson.M{"$or": clauses})
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch messages: %v", err)
 	}
 	defer cursor.Close(ctx)
 
-	var messages []models.Message
-	if err = cursor.All(ctx, &messages); err != nil {
-		return nil, fmt.Errorf("failed to decode messages: %v", err)
+	for cursor.Next(ctx) {
+		var message models.Message
+		if err := cursor.Decode(&message); err != nil {
+			return nil, fmt.Errorf("failed to decode message: %v", err)
+		}
+		messages[MessageKey{ChatID: message.ChatID, MessageID: message.MessageID}] = message
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read messages: %v", err)
 	}
 	return messages, nil
 }
 
+// Ping checks that MongoDB is reachable
+func (s *MongoDB) Ping(ctx context.Context) error {
+	if err := s.client.Ping(ctx, nil); err != nil {
+		return fmt.Errorf("failed to ping MongoDB: %v", err)
+	}
+	return nil
+}
+
+// Close disconnects from MongoDB, waiting for in-use connections until ctx is done
+func (s *MongoDB) Close(ctx context.Context) error {
+	if s.client != nil {
+		return s.client.Disconnect(ctx)
+	}
+	return nil
+}
+
+// GetRecentMessages retrieves the newest messages of a specific group, newest first
+func (s *MongoDB) GetRecentMessages(ctx context.Context, groupID int64, limit int64) ([]models.Message, error) {
+	page, err := s.GetMessagesPage(ctx, PageQuery{ChatID: groupID, Limit: limit, Direction: Backward})
+	if err != nil {
+		return nil, err
+	}
+	return page.Messages, nil
+}
+
+// DeleteMessagesBefore deletes a group's messages created before a given time
+func (s *MongoDB) DeleteMessagesBefore(ctx context.Context, groupID int64, before time.Time) (_ int64, err error) {
+	ctx, done := observe(ctx, "delete_messages_before")
+	defer func() { done(err) }()
+
+	result, err := s.getMessagesCollection().DeleteMany(ctx, bson.M{
+		"chat_id":    groupID,
+		"created_at": bson.M{"$lt": before},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete messages: %v", err)
+	}
+	return result.DeletedCount, nil
+}
+
 // GetMessagesByTimeRange retrieves messages within a time range from a specific group
-func (s *MongoDB) GetMessagesByTimeRange(groupID int64, start, end time.Time) ([]models.Message, error) {
-	collection := s.getGroupCollection(groupID)
+func (s *MongoDB) GetMessagesByTimeRange(ctx context.Context, groupID int64, start, end time.Time) (_ []models.Message, err error) {
+	ctx, done := observe(ctx, "get_messages_by_time_range")
+	defer func() { done(err) }()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	collection := s.getMessagesCollection()
 
 	filter := bson.M{
+		"chat_id": groupID,
 		"created_at": bson.M{
 			"$gte": start,
 			"$lte": end,
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"SearchBot/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// streamBatchSize is how many messages StreamMessages fetches per round trip
+const streamBatchSize = 500
+
+// Direction is the order in which a chat's history is read
+type Direction int
+
+const (
+	Forward  Direction = iota // Oldest first
+	Backward                  // Newest first
+)
+
+// Cursor marks a position in a chat's history. Messages are ordered by
+// creation time and then by message ID, so the position is unambiguous even
+// when several messages share a timestamp. The zero Cursor is the start of
+// the history when reading forward and its end when reading backward.
+type Cursor struct {
+	CreatedAt time.Time `json:"created_at"`
+	MessageID int64     `json:"message_id"`
+}
+
+// IsZero reports whether c is the zero Cursor
+func (c Cursor) IsZero() bool {
+	return c.CreatedAt.IsZero() && c.MessageID == 0
+}
+
+// CursorOf returns the position of msg in its chat's history
+func CursorOf(msg *models.Message) Cursor {
+	return Cursor{CreatedAt: msg.CreatedAt, MessageID: msg.MessageID}
+}
+
+// PageQuery selects a page of a chat's messages
+type PageQuery struct {
+	ChatID    int64
+	After     Cursor // Return messages after this position, exclusive
+	Limit     int64
+	Direction Direction
+}
+
+// Page is a slice of a chat's history
+type Page struct {
+	Messages []models.Message
+	Next     Cursor // Pass as After to get the following page
+	More     bool   // Whether there are messages after Next
+}
+
+// cursorFilter selects a chat's messages after cursor in the given direction
+func cursorFilter(chatID int64, after Cursor, direction Direction) bson.M {
+	if after.IsZero() {
+		return bson.M{"chat_id": chatID}
+	}
+	op := "$gt"
+	if direction == Backward {
+		op = "$lt"
+	}
+	return bson.M{
+		"chat_id": chatID,
+		"$or": bson.A{
+			bson.M{"created_at": bson.M{op: after.CreatedAt}},
+			bson.M{"created_at": after.CreatedAt, "message_id": bson.M{op: after.MessageID}},
+		},
+	}
+}
+
+// cursorSort orders a chat's messages for reading in the given direction,
+// matching the chat_id, created_at, message_id index
+func cursorSort(direction Direction) bson.D {
+	order := 1
+	if direction == Backward {
+		order = -1
+	}
+	return bson.D{{Key: "created_at", Value: order}, {Key: "message_id", Value: order}}
+}
+
+// GetMessagesPage returns up to query.Limit messages of a chat after the
+// query's cursor
+func (s *MongoDB) GetMessagesPage(ctx context.Context, query PageQuery) (_ *Page, err error) {
+	ctx, done := observe(ctx, "get_messages_page")
+	defer func() { done(err) }()
+
+	if query.Limit <= 0 {
+		return nil, fmt.Errorf("page limit must be positive, got %d", query.Limit)
+	}
+
+	// Fetch one extra message to learn whether there is another page
+	opts := options.Find().
+		SetSort(cursorSort(query.Direction)).
+		SetLimit(query.Limit + 1)
+
+	cursor, err := s.getMessagesCollection().Find(ctx, cursorFilter(query.ChatID, query.After, query.Direction), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch messages: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	page := &Page{}
+	if err := cursor.All(ctx, &page.Messages); err != nil {
+		return nil, fmt.Errorf("failed to decode messages: %v", err)
+	}
+
+	if int64(len(page.Messages)) > query.Limit {
+		page.Messages = page.Messages[:query.Limit]
+		page.More = true
+	}
+	page.Next = query.After
+	if len(page.Messages) > 0 {
+		page.Next = CursorOf(&page.Messages[len(page.Messages)-1])
+	}
+
+	return page, nil
+}
+
+// StreamMessages calls fn for each of a chat's messages in the given
+// direction without loading them all into memory. It stops at the first
+// error returned by fn and returns it.
+func (s *MongoDB) StreamMessages(ctx context.Context, chatID int64, direction Direction, fn func(*models.Message) error) (err error) {
+	ctx, done := observe(ctx, "stream_messages")
+	defer func() { done(err) }()
+
+	opts := options.Find().
+		SetSort(cursorSort(direction)).
+		SetBatchSize(streamBatchSize)
+
+	cursor, err := s.getMessagesCollection().Find(ctx, cursorFilter(chatID, Cursor{}, direction), opts)
+	if err != nil {
+		return fmt.Errorf("failed to fetch messages: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var msg models.Message
+		if err := cursor.Decode(&msg); err != nil {
+			return fmt.Errorf("failed to decode message: %v", err)
+		}
+		if err := fn(&msg); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to read messages: %v", err)
+	}
+
+	return nil
+}
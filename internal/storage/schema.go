@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// schemaCollection holds the document recording the database's schema version
+const schemaCollection = "schema_version"
+
+// schemaDocumentID identifies the schema version document
+const schemaDocumentID = "schema"
+
+// SchemaVersion is the schema version this build creates and understands
+var SchemaVersion = migrations[len(migrations)-1].Version
+
+// schemaDocument records which schema version the database is at
+type schemaDocument struct {
+	ID        string    `bson:"_id"`
+	Version   int       `bson:"version"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// collectionIndexes are the indexes a collection needs
+type collectionIndexes struct {
+	collection *mongo.Collection
+	indexes    []mongo.IndexModel
+}
+
+// requiredIndexes returns the indexes the queries in this package rely on
+func (s *MongoDB) requiredIndexes() []collectionIndexes {
+	unique := options.Index().SetUnique(true)
+	return []collectionIndexes{
+		{s.getMessagesCollection(), []mongo.IndexModel{
+			{Keys: bson.D{{Key: "chat_id", Value: 1}, {Key: "message_id", Value: 1}}, Options: unique},
+			{Keys: bson.D{{Key: "chat_id", Value: 1}, {Key: "created_at", Value: 1}, {Key: "message_id", Value: 1}}},
+		}},
+		{s.getSettingsCollection(), []mongo.IndexModel{
+			{Keys: bson.D{{Key: "chat_id", Value: 1}}, Options: unique},
+		}},
+		{s.getUsageCollection(), []mongo.IndexModel{
+			{Keys: bson.D{{Key: "chat_id", Value: 1}, {Key: "day", Value: 1}}, Options: unique},
+		}},
+		{s.getIndexQueueCollection(), []mongo.IndexModel{
+			{Keys: bson.D{{Key: "chat_id", Value: 1}, {Key: "message_id", Value: 1}}, Options: unique},
+			{Keys: bson.D{{Key: "enqueued_at", Value: 1}}},
+		}},
+		{s.getBookmarksCollection(), []mongo.IndexModel{
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "chat_id", Value: 1}, {Key: "message_id", Value: 1}}, Options: unique},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		}},
+		{s.getAnswersCollection(), []mongo.IndexModel{
+			{Keys: bson.D{{Key: "created_at", Value: 1}}},
+			{Keys: bson.D{{Key: "chat_id", Value: 1}, {Key: "created_at", Value: 1}}},
+			{Keys: bson.D{{Key: "candidates.chat_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		}},
+		{s.getMembershipsCollection(), []mongo.IndexModel{
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "chat_id", Value: 1}}, Options: unique},
+		}},
+		{s.getWatchesCollection(), []mongo.IndexModel{
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		}},
+	}
+}
+
+// EnsureSchema prepares the database for this build: it refuses databases
+// written by a newer version, creates missing indexes, applies pending
+// migrations and records the resulting schema version
+func (s *MongoDB) EnsureSchema(ctx context.Context) (err error) {
+	ctx, done := observe(ctx, "ensure_schema")
+	defer func() { done(err) }()
+
+	version, err := s.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("database schema version %d is newer than version %d supported by this build, "+
+			"run a newer version of the bot", version, SchemaVersion)
+	}
+
+	for _, required := range s.requiredIndexes() {
+		if _, err := required.collection.Indexes().CreateMany(ctx, required.indexes); err != nil {
+			return fmt.Errorf("failed to create indexes on %s: %v", required.collection.Name(), err)
+		}
+	}
+
+	if err := s.migrate(ctx); err != nil {
+		return err
+	}
+
+	if version < SchemaVersion {
+		// $max keeps a newer version recorded by another instance
+		_, err := s.getSchemaCollection().UpdateOne(ctx,
+			bson.M{"_id": schemaDocumentID},
+			bson.M{
+				"$max": bson.M{"version": SchemaVersion},
+				"$set": bson.M{"updated_at": time.Now()},
+			},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("failed to record schema version: %v", err)
+		}
+		slog.InfoContext(ctx, "Updated database schema", "from_version", version, "to_version", SchemaVersion)
+	}
+
+	return nil
+}
+
+// getSchemaCollection returns the schema version collection
+func (s *MongoDB) getSchemaCollection() *mongo.Collection {
+	return s.client.Database(s.database).Collection(schemaCollection)
+}
+
+// schemaVersion returns the recorded schema version, 0 for a database that
+// predates versioning
+func (s *MongoDB) schemaVersion(ctx context.Context) (int, error) {
+	var doc schemaDocument
+	err := s.getSchemaCollection().FindOne(ctx, bson.M{"_id": schemaDocumentID}).Decode(&doc)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to fetch schema version: %v", err)
+	}
+	return doc.Version, nil
+}
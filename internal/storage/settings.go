@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"SearchBot/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// settingsCollection holds one settings document per chat
+const settingsCollection = "chat_settings"
+
+// getSettingsCollection returns the chat settings collection
+func (s *MongoDB) getSettingsCollection() *mongo.Collection {
+	return s.client.Database(s.database).Collection(settingsCollection)
+}
+
+// GetChatSettings retrieves a chat's settings, or the defaults if it has none
+func (s *MongoDB) GetChatSettings(ctx context.Context, chatID int64) (_ *models.ChatSettings, err error) {
+	ctx, done := observe(ctx, "get_chat_settings")
+	defer func() { done(err) }()
+
+	collection := s.getSettingsCollection()
+
+	// Start from the defaults so fields added later get sensible values
+	settings := models.DefaultChatSettings(chatID)
+	err = collection.FindOne(ctx, bson.M{"chat_id": chatID}).Decode(settings)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return models.DefaultChatSettings(chatID), nil
+		}
+		return nil, fmt.Errorf("failed to fetch chat settings: %v", err)
+	}
+
+	return settings, nil
+}
+
+// SetChatSetting changes one of a chat's settings, named by its field in
+// the settings document (e.g. "retention_days" or "commands.ask"), and
+// returns the updated settings. Only that field is written, so admins
+// changing different settings at the same time don't undo each other.
+func (s *MongoDB) SetChatSetting(ctx context.Context, chatID int64, field string, value interface{}, updatedBy int64) (_ *models.ChatSettings, err error) {
+	ctx, done := observe(ctx, "set_chat_setting")
+	defer func() { done(err) }()
+
+	collection := s.getSettingsCollection()
+
+	filter := bson.M{"chat_id": chatID}
+	update := bson.M{"$set": bson.M{
+		field:        value,
+		"updated_at": time.Now(),
+		"updated_by": updatedBy,
+	}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	// Fields the chat never changed keep their defaults
+	settings := models.DefaultChatSettings(chatID)
+	if err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(settings); err != nil {
+		return nil, fmt.Errorf("failed to store chat setting: %v", err)
+	}
+
+	return settings, nil
+}
+
+// ListChatSettings retrieves the settings of every chat that changed them
+func (s *MongoDB) ListChatSettings(ctx context.Context) (_ []models.ChatSettings, err error) {
+	ctx, done := observe(ctx, "list_chat_settings")
+	defer func() { done(err) }()
+
+	collection := s.getSettingsCollection()
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch chat settings: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	// Documents only hold the settings a chat changed, start from the defaults
+	var settings []models.ChatSettings
+	for cursor.Next(ctx) {
+		chatSettings := models.DefaultChatSettings(0)
+		if err := cursor.Decode(chatSettings); err != nil {
+			return nil, fmt.Errorf("failed to decode chat settings: %v", err)
+		}
+		settings = append(settings, *chatSettings)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch chat settings: %v", err)
+	}
+
+	return settings, nil
+}
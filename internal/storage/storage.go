@@ -2,14 +2,80 @@ package storage
 
 import (
 	"SearchBot/internal/models"
+	"context"
 	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// MessageStorage defines the interface for message storage
+// MessageStorage defines the interface for message storage. Like the other
+// storage interfaces it sets no timeouts of its own: every call is bounded
+// only by the deadline and cancellation of the context passed in.
 type MessageStorage interface {
-	StoreMessage(msg *models.Message) error
-	GetMessagesByChat(chatID int64) ([]models.Message, error)
-	GetMessage(chatID int64, messageID int64) (*models.Message, error)
-	GetRecentMessages(chatID int64, limit int64) ([]models.Message, error)
-	GetMessagesByTimeRange(chatID int64, start, end time.Time) ([]models.Message, error)
+	StoreMessage(ctx context.Context, msg *models.Message) error
+	ImportMessages(ctx context.Context, msgs []models.Message) ([]models.Message, error)
+	GetMessage(ctx context.Context, chatID int64, messageID int64) (*models.Message, error)
+	GetMessages(ctx context.Context, keys []MessageKey) (map[MessageKey]models.Message, error)
+	GetRecentMessages(ctx context.Context, chatID int64, limit int64) ([]models.Message, error)
+	GetMessagesByTimeRange(ctx context.Context, chatID int64, start, end time.Time) ([]models.Message, error)
+	GetMessagesPage(ctx context.Context, query PageQuery) (*Page, error)
+	StreamMessages(ctx context.Context, chatID int64, direction Direction, fn func(*models.Message) error) error
+	GetChatIDs(ctx context.Context) ([]int64, error)
+	CountMessages(ctx context.Context, chatID int64) (int64, error)
+	DeleteMessagesBefore(ctx context.Context, chatID int64, before time.Time) (int64, error)
+	MoveGroupCollections(ctx context.Context) (int, error)
+}
+
+// SettingsStorage defines the interface for per-chat settings storage
+type SettingsStorage interface {
+	GetChatSettings(ctx context.Context, chatID int64) (*models.ChatSettings, error)
+	SetChatSetting(ctx context.Context, chatID int64, field string, value interface{}, updatedBy int64) (*models.ChatSettings, error)
+	ListChatSettings(ctx context.Context) ([]models.ChatSettings, error)
+}
+
+// IndexQueue defines the interface for the durable queue of messages
+// waiting to be indexed in the search backend
+type IndexQueue interface {
+	EnqueueForIndexing(ctx context.Context, msgs []models.Message) error
+	PendingForIndexing(ctx context.Context, limit int64) ([]models.Message, error)
+	AckIndexed(ctx context.Context, chatID int64, msgs []models.Message) error
+}
+
+// UsageStorage defines the interface for per-chat AI usage accounting
+type UsageStorage interface {
+	RecordAIUsage(ctx context.Context, chatID int64, at time.Time, usage models.AIUsage) error
+	ReserveAIUsage(ctx context.Context, chatID int64, at time.Time, tokens, quota int64) (bool, error)
+	GetAIUsage(ctx context.Context, chatID int64, start, end time.Time) ([]models.DailyUsage, error)
+}
+
+// MembershipStorage defines the interface for the index of which groups
+// each user belongs to
+type MembershipStorage interface {
+	AddMembership(ctx context.Context, userID, chatID int64, at time.Time) error
+	RemoveMembership(ctx context.Context, userID, chatID int64) error
+	GetMemberChats(ctx context.Context, userID int64) ([]int64, error)
+}
+
+// WatchStorage defines the interface for the users' saved searches
+type WatchStorage interface {
+	AddWatch(ctx context.Context, watch *models.Watch) error
+	GetWatches(ctx context.Context, userID int64) ([]models.Watch, error)
+	ListWatches(ctx context.Context) ([]models.Watch, error)
+	DeleteWatch(ctx context.Context, userID int64, id primitive.ObjectID) (bool, error)
+	ClaimWatch(ctx context.Context, id primitive.ObjectID, now time.Time, throttle time.Duration) (bool, error)
+}
+
+// BookmarkStorage defines the interface for the users' saved messages
+type BookmarkStorage interface {
+	SaveBookmark(ctx context.Context, bookmark *models.Bookmark) error
+	GetBookmarks(ctx context.Context, userID int64) ([]models.Bookmark, error)
+	DeleteBookmark(ctx context.Context, userID, chatID, messageID int64) (bool, error)
+}
+
+// AnswerStorage defines the interface for /ask answers and their ratings
+type AnswerStorage interface {
+	SaveAnswer(ctx context.Context, answer *models.Answer) error
+	RateAnswer(ctx context.Context, id primitive.ObjectID, rating models.AnswerRating) (*models.Answer, error)
+	DeleteAnswersBefore(ctx context.Context, chatID int64, before time.Time) (int64, error)
+	StreamRatedAnswers(ctx context.Context, fn func(*models.Answer) error) error
 }
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"SearchBot/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// usageCollection holds one AI usage document per chat and day
+const usageCollection = "ai_usage"
+
+// dayFormat is how days are stored in usage documents
+const dayFormat = "2006-01-02"
+
+// getUsageCollection returns the AI usage collection
+func (s *MongoDB) getUsageCollection() *mongo.Collection {
+	return s.client.Database(s.database).Collection(usageCollection)
+}
+
+// RecordAIUsage adds usage to a chat's total for the day of at
+func (s *MongoDB) RecordAIUsage(ctx context.Context, chatID int64, at time.Time, usage models.AIUsage) (err error) {
+	ctx, done := observe(ctx, "record_ai_usage")
+	defer func() { done(err) }()
+
+	collection := s.getUsageCollection()
+
+	filter := bson.M{"chat_id": chatID, "day": at.UTC().Format(dayFormat)}
+	update := bson.M{"$inc": bson.M{
+		"requests":        usage.Requests,
+		"prompt_tokens":   usage.PromptTokens,
+		"response_tokens": usage.ResponseTokens,
+		"total_tokens":    usage.TotalTokens,
+	}}
+	opts := options.Update().SetUpsert(true)
+
+	if _, err := collection.UpdateOne(ctx, filter, update, opts); err != nil {
+		return fmt.Errorf("failed to record AI usage: %v", err)
+	}
+
+	return nil
+}
+
+// ReserveAIUsage adds tokens to a chat's total for the day of at if the
+// total stays within quota, and reports whether it did. The check and the
+// addition are one update, so parallel calls can't overspend the quota.
+func (s *MongoDB) ReserveAIUsage(ctx context.Context, chatID int64, at time.Time, tokens, quota int64) (_ bool, err error) {
+	ctx, done := observe(ctx, "reserve_ai_usage")
+	defer func() { done(err) }()
+
+	if tokens > quota {
+		return false, nil
+	}
+
+	collection := s.getUsageCollection()
+
+	// A day with too little quota left doesn't match, so the upsert tries to
+	// insert a second document for it and hits the unique index
+	filter := bson.M{
+		"chat_id":      chatID,
+		"day":          at.UTC().Format(dayFormat),
+		"total_tokens": bson.M{"$lte": quota - tokens},
+	}
+	update := bson.M{"$inc": bson.M{"total_tokens": tokens}}
+	opts := options.Update().SetUpsert(true)
+
+	if _, err := collection.UpdateOne(ctx, filter, update, opts); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to reserve AI usage: %v", err)
+	}
+
+	return true, nil
+}
+
+// GetAIUsage retrieves a chat's daily AI usage from the day of start to the
+// day of end, oldest first. Days without usage are left out.
+func (s *MongoDB) GetAIUsage(ctx context.Context, chatID int64, start, end time.Time) (_ []models.DailyUsage, err error) {
+	ctx, done := observe(ctx, "get_ai_usage")
+	defer func() { done(err) }()
+
+	collection := s.getUsageCollection()
+
+	filter := bson.M{
+		"chat_id": chatID,
+		"day": bson.M{
+			"$gte": start.UTC().Format(dayFormat),
+			"$lte": end.UTC().Format(dayFormat),
+		},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "day", Value: 1}})
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch AI usage: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var usage []models.DailyUsage
+	if err := cursor.All(ctx, &usage); err != nil {
+		return nil, fmt.Errorf("failed to decode AI usage: %v", err)
+	}
+
+	return usage, nil
+}
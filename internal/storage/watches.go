@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"SearchBot/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// watchesCollection holds the users' saved searches
+const watchesCollection = "watches"
+
+// getWatchesCollection returns the watches collection
+func (s *MongoDB) getWatchesCollection() *mongo.Collection {
+	return s.client.Database(s.database).Collection(watchesCollection)
+}
+
+// AddWatch stores a new watch and sets its ID
+func (s *MongoDB) AddWatch(ctx context.Context, watch *models.Watch) (err error) {
+	ctx, done := observe(ctx, "add_watch")
+	defer func() { done(err) }()
+
+	result, err := s.getWatchesCollection().InsertOne(ctx, watch)
+	if err != nil {
+		return fmt.Errorf("failed to store watch: %v", err)
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		watch.ID = id
+	}
+	return nil
+}
+
+// GetWatches retrieves a user's watches, oldest first
+func (s *MongoDB) GetWatches(ctx context.Context, userID int64) (_ []models.Watch, err error) {
+	ctx, done := observe(ctx, "get_watches")
+	defer func() { done(err) }()
+
+	return s.findWatches(ctx, bson.M{"user_id": userID})
+}
+
+// ListWatches retrieves every user's watches
+func (s *MongoDB) ListWatches(ctx context.Context) (_ []models.Watch, err error) {
+	ctx, done := observe(ctx, "list_watches")
+	defer func() { done(err) }()
+
+	return s.findWatches(ctx, bson.M{})
+}
+
+// findWatches retrieves the watches matching filter, oldest first
+func (s *MongoDB) findWatches(ctx context.Context, filter bson.M) ([]models.Watch, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := s.getWatchesCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch watches: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var watches []models.Watch
+	if err := cursor.All(ctx, &watches); err != nil {
+		return nil, fmt.Errorf("failed to decode watches: %v", err)
+	}
+	return watches, nil
+}
+
+// DeleteWatch deletes one of a user's watches and reports whether it existed
+func (s *MongoDB) DeleteWatch(ctx context.Context, userID int64, id primitive.ObjectID) (_ bool, err error) {
+	ctx, done := observe(ctx, "delete_watch")
+	defer func() { done(err) }()
+
+	result, err := s.getWatchesCollection().DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete watch: %v", err)
+	}
+	return result.DeletedCount > 0, nil
+}
+
+// ClaimWatch reports whether a watch may notify its user at now, given that
+// it notifies at most once per throttle, and if so records that it did. The
+// check and the update are one operation, so when several instances match
+// the same message only one of them notifies. Deleted watches are never
+// claimed.
+func (s *MongoDB) ClaimWatch(ctx context.Context, id primitive.ObjectID, now time.Time, throttle time.Duration) (_ bool, err error) {
+	ctx, done := observe(ctx, "claim_watch")
+	defer func() { done(err) }()
+
+	filter := bson.M{
+		"_id": id,
+		"$or": bson.A{
+			bson.M{"last_notified_at": bson.M{"$exists": false}},
+			bson.M{"last_notified_at": bson.M{"$lte": now.Add(-throttle)}},
+		},
+	}
+	result, err := s.getWatchesCollection().UpdateOne(ctx, filter, bson.M{"$set": bson.M{"last_notified_at": now}})
+	if err != nil {
+		return false, fmt.Errorf("failed to update watch: %v", err)
+	}
+	return result.ModifiedCount > 0, nil
+}
@@ -0,0 +1,170 @@
+package watch
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"SearchBot/internal/models"
+	"SearchBot/internal/query"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// entry is a watch with its parsed query
+type entry struct {
+	watch models.Watch
+	query query.Query
+	key   string
+}
+
+// Index matches new messages against all active watches. Each watch is
+// filed under one word it requires, so a message is only checked against the
+// watches filed under its own words instead of against every watch.
+type Index struct {
+	mu      sync.Mutex
+	entries map[primitive.ObjectID]*entry
+	byKey   map[string]map[primitive.ObjectID]*entry
+}
+
+// NewIndex creates an empty Index
+func NewIndex() *Index {
+	return &Index{
+		entries: make(map[primitive.ObjectID]*entry),
+		byKey:   make(map[string]map[primitive.ObjectID]*entry),
+	}
+}
+
+// Add adds a watch, replacing any watch with the same ID. Watches whose
+// query can't match anything are ignored.
+func (i *Index) Add(watch models.Watch) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.add(watch)
+}
+
+// Replace replaces all watches, e.g. with the ones currently stored, so
+// watches added or removed by other instances are picked up
+func (i *Index) Replace(watches []models.Watch) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.entries = make(map[primitive.ObjectID]*entry, len(watches))
+	i.byKey = make(map[string]map[primitive.ObjectID]*entry)
+	for _, watch := range watches {
+		i.add(watch)
+	}
+}
+
+// add adds a watch, the caller must hold i.mu
+func (i *Index) add(watch models.Watch) {
+	q := query.Parse(watch.Query)
+	key := indexKey(q)
+	if key == "" {
+		return
+	}
+
+	i.remove(watch.ID)
+	e := &entry{watch: watch, query: q, key: key}
+	i.entries[watch.ID] = e
+	if i.byKey[key] == nil {
+		i.byKey[key] = make(map[primitive.ObjectID]*entry)
+	}
+	i.byKey[key][watch.ID] = e
+}
+
+// Remove removes a watch
+func (i *Index) Remove(id primitive.ObjectID) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.remove(id)
+}
+
+// remove removes a watch, the caller must hold i.mu
+func (i *Index) remove(id primitive.ObjectID) {
+	e, ok := i.entries[id]
+	if !ok {
+		return
+	}
+	delete(i.entries, id)
+	delete(i.byKey[e.key], id)
+	if len(i.byKey[e.key]) == 0 {
+		delete(i.byKey, e.key)
+	}
+}
+
+// Len returns the number of active watches
+func (i *Index) Len() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return len(i.entries)
+}
+
+// Match returns the watches a message matches
+func (i *Index) Match(msg *models.Message) []models.Watch {
+	keys := query.Words(msg.Text)
+	if msg.Username != "" {
+		keys = append(keys, "@"+strings.ToLower(msg.Username))
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var matches []models.Watch
+	seen := make(map[primitive.ObjectID]bool)
+	for _, key := range keys {
+		for id, e := range i.byKey[key] {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			if e.query.Matches(msg.Text, msg.Username) {
+				matches = append(matches, e.watch)
+			}
+		}
+	}
+	return matches
+}
+
+// Throttled reports whether a watch is known to have notified its user
+// within throttle before now. It saves asking the database about watches
+// that notified recently; other instances may have notified since.
+func (i *Index) Throttled(id primitive.ObjectID, now time.Time, throttle time.Duration) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	e, ok := i.entries[id]
+	return !ok || now.Sub(e.watch.LastNotifiedAt) < throttle
+}
+
+// SetNotified records when a watch last notified its user
+func (i *Index) SetNotified(id primitive.ObjectID, at time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if e, ok := i.entries[id]; ok {
+		e.watch.LastNotifiedAt = at
+	}
+}
+
+// indexKey picks the word a watch is filed under: its longest required
+// word, which is likely the rarest, or its author for from:-only watches
+func indexKey(q query.Query) string {
+	var key string
+	for _, term := range q.Terms {
+		if len(term) > len(key) {
+			key = term
+		}
+	}
+	for _, phrase := range q.Phrases {
+		for _, word := range query.Words(phrase) {
+			if len(word) > len(key) {
+				key = word
+			}
+		}
+	}
+	if key == "" && q.From != "" {
+		key = "@" + strings.ToLower(q.From)
+	}
+	return key
+}
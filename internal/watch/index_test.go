@@ -0,0 +1,119 @@
+package watch
+
+import (
+	"testing"
+	"time"
+
+	"SearchBot/internal/models"
+	"SearchBot/internal/query"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestIndexKey(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "go kubernetes", want: "kubernetes"},
+		{query: `go "helm chart"`, want: "chart"},
+		{query: "docker-compose up", want: "compose"},
+		{query: "go from:Alice", want: "go"},
+		{query: "from:@Alice", want: "@alice"},
+		{query: "-spam", want: ""},
+		{query: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := indexKey(query.Parse(tt.query)); got != tt.want {
+				t.Errorf("indexKey(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+// ids returns the IDs of watches
+func ids(watches []models.Watch) map[primitive.ObjectID]bool {
+	set := make(map[primitive.ObjectID]bool)
+	for _, watch := range watches {
+		set[watch.ID] = true
+	}
+	return set
+}
+
+func TestIndexMatch(t *testing.T) {
+	kubernetes := models.Watch{ID: primitive.NewObjectID(), Query: "kubernetes -helm"}
+	phrase := models.Watch{ID: primitive.NewObjectID(), Query: `"pod restarts"`}
+	fromAlice := models.Watch{ID: primitive.NewObjectID(), Query: "from:alice"}
+	excludeOnly := models.Watch{ID: primitive.NewObjectID(), Query: "-spam"}
+
+	i := NewIndex()
+	for _, watch := range []models.Watch{kubernetes, phrase, fromAlice, excludeOnly} {
+		i.Add(watch)
+	}
+	if n := i.Len(); n != 3 {
+		t.Fatalf("index holds %d watches, want 3 without the one that can't match", n)
+	}
+
+	tests := []struct {
+		name string
+		msg  models.Message
+		want []models.Watch
+	}{
+		{name: "word", msg: models.Message{Text: "Kubernetes pod restarts again"}, want: []models.Watch{kubernetes, phrase}},
+		{name: "excluded word", msg: models.Message{Text: "kubernetes helm chart"}},
+		{name: "author", msg: models.Message{Text: "hello", Username: "Alice"}, want: []models.Watch{fromAlice}},
+		{name: "imported without username", msg: models.Message{Text: "hello", DisplayName: "alice"}},
+		{name: "no match", msg: models.Message{Text: "nothing to see"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := i.Match(&tt.msg)
+			if len(got) != len(tt.want) {
+				t.Fatalf("matched %d watches, want %d", len(got), len(tt.want))
+			}
+			matched := ids(got)
+			for _, watch := range tt.want {
+				if !matched[watch.ID] {
+					t.Errorf("watch %q didn't match", watch.Query)
+				}
+			}
+		})
+	}
+
+	i.Remove(kubernetes.ID)
+	if got := i.Match(&models.Message{Text: "kubernetes"}); len(got) != 0 {
+		t.Errorf("removed watch still matched")
+	}
+
+	i.Replace([]models.Watch{kubernetes})
+	if got := i.Match(&models.Message{Text: "hello", Username: "alice"}); len(got) != 0 {
+		t.Errorf("replaced watch still matched")
+	}
+	if got := i.Match(&models.Message{Text: "kubernetes"}); len(got) != 1 {
+		t.Errorf("watch added by Replace didn't match")
+	}
+}
+
+func TestIndexThrottled(t *testing.T) {
+	now := time.Now()
+	watch := models.Watch{ID: primitive.NewObjectID(), Query: "kubernetes", LastNotifiedAt: now.Add(-2 * time.Hour)}
+
+	i := NewIndex()
+	i.Add(watch)
+
+	if i.Throttled(watch.ID, now, time.Hour) {
+		t.Error("watch that notified two hours ago is throttled")
+	}
+	i.SetNotified(watch.ID, now)
+	if !i.Throttled(watch.ID, now.Add(30*time.Minute), time.Hour) {
+		t.Error("watch that just notified isn't throttled")
+	}
+	if i.Throttled(watch.ID, now.Add(2*time.Hour), time.Hour) {
+		t.Error("watch is still throttled after the throttle passed")
+	}
+	if !i.Throttled(primitive.NewObjectID(), now, time.Hour) {
+		t.Error("unknown watch isn't throttled")
+	}
+}
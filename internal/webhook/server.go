@@ -0,0 +1,162 @@
+package webhook
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// secretHeader is the header Telegram uses to send the webhook secret token
+const secretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// maxUpdateSize bounds the request body of a single update
+const maxUpdateSize = 1 << 20
+
+// validSecret matches the characters Telegram allows in a secret token
+var validSecret = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// ValidSecret reports whether Telegram accepts secret as a webhook secret token
+func ValidSecret(secret string) bool {
+	return validSecret.MatchString(secret)
+}
+
+// SubmitFunc hands a decoded update over for processing
+type SubmitFunc func(ctx context.Context, update tgbotapi.Update) error
+
+// Config holds the webhook settings
+type Config struct {
+	URL            string   // Public HTTPS URL Telegram sends updates to
+	ListenAddr     string   // Address the embedded server listens on
+	SecretToken    string   // Shared secret Telegram sends with every update
+	CertFile       string   // Optional TLS certificate for serving HTTPS directly
+	KeyFile        string   // Optional TLS key for serving HTTPS directly
+	MaxConnections int      // Optional limit of concurrent connections from Telegram
+	AllowedUpdates []string // Update types to receive
+}
+
+// Server receives updates from Telegram over HTTPS
+type Server struct {
+	api    *tgbotapi.BotAPI
+	config Config
+	path   string
+	submit SubmitFunc
+	server *http.Server
+}
+
+// NewServer creates a webhook server that passes updates to submit
+func NewServer(api *tgbotapi.BotAPI, config Config, submit SubmitFunc) (*Server, error) {
+	webhookURL, err := url.Parse(config.URL)
+	if err != nil || webhookURL.Scheme != "https" || webhookURL.Host == "" {
+		return nil, fmt.Errorf("webhook URL must be an absolute https URL, got %q", config.URL)
+	}
+	if !ValidSecret(config.SecretToken) {
+		return nil, fmt.Errorf("webhook secret must be 1-256 characters of A-Z, a-z, 0-9, _ and -")
+	}
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, fmt.Errorf("webhook TLS needs both a certificate and a key file")
+	}
+
+	path := webhookURL.Path
+	if path == "" {
+		path = "/"
+	}
+
+	s := &Server{
+		api:    api,
+		config: config,
+		path:   path,
+		submit: submit,
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, s)
+	s.server = &http.Server{
+		Addr:              config.ListenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return s, nil
+}
+
+// Register tells Telegram to deliver updates to the webhook URL
+func (s *Server) Register() error {
+	params := make(tgbotapi.Params)
+	params["url"] = s.config.URL
+	params["secret_token"] = s.config.SecretToken
+	params.AddNonZero("max_connections", s.config.MaxConnections)
+	if err := params.AddInterface("allowed_updates", s.config.AllowedUpdates); err != nil {
+		return fmt.Errorf("failed to encode allowed updates: %v", err)
+	}
+
+	// The client library predates secret tokens, so call setWebhook directly
+	if _, err := s.api.MakeRequest("setWebhook", params); err != nil {
+		return fmt.Errorf("failed to set webhook: %v", err)
+	}
+
+	slog.Info("Registered webhook", "url", s.config.URL)
+	return nil
+}
+
+// ListenAndServe serves webhook requests until Shutdown is called
+func (s *Server) ListenAndServe() error {
+	slog.Info("Listening for webhook requests", "addr", s.config.ListenAddr, "path", s.path)
+
+	var err error
+	if s.config.CertFile != "" {
+		err = s.server.ListenAndServeTLS(s.config.CertFile, s.config.KeyFile)
+	} else {
+		// TLS is terminated by a load balancer in front of the bot
+		err = s.server.ListenAndServe()
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting requests and waits for in-flight ones to finish
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// ServeHTTP validates and decodes a single update from Telegram
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	secret := r.Header.Get(secretHeader)
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(s.config.SecretToken)) != 1 {
+		slog.Warn("Rejected webhook request with invalid secret token", "remote_addr", r.RemoteAddr)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	var update tgbotapi.Update
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUpdateSize)).Decode(&update); err != nil {
+		slog.Warn("Failed to decode webhook update", "error", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	// Telegram retries the update if we don't answer with 2xx
+	if err := s.submit(r.Context(), update); err != nil {
+		slog.Error("Error dispatching update", "update_id", update.UpdateID, "error", err)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}